@@ -0,0 +1,103 @@
+// Command rulesctl inspects rules files offline.
+//
+// Usage:
+//
+//	rulesctl analyze [-json] <rules-file>
+//
+// analyze reports rules that are shadowed by earlier rules, conflicting
+// allow/block rules at the same priority, duplicate conditions and rules that
+// can never match. It exits with status 1 when it finds problems.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"http-proxy/internal/rules"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "analyze":
+		os.Exit(analyze(os.Args[2:]))
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: rulesctl <command> [options]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  analyze [-json] <rules-file>   report shadowed, conflicting, duplicate and unmatchable rules")
+}
+
+// analyze runs the ruleset analyzer on a rules file and returns the exit status
+func analyze(args []string) int {
+	flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print findings as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: rulesctl analyze [-json] <rules-file>")
+		return 2
+	}
+
+	filename := flags.Arg(0)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read rules file: %v\n", err)
+		return 2
+	}
+	ruleList, err := rules.ParseRulesFile(data, filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	findings := rules.Analyze(ruleList)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if findings == nil {
+			findings = []rules.Finding{}
+		}
+		encoder.Encode(findings)
+	} else {
+		// Map rule IDs to the line they are defined on
+		lines := rules.RuleLines(data, filename, "rules")
+		ruleLines := make(map[string]int, len(ruleList))
+		for i, rule := range ruleList {
+			if _, seen := ruleLines[rule.ID]; !seen && i < len(lines) {
+				ruleLines[rule.ID] = lines[i]
+			}
+		}
+
+		for _, finding := range findings {
+			position := filename
+			if line := ruleLines[finding.RuleID]; line > 0 {
+				position = fmt.Sprintf("%s:%d", filename, line)
+			}
+			fmt.Printf("%s: %s: rule %s: %s\n", position, finding.Kind, finding.RuleID, finding.Message)
+		}
+		fmt.Printf("%d rules analyzed, %d findings\n", len(ruleList), len(findings))
+	}
+
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
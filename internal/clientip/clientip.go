@@ -0,0 +1,140 @@
+// Package clientip derives the address of the client that sent a request
+// through one or more trusted reverse proxies or load balancers.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"http-proxy/internal/ipset"
+)
+
+// Resolver derives client IPs from forwarding headers set by trusted proxies.
+// Headers are only honored when the connecting peer is a trusted proxy, and
+// the forwarding chain is walked right to left: the first hop that is not a
+// trusted proxy is the client. The RFC 7239 Forwarded header takes precedence
+// over X-Forwarded-For, which takes precedence over X-Real-IP.
+type Resolver struct {
+	trusted *ipset.Set
+}
+
+// NewResolver creates a resolver trusting the given CIDR ranges and addresses
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	trusted := ipset.NewSet("trusted_proxies")
+	for _, entry := range trustedProxies {
+		if err := trusted.Add(strings.TrimSpace(entry)); err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// FromRequest returns the derived client IP and the IP of the connecting peer
+func (r *Resolver) FromRequest(req *http.Request) (client net.IP, peer net.IP) {
+	peer = parseHop(req.RemoteAddr)
+	return r.ClientIP(peer, req.Header), peer
+}
+
+// ClientIP returns the client IP of a request received from peer
+func (r *Resolver) ClientIP(peer net.IP, header http.Header) net.IP {
+	if peer == nil || !r.trusted.Contains(peer) {
+		return peer
+	}
+
+	client := peer
+	hops := forwardedHops(header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			// An unknown or obfuscated hop ends the chain we can trust
+			return client
+		}
+		client = hop
+		if !r.trusted.Contains(hop) {
+			return client
+		}
+	}
+	return client
+}
+
+// IsTrusted reports whether ip is a trusted proxy
+func (r *Resolver) IsTrusted(ip net.IP) bool {
+	return ip != nil && r.trusted.Contains(ip)
+}
+
+// forwardedHops returns the forwarding chain from the first header present,
+// ordered from the original client to the nearest proxy
+func forwardedHops(header http.Header) []string {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		var hops []string
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return hops
+	}
+	if value := header.Get("X-Real-IP"); value != "" {
+		return []string{strings.TrimSpace(value)}
+	}
+	return nil
+}
+
+// parseForwarded returns the "for" parameters of RFC 7239 Forwarded headers.
+// Elements without one are kept as empty hops, which end the chain.
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				name, param, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(strings.TrimSpace(name), "for") {
+					hop = strings.Trim(strings.TrimSpace(param), `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s at sep outside of double quotes
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHop parses an address with an optional port, such as "192.0.2.1",
+// "192.0.2.1:8080", "2001:db8::1" or "[2001:db8::1]:8080". It returns nil
+// for "unknown", obfuscated identifiers and other invalid values.
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+
+	ip := net.ParseIP(hop)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
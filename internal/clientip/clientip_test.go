@@ -0,0 +1,73 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{"untrusted peer ignores headers", "198.51.100.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}, "198.51.100.1"},
+		{"trusted peer without headers", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"single forwarded hop", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}, "203.0.113.5"},
+		{"spoofed leftmost hop is skipped", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.5, 10.1.1.1"}}, "203.0.113.5"},
+		{"repeated headers are joined", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.5", "10.1.1.1"}}, "203.0.113.5"},
+		{"all hops trusted", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}}, "10.2.2.2"},
+		{"hop with port", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.5:4711"}}, "203.0.113.5"},
+		{"invalid hop ends the chain", "10.0.0.1:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.5, garbage, 10.1.1.1"}}, "10.1.1.1"},
+		{"real IP header", "192.0.2.10:80", map[string][]string{"X-Real-Ip": {"203.0.113.9"}}, "203.0.113.9"},
+		{
+			"forwarded takes precedence", "10.0.0.1:5000",
+			map[string][]string{"Forwarded": {`for=203.0.113.60;proto=http;by=10.0.0.1`}, "X-Forwarded-For": {"203.0.113.5"}},
+			"203.0.113.60",
+		},
+		{
+			"forwarded with IPv6 and several elements", "[2001:db8::1]:443",
+			map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711", For=10.0.0.5`}},
+			"2001:db8:cafe::17",
+		},
+		{"forwarded unknown hop", "10.0.0.1:5000", map[string][]string{"Forwarded": {"for=unknown, for=10.1.1.1"}}, "10.1.1.1"},
+		{"forwarded obfuscated hop", "10.0.0.1:5000", map[string][]string{"Forwarded": {`for="_hidden"`}}, "10.0.0.1"},
+		{"quoted separators", "10.0.0.1:5000", map[string][]string{"Forwarded": {`for=203.0.113.1;ext="a,b;c"`}}, "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			client, peer := resolver.FromRequest(req)
+			if client.String() != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, client)
+			}
+			if peer == nil {
+				t.Errorf("Expected peer IP for %s", tt.remoteAddr)
+			}
+		})
+	}
+}
+
+func TestNewResolver_InvalidProxy(t *testing.T) {
+	if _, err := NewResolver([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected invalid CIDR range to be rejected")
+	}
+	if _, err := NewResolver([]string{"proxy.local"}); err == nil {
+		t.Error("Expected host name to be rejected")
+	}
+}
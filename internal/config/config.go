@@ -2,12 +2,20 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"http-proxy/internal/clientip"
+	"http-proxy/internal/fileutil"
+	"http-proxy/internal/filewatch"
+	"http-proxy/internal/proxyproto"
+	"http-proxy/internal/rules"
 	"http-proxy/pkg/types"
 
 	"github.com/BurntSushi/toml"
@@ -17,20 +25,60 @@ import (
 // ConfigManager handles configuration loading and management
 type ConfigManager struct {
 	configPath string
+	backups    int
+
+	// mu guards the current config, which the watcher replaces on reload.
+	// reloadMu serializes reloads; lastDigest is the hash of the content
+	// last loaded or saved, so unchanged files and our own writes are skipped.
+	mu         sync.RWMutex
 	config     *types.ProxyConfig
+	reloadMu   sync.Mutex
+	lastDigest string
+	watcher    *filewatch.Watcher
+	onReload   []ReloadFunc
+
+	// Settings are layered: defaults, the file, the environment, then flags
+	flags   *flag.FlagSet
+	sources []FieldSource
+
+	// templates holds the ${VAR} and file:// references of the last load by
+	// field path, to keep resolved values out of saved files and dumps
+	templates map[string]stringTemplate
 }
 
-// NewConfigManager creates a new configuration manager
+// NewConfigManager creates a new configuration manager. An empty path falls
+// back to the PROXY_CONFIG_FILE environment variable.
 func NewConfigManager(configPath string) *ConfigManager {
+	if configPath == "" {
+		configPath = os.Getenv(ConfigFileEnv)
+	}
 	return &ConfigManager{
 		configPath: configPath,
+		backups:    fileutil.DefaultBackups,
 	}
 }
 
-// LoadConfig loads configuration from file based on file extension
+// SetBackups sets how many timestamped backups SaveConfig keeps; zero or less keeps none
+func (cm *ConfigManager) SetBackups(backups int) {
+	cm.backups = backups
+}
+
+// LoadConfig loads configuration from file based on file extension, then
+// applies the environment and flag overrides
 func (cm *ConfigManager) LoadConfig() (*types.ProxyConfig, error) {
+	cm.reloadMu.Lock()
+	defer cm.reloadMu.Unlock()
+
 	if cm.configPath == "" {
-		return cm.getDefaultConfig(), nil
+		parsed, err := cm.resolveConfig(cm.getDefaultConfig(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := cm.validateAndSetDefaults(parsed.config); err != nil {
+			return nil, fmt.Errorf("config validation failed: %w", err)
+		}
+		cm.setConfig(parsed, nil)
+		return parsed.config, nil
 	}
 
 	data, err := os.ReadFile(cm.configPath)
@@ -38,6 +86,25 @@ func (cm *ConfigManager) LoadConfig() (*types.ProxyConfig, error) {
 		return nil, fmt.Errorf("failed to read config file %s: %w", cm.configPath, err)
 	}
 
+	parsed, err := cm.parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cm.setConfig(parsed, data)
+	return parsed.config, nil
+}
+
+// parsedConfig is a loaded configuration with the provenance of its values
+type parsedConfig struct {
+	config    *types.ProxyConfig
+	sources   []FieldSource
+	templates map[string]stringTemplate
+}
+
+// parseConfig parses configuration data in the format of the config file,
+// then interpolates it, applies the overrides and validates the result
+func (cm *ConfigManager) parseConfig(data []byte) (*parsedConfig, error) {
 	config := &types.ProxyConfig{}
 	ext := strings.ToLower(filepath.Ext(cm.configPath))
 
@@ -58,33 +125,83 @@ func (cm *ConfigManager) LoadConfig() (*types.ProxyConfig, error) {
 		return nil, fmt.Errorf("unsupported config file format: %s", ext)
 	}
 
+	parsed, err := cm.resolveConfig(config, fileKeys(data, ext))
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate and set defaults
 	if err := cm.validateAndSetDefaults(config); err != nil {
+		var ruleErrs rules.ValidationErrors
+		if errors.As(err, &ruleErrs) {
+			ruleErrs.WithPositions(cm.configPath, rules.RuleLines(data, cm.configPath, "rules", "rules"))
+		}
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
+	return parsed, nil
+}
+
+// resolveConfig interpolates the strings of a parsed config, applies the
+// overrides and resolves its secret references. tree is the raw config
+// file, if any.
+func (cm *ConfigManager) resolveConfig(config *types.ProxyConfig, tree map[string]interface{}) (*parsedConfig, error) {
+	templates, err := interpolate(config)
+	if err != nil {
+		return nil, err
+	}
 
-	cm.config = config
-	return config, nil
+	sources, err := cm.applyOverrides(config, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolveSecrets(config, templates, filepath.Dir(cm.configPath)); err != nil {
+		return nil, err
+	}
+
+	return &parsedConfig{config: config, sources: sources, templates: templates}, nil
+}
+
+// setConfig makes a parsed config current; data is the file content it came from
+func (cm *ConfigManager) setConfig(parsed *parsedConfig, data []byte) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.config = parsed.config
+	if parsed.sources != nil {
+		cm.sources = parsed.sources
+		cm.templates = parsed.templates
+	}
+	cm.lastDigest = contentDigest(data)
 }
 
-// SaveConfig saves the current configuration to file
+// SaveConfig saves the current configuration to file. Values that came
+// from ${VAR} interpolation or file:// secrets are written back as their
+// references, so the file never holds the resolved values.
 func (cm *ConfigManager) SaveConfig(config *types.ProxyConfig) error {
 	if cm.configPath == "" {
 		return fmt.Errorf("no config path specified")
 	}
 
+	cm.mu.RLock()
+	templates := cm.templates
+	cm.mu.RUnlock()
+
+	redacted, err := restoreTemplates(config, templates)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
 	var data []byte
-	var err error
 
 	ext := strings.ToLower(filepath.Ext(cm.configPath))
 
 	switch ext {
 	case ".yaml", ".yml":
-		data, err = yaml.Marshal(config)
+		data, err = yaml.Marshal(redacted)
 	case ".json":
-		data, err = json.MarshalIndent(config, "", "  ")
+		data, err = json.MarshalIndent(redacted, "", "  ")
 	case ".toml":
-		data, err = toml.Marshal(config)
+		data, err = toml.Marshal(redacted)
 	default:
 		return fmt.Errorf("unsupported config file format: %s", ext)
 	}
@@ -93,16 +210,22 @@ func (cm *ConfigManager) SaveConfig(config *types.ProxyConfig) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(cm.configPath, data, 0644); err != nil {
+	cm.reloadMu.Lock()
+	defer cm.reloadMu.Unlock()
+
+	if err := fileutil.WriteFileWithBackup(cm.configPath, data, 0644, cm.backups); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
-	cm.config = config
+	cm.setConfig(&parsedConfig{config: config}, data)
 	return nil
 }
 
 // GetConfig returns the current configuration
 func (cm *ConfigManager) GetConfig() *types.ProxyConfig {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
 	if cm.config == nil {
 		return cm.getDefaultConfig()
 	}
@@ -157,9 +280,52 @@ func (cm *ConfigManager) validateAndSetDefaults(config *types.ProxyConfig) error
 	if config.Rules.DefaultAction == "" {
 		config.Rules.DefaultAction = types.ActionAllow
 	}
+	if !validAction(config.Rules.DefaultAction) {
+		return fmt.Errorf("invalid default_action: %s", config.Rules.DefaultAction)
+	}
 	if config.Rules.ReloadInterval == 0 {
 		config.Rules.ReloadInterval = 5 * time.Second
 	}
+	if config.Rules.ExpiredRuleAction == "" {
+		config.Rules.ExpiredRuleAction = types.ExpiredRuleRemove
+	}
+	if config.Rules.ExpiredRuleAction != types.ExpiredRuleRemove && config.Rules.ExpiredRuleAction != types.ExpiredRuleDisable {
+		return fmt.Errorf("invalid expired_rule_action: %s", config.Rules.ExpiredRuleAction)
+	}
+	if config.Rules.ExpiryCheckInterval == 0 {
+		config.Rules.ExpiryCheckInterval = time.Second
+	}
+	if config.Rules.FileBackups == 0 {
+		config.Rules.FileBackups = fileutil.DefaultBackups
+	}
+	if config.Rules.HistorySize == 0 {
+		config.Rules.HistorySize = rules.DefaultHistorySize
+	}
+	if config.Rules.BodyInspection.MaxBodySize == 0 {
+		config.Rules.BodyInspection.MaxBodySize = 1 << 20 // 1MB
+	}
+	// An empty body inspection action skips the rule
+	if action := config.Rules.BodyInspection.OversizeAction; action != "" && !validAction(action) {
+		return fmt.Errorf("invalid body_inspection.oversize_action: %s", action)
+	}
+	if action := config.Rules.BodyInspection.ParseErrorAction; action != "" && !validAction(action) {
+		return fmt.Errorf("invalid body_inspection.parse_error_action: %s", action)
+	}
+
+	if _, err := clientip.NewResolver(config.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid server config: %w", err)
+	}
+	if config.Server.ProxyProtocol.Enabled {
+		if len(config.Server.ProxyProtocol.AllowedSources) == 0 {
+			return fmt.Errorf("invalid server config: proxy_protocol requires allowed_sources")
+		}
+		if _, err := proxyproto.NewListener(nil, config.Server.ProxyProtocol.AllowedSources, 0); err != nil {
+			return fmt.Errorf("invalid server config: %w", err)
+		}
+		if config.Server.ProxyProtocol.HeaderTimeout == 0 {
+			config.Server.ProxyProtocol.HeaderTimeout = proxyproto.DefaultHeaderTimeout
+		}
+	}
 
 	// Logging defaults
 	if config.Logging.Level == "" {
@@ -175,6 +341,11 @@ func (cm *ConfigManager) validateAndSetDefaults(config *types.ProxyConfig) error
 		config.Logging.MaxAge = 28 // 28 days
 	}
 
+	// GeoIP defaults
+	if (config.GeoIP.CountryDatabase != "" || config.GeoIP.ASNDatabase != "") && config.GeoIP.ReloadInterval == 0 {
+		config.GeoIP.ReloadInterval = 60 * time.Second
+	}
+
 	// Rate limiting defaults
 	if config.Security.RateLimiting.Enabled {
 		if config.Security.RateLimiting.RequestsPerSec == 0 {
@@ -188,22 +359,19 @@ func (cm *ConfigManager) validateAndSetDefaults(config *types.ProxyConfig) error
 		}
 	}
 
-	// Validate rules
-	for i, rule := range config.Rules.Rules {
-		if rule.ID == "" {
-			return fmt.Errorf("rule at index %d has no ID", i)
-		}
-		if rule.Type == "" {
-			return fmt.Errorf("rule %s has no type", rule.ID)
-		}
-		if rule.Action != types.ActionAllow && rule.Action != types.ActionBlock {
-			return fmt.Errorf("rule %s has invalid action: %s", rule.ID, rule.Action)
-		}
+	// Validate rules; the returned ValidationErrors name every invalid rule
+	if err := rules.ValidateRules(config.Rules.Rules); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// validAction reports whether action is one a request can be decided with
+func validAction(action types.Action) bool {
+	return action == types.ActionAllow || action == types.ActionBlock
+}
+
 // getDefaultConfig returns a default configuration
 func (cm *ConfigManager) getDefaultConfig() *types.ProxyConfig {
 	return &types.ProxyConfig{
@@ -230,6 +398,13 @@ func (cm *ConfigManager) getDefaultConfig() *types.ProxyConfig {
 			DefaultAction:  types.ActionAllow,
 			WatchRulesFile: true,
 			ReloadInterval: 5 * time.Second,
+			BodyInspection: types.BodyInspectionConfig{
+				MaxBodySize: 1 << 20,
+			},
+			ExpiredRuleAction:   types.ExpiredRuleRemove,
+			ExpiryCheckInterval: time.Second,
+			HistorySize:         rules.DefaultHistorySize,
+			FileBackups:         fileutil.DefaultBackups,
 			Rules: []types.Rule{
 				{
 					ID:          "default-allow-all",
@@ -1,13 +1,18 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"http-proxy/internal/logger"
+	"http-proxy/internal/rules"
 	"http-proxy/pkg/types"
 
 	"gopkg.in/yaml.v3"
@@ -318,6 +323,41 @@ func TestConfigManager_LoadConfig_InvalidFormat(t *testing.T) {
 	}
 }
 
+func TestConfigManager_LoadConfig_InvalidRules(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.toml")
+	data := `[rules]
+default_action = "allow"
+
+[[rules.rules]]
+id = "bad-cidr"
+type = "ipv4"
+operator = "in_range"
+value = "10.0.0.0/33"
+action = "block"
+
+[[rules.rules]]
+id = "bad-type"
+type = "cookie"
+action = "block"
+`
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewConfigManager(configFile).LoadConfig()
+	if err == nil {
+		t.Fatal("Expected invalid rules to be rejected")
+	}
+	for _, expected := range []string{
+		configFile + ":4: rule bad-cidr: invalid CIDR range",
+		configFile + ":11: rule bad-type: unknown type",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got: %v", expected, err)
+		}
+	}
+}
+
 func TestConfigManager_SaveConfig(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -455,9 +495,11 @@ func TestConfigManager_ValidateAndSetDefaults(t *testing.T) {
 		Rules: types.RulesConfig{
 			Rules: []types.Rule{
 				{
-					ID:     "valid-rule",
-					Type:   types.RuleTypeURL,
-					Action: types.ActionAllow,
+					ID:       "valid-rule",
+					Type:     types.RuleTypeURL,
+					Operator: types.MatchStartsWith,
+					Value:    "/api",
+					Action:   types.ActionAllow,
 				},
 			},
 		},
@@ -549,3 +591,581 @@ func TestConfigManager_GetConfig(t *testing.T) {
 		t.Errorf("GetConfig should return consistent results")
 	}
 }
+
+func TestConfigManager_LoadConfig_TrustedProxies(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	data := "server:\n  trusted_proxies: [10.0.0.0/8, \"2001:db8::1\"]\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigManager(configFile).LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(config.Server.TrustedProxies) != 2 {
+		t.Errorf("Expected 2 trusted proxies, got %v", config.Server.TrustedProxies)
+	}
+
+	data = "server:\n  trusted_proxies: [10.0.0.0/33]\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfigManager(configFile).LoadConfig(); err == nil || !strings.Contains(err.Error(), "invalid trusted proxy") {
+		t.Errorf("Expected invalid trusted proxy error, got %v", err)
+	}
+}
+
+func TestConfigManager_LoadConfig_ProxyProtocol(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	data := "server:\n  proxy_protocol:\n    enabled: true\n    allowed_sources: [10.0.0.0/8]\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigManager(configFile).LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Server.ProxyProtocol.HeaderTimeout != 5*time.Second {
+		t.Errorf("Expected default header timeout 5s, got %v", config.Server.ProxyProtocol.HeaderTimeout)
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"missing sources", "server:\n  proxy_protocol:\n    enabled: true\n", "requires allowed_sources"},
+		{"invalid source", "server:\n  proxy_protocol:\n    enabled: true\n    allowed_sources: [lb.local]\n", "invalid PROXY protocol source"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configFile, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewConfigManager(configFile).LoadConfig(); err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestConfigManager_SaveConfig_Backups(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	cm := NewConfigManager(configFile)
+	cm.SetBackups(1)
+
+	for _, port := range []int{8081, 8082, 8083} {
+		if err := cm.SaveConfig(&types.ProxyConfig{Server: types.ServerConfig{Port: port}}); err != nil {
+			t.Fatalf("Failed to save config: %v", err)
+		}
+	}
+
+	backups, err := filepath.Glob(configFile + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %v", backups)
+	}
+	data, err := os.ReadFile(backups[0])
+	if err != nil || !strings.Contains(string(data), "8082") {
+		t.Errorf("Expected backup of the previous config, got %s (%v)", data, err)
+	}
+}
+
+func TestConfigManager_Reload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("server:\n  port: 8081\nbackend:\n  port: 9000\nlogging:\n  level: info\n")
+	cm := NewConfigManager(configFile)
+	if _, err := cm.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	var notified []ConfigChange
+	cm.OnReload(func(previous, config *types.ProxyConfig, changes []ConfigChange) {
+		notified = changes
+	})
+
+	// Unchanged content is not reloaded
+	if changes, err := cm.Reload(); err != nil || changes != nil {
+		t.Errorf("Expected no changes, got %v (%v)", changes, err)
+	}
+
+	write("server:\n  port: 9999\nbackend:\n  port: 9001\nlogging:\n  level: debug\nsecurity:\n  rate_limiting:\n    enabled: true\n")
+	changes, err := cm.Reload()
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	expected := []ConfigChange{
+		{Section: "server.listen", RestartRequired: true},
+		{Section: "backend"},
+		{Section: "logging.level"},
+		{Section: "security.rate_limiting"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %+v, got %+v", expected, changes)
+	}
+	if !reflect.DeepEqual(notified, expected) {
+		t.Errorf("Expected subscribers to be notified of %+v, got %+v", expected, notified)
+	}
+
+	config := cm.GetConfig()
+	if config.Server.Port != 8081 {
+		t.Errorf("Expected listen port to stay 8081 until restart, got %d", config.Server.Port)
+	}
+	if config.Backend.Port != 9001 || config.Logging.Level != "debug" || config.Security.RateLimiting.RequestsPerSec != 100 {
+		t.Errorf("Expected reloadable sections to be applied, got %+v", config)
+	}
+
+	// An invalid file keeps the running config
+	write("rules:\n  default_action: allow\n  expired_rule_action: keep\n")
+	if _, err := cm.Reload(); err == nil || !strings.Contains(err.Error(), "expired_rule_action") {
+		t.Errorf("Expected validation error, got %v", err)
+	}
+	if cm.GetConfig() != config {
+		t.Error("Expected the running config to be kept after a failed reload")
+	}
+}
+
+func TestConfigManager_StartWatching(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	if err := os.WriteFile(configFile, []byte("logging:\n  level: info\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewConfigManager(configFile)
+	if _, err := cm.LoadConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	reloaded := make(chan string, 1)
+	cm.OnReload(func(previous, config *types.ProxyConfig, changes []ConfigChange) {
+		reloaded <- config.Logging.Level
+	})
+	if err := cm.StartWatching(50 * time.Millisecond); err != nil {
+		t.Fatalf("Failed to start watching: %v", err)
+	}
+	defer cm.StopWatching()
+
+	if err := os.WriteFile(configFile, []byte("logging:\n  level: warn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case level := <-reloaded:
+		if level != "warn" {
+			t.Errorf("Expected level warn, got %s", level)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for config reload")
+	}
+}
+
+func TestConfigManager_LoadConfig_Layers(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	data := "server:\n  port: 8081\n  host: 0.0.0.0\nbackend:\n  port: 9000\nlogging:\n  level: warn\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PROXY_SERVER_PORT", "8082")
+	t.Setenv("PROXY_BACKEND_TIMEOUT", "5s")
+	t.Setenv("PROXY_SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.0.1")
+	t.Setenv("PROXY_LOG_LEVEL", "error")
+
+	cm := NewConfigManager("")
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	cm.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-config", configFile, "-server.port", "8083", "-log-level", "debug"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	config, err := cm.LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Server.Port != 8083 || config.Server.Host != "0.0.0.0" || config.Backend.Port != 9000 {
+		t.Errorf("Expected flag port 8083 and file host and backend port, got %+v", config.Server)
+	}
+	if config.Backend.Timeout != 5*time.Second || config.Logging.Level != "debug" {
+		t.Errorf("Expected env backend timeout 5s and flag log level debug, got %v and %s", config.Backend.Timeout, config.Logging.Level)
+	}
+	if !reflect.DeepEqual(config.Server.TrustedProxies, []string{"10.0.0.0/8", "192.168.0.1"}) {
+		t.Errorf("Expected trusted proxies from env, got %v", config.Server.TrustedProxies)
+	}
+
+	expected := map[string]FieldSource{
+		"server.port":         {Key: "server.port", Value: "8083", Layer: LayerFlag, Origin: "-server.port"},
+		"server.host":         {Key: "server.host", Value: "0.0.0.0", Layer: LayerFile, Origin: configFile},
+		"backend.timeout":     {Key: "backend.timeout", Value: "5s", Layer: LayerEnv, Origin: "PROXY_BACKEND_TIMEOUT"},
+		"logging.level":       {Key: "logging.level", Value: "debug", Layer: LayerFlag, Origin: "-log-level"},
+		"server.idle_timeout": {Key: "server.idle_timeout", Value: "2m0s", Layer: LayerDefault},
+	}
+	for _, source := range cm.Sources() {
+		if want, exists := expected[source.Key]; exists && source != want {
+			t.Errorf("Expected source %+v, got %+v", want, source)
+		}
+	}
+
+	var dump bytes.Buffer
+	if err := cm.DumpSources(&dump); err != nil {
+		t.Fatalf("Failed to dump sources: %v", err)
+	}
+	if !strings.Contains(dump.String(), "backend.timeout = 5s (env PROXY_BACKEND_TIMEOUT)\n") {
+		t.Errorf("Expected dump to show the env source, got:\n%s", dump.String())
+	}
+}
+
+func TestConfigManager_Sources_SavedBeforeLoad(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	cm := NewConfigManager(configFile)
+	cm.SetBackups(-1)
+	config := cm.getDefaultConfig()
+	config.Server.Port = 8084
+
+	if err := cm.SaveConfig(config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	sources := cm.Sources()
+	if len(sources) != len(configFields(config)) {
+		t.Fatalf("Expected a source for every field, got %d", len(sources))
+	}
+	for _, source := range sources {
+		if source.Key == "server.port" && (source.Value != "8084" || source.Layer != LayerFile || source.Origin != configFile) {
+			t.Errorf("Expected server.port from the saved file, got %+v", source)
+		}
+	}
+
+	if sources := NewConfigManager("").Sources(); sources != nil {
+		t.Errorf("Expected no sources before load, got %d", len(sources))
+	}
+}
+
+func TestConfigManager_LoadConfig_InvalidOverride(t *testing.T) {
+	t.Setenv("PROXY_SERVER_PORT", "eighty")
+
+	_, err := NewConfigManager("").LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "invalid server.port value \"eighty\" from PROXY_SERVER_PORT") {
+		t.Errorf("Expected invalid override error, got %v", err)
+	}
+}
+
+func TestNewConfigManager_ConfigFileEnv(t *testing.T) {
+	t.Setenv("PROXY_CONFIG_FILE", "env.yaml")
+
+	if cm := NewConfigManager(""); cm.configPath != "env.yaml" {
+		t.Errorf("Expected config path from env, got '%s'", cm.configPath)
+	}
+	if cm := NewConfigManager("test.yaml"); cm.configPath != "test.yaml" {
+		t.Errorf("Expected explicit config path, got '%s'", cm.configPath)
+	}
+}
+
+func TestConfigManager_LoadConfig_Interpolation(t *testing.T) {
+	t.Setenv("BACKEND_HOST", "api.internal")
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"yaml", "proxy.yaml", "backend:\n  host: ${BACKEND_HOST}\n  health_check:\n    path: ${EMPTY:-/ready}\nlogging:\n  file: $${literal}\n"},
+		{"json", "proxy.json", `{"backend": {"host": "${BACKEND_HOST}", "health_check": {"path": "${EMPTY:-/ready}"}}, "logging": {"file": "$${literal}"}}`},
+		{"toml", "proxy.toml", "[backend]\nhost = \"${BACKEND_HOST}\"\n[backend.health_check]\npath = \"${EMPTY:-/ready}\"\n[logging]\nfile = \"$${literal}\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(configFile, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := NewConfigManager(configFile).LoadConfig()
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if config.Backend.Host != "api.internal" || config.Backend.HealthCheck.Path != "/ready" {
+				t.Errorf("Expected interpolated backend, got %+v", config.Backend)
+			}
+			if config.Logging.File != "${literal}" {
+				t.Errorf("Expected escaped ${literal}, got %s", config.Logging.File)
+			}
+		})
+	}
+}
+
+func TestConfigManager_LoadConfig_UnsetVariables(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	data := "backend:\n  host: ${UNSET_HOST}\n  health_check:\n    path: /${UNSET_PATH}\nlogging:\n  file: ${UNSET_HOST}\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewConfigManager(configFile).LoadConfig()
+	if err == nil || err.Error() != "config variables not set: UNSET_HOST, UNSET_PATH" {
+		t.Errorf("Expected unset variables error, got %v", err)
+	}
+}
+
+func TestConfigManager_LoadConfig_InterpolationValues(t *testing.T) {
+	values := []string{
+		`a","port":1234,"x":"`,
+		"a\" port = 1234 #",
+		"pa:ss #word",
+		"key: value",
+		"line one\nline two",
+		"${NESTED}",
+	}
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"yaml", "proxy.yaml", "# set ${UNSET_IN_COMMENT} in prod\nbackend:\n  host: ${TEST_BACKEND_HOST} # ${UNSET_IN_COMMENT}\n  port: 8080\n"},
+		{"json", "proxy.json", `{"backend": {"host": "${TEST_BACKEND_HOST}", "port": 8080}}`},
+		{"toml", "proxy.toml", "# set ${UNSET_IN_COMMENT} in prod\n[backend]\nhost = \"${TEST_BACKEND_HOST}\" # ${UNSET_IN_COMMENT}\nport = 8080\n"},
+	}
+
+	for _, tt := range tests {
+		for _, value := range values {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Setenv("TEST_BACKEND_HOST", value)
+				configFile := filepath.Join(t.TempDir(), tt.file)
+				if err := os.WriteFile(configFile, []byte(tt.data), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				config, err := NewConfigManager(configFile).LoadConfig()
+				if err != nil {
+					t.Fatalf("Failed to load config: %v", err)
+				}
+				if config.Backend.Host != value {
+					t.Errorf("Expected host %q, got %q", value, config.Backend.Host)
+				}
+				if config.Backend.Port != 8080 {
+					t.Errorf("Expected port 8080, got %d", config.Backend.Port)
+				}
+			})
+		}
+	}
+}
+
+func TestConfigManager_Secrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "backend_host"), []byte("secret.internal\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_DIR", dir)
+	t.Setenv("AUDIT_FILE", "/var/log/audit.log")
+
+	configFile := filepath.Join(dir, "proxy.yaml")
+	data := "backend:\n  host: file://${SECRETS_DIR}/backend_host\nlogging:\n  audit_file: ${AUDIT_FILE}\n  file: file://missing\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfigManager(configFile).LoadConfig(); err == nil || !strings.Contains(err.Error(), "failed to read secret for logging.file") {
+		t.Errorf("Expected missing secret error, got %v", err)
+	}
+
+	// Relative references are resolved against the config file's directory
+	data = "backend:\n  host: file://${SECRETS_DIR}/backend_host\nlogging:\n  audit_file: ${AUDIT_FILE}\n  file: file://backend_host\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm := NewConfigManager(configFile)
+	cm.SetBackups(-1)
+	config, err := cm.LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Backend.Host != "secret.internal" || config.Logging.File != "secret.internal" {
+		t.Errorf("Expected secrets to be resolved, got %s and %s", config.Backend.Host, config.Logging.File)
+	}
+
+	var dump bytes.Buffer
+	if err := cm.DumpSources(&dump); err != nil {
+		t.Fatalf("Failed to dump sources: %v", err)
+	}
+	if strings.Contains(dump.String(), "secret.internal") || !strings.Contains(dump.String(), "backend.host = [redacted] (file ") {
+		t.Errorf("Expected secrets to be redacted, got:\n%s", dump.String())
+	}
+
+	config.Backend.Port = 9001
+	if err := cm.SaveConfig(config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	saved, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"file://${SECRETS_DIR}/backend_host", "${AUDIT_FILE}", "file://backend_host", "9001"} {
+		if !strings.Contains(string(saved), expected) {
+			t.Errorf("Expected saved config to contain %q, got:\n%s", expected, saved)
+		}
+	}
+	if strings.Contains(string(saved), "secret.internal") || strings.Contains(string(saved), "/var/log/audit.log") {
+		t.Errorf("Expected resolved values to stay out of the saved config, got:\n%s", saved)
+	}
+	if config.Backend.Host != "secret.internal" {
+		t.Errorf("Expected the running config to keep the secret, got %s", config.Backend.Host)
+	}
+
+	// The saved file loads back to the same values
+	reloaded, err := NewConfigManager(configFile).LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if reloaded.Backend.Host != "secret.internal" || reloaded.Backend.Port != 9001 {
+		t.Errorf("Expected saved config to round-trip, got %+v", reloaded.Backend)
+	}
+}
+
+func TestConfigManager_Secrets_RuleValues(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "passwd"), []byte("root:x:0:0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "proxy.yaml")
+	data := "rules:\n  rules:\n    - id: block-file-urls\n      type: url\n      operator: starts_with\n      value: file://passwd\n      action: block\n      priority: 10\n      enabled: true\n" +
+		"    - id: block-missing\n      type: url\n      operator: equals\n      value: file://missing\n      action: block\n      priority: 20\n      enabled: true\n"
+	if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfigManager(configFile).LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(config.Rules.Rules) != 2 || config.Rules.Rules[0].Value != "file://passwd" || config.Rules.Rules[1].Value != "file://missing" {
+		t.Errorf("Expected rule values to be kept as written, got %+v", config.Rules.Rules)
+	}
+}
+
+func TestConfigManager_LoadConfig_InvalidActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"default action", "rules:\n  default_action: blok\n", "invalid default_action: blok"},
+		{"oversize action", "rules:\n  body_inspection:\n    oversize_action: blok\n", "invalid body_inspection.oversize_action: blok"},
+		{"parse error action", "rules:\n  body_inspection:\n    parse_error_action: deny\n", "invalid body_inspection.parse_error_action: deny"},
+		{"valid actions", "rules:\n  default_action: block\n  body_inspection:\n    oversize_action: block\n    parse_error_action: allow\n", ""},
+		{"empty actions", "rules:\n  body_inspection:\n    max_body_size: 1024\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+			if err := os.WriteFile(configFile, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := NewConfigManager(configFile).LoadConfig()
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestConfigManager_Reload_KeepsAPIRules(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	write := func(level string) {
+		data := "logging:\n  level: " + level + "\nrules:\n  rules:\n    - id: block-admin\n      type: url\n      operator: starts_with\n      value: /admin\n      action: block\n      enabled: true\n"
+		if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("info")
+	cm := NewConfigManager(configFile)
+	config, err := cm.LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	manager, err := rules.NewManager(&config.Rules)
+	if err != nil {
+		t.Fatalf("Failed to create rules manager: %v", err)
+	}
+	defer manager.Close()
+	cm.OnReload(func(previous, config *types.ProxyConfig, changes []ConfigChange) {
+		if err := manager.ApplyConfig(&config.Rules); err != nil {
+			t.Errorf("Failed to apply rules config: %v", err)
+		}
+	})
+
+	err = manager.AddRule(types.Rule{ID: "api-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/api", Action: types.ActionBlock, Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	write("debug")
+	if _, err := cm.Reload(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if _, exists := manager.GetRuleByID("api-rule"); !exists {
+		t.Error("Expected the API rule to survive a reload that only changes the log level")
+	}
+	if _, exists := manager.GetRuleByID("block-admin"); !exists {
+		t.Error("Expected the config rule to be kept")
+	}
+}
+
+func TestConfigManager_ApplyOnReload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "proxy.yaml")
+	write := func(level, value string) {
+		data := "logging:\n  level: " + level + "\nrules:\n  rules:\n    - id: block-admin\n      type: url\n      operator: starts_with\n      value: " + value + "\n      action: block\n      enabled: true\n"
+		if err := os.WriteFile(configFile, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("info", "/admin")
+	cm := NewConfigManager(configFile)
+	config, err := cm.LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	appLogger, err := logger.NewLogger(&config.Logging)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer appLogger.Close()
+	manager, err := rules.NewManager(&config.Rules)
+	if err != nil {
+		t.Fatalf("Failed to create rules manager: %v", err)
+	}
+	defer manager.Close()
+	cm.ApplyOnReload(appLogger, manager)
+
+	write("debug", "/private")
+	if _, err := cm.Reload(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+	if appLogger.GetLevel() != "debug" {
+		t.Errorf("Expected log level debug after reload, got %s", appLogger.GetLevel())
+	}
+	if rule, exists := manager.GetRuleByID("block-admin"); !exists || rule.Value != "/private" {
+		t.Errorf("Expected the reloaded rule to be applied, got %+v", rule)
+	}
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"http-proxy/pkg/types"
+)
+
+// SecretPrefix marks a string value that is read from a file, as in
+// file:///run/secrets/api_key. Relative paths are relative to the config file.
+const SecretPrefix = "file://"
+
+// Redacted replaces secret values in config dumps
+const Redacted = "[redacted]"
+
+// variablePattern matches ${VAR} and ${VAR:-default}, and the $${ escape
+var variablePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// stringTemplate is the reference a config string was resolved from
+type stringTemplate struct {
+	raw    string // as written in the file, or the file:// reference
+	value  string // resolved value
+	secret bool   // read from a file:// reference
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in every string field of
+// a parsed config, so that values are never parsed as file syntax and keys
+// and comments are left alone. ${VAR} is the environment variable VAR, and
+// ${VAR:-default} is VAR, or default when VAR is unset or empty. $${ stands
+// for a literal ${. Unset variables without a default are an error. The
+// returned templates hold the original strings by path.
+func interpolate(config *types.ProxyConfig) (map[string]stringTemplate, error) {
+	templates := make(map[string]stringTemplate)
+	var missing []string
+	seen := make(map[string]bool)
+
+	walkStrings(reflect.ValueOf(config), "", func(path string, field reflect.Value) error {
+		raw := field.String()
+		if !strings.Contains(raw, "${") {
+			return nil
+		}
+		value := variablePattern.ReplaceAllStringFunc(raw, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+			groups := variablePattern.FindStringSubmatch(match)
+			name := groups[1]
+			value, set := os.LookupEnv(name)
+			switch {
+			case groups[2] != "" && value == "":
+				return groups[3]
+			case set:
+				return value
+			}
+			if !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+			return match
+		})
+		field.SetString(value)
+		if value != raw {
+			templates[path] = stringTemplate{raw: raw, value: value}
+		}
+		return nil
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("config variables not set: %s", strings.Join(missing, ", "))
+	}
+	return templates, nil
+}
+
+// walkStrings calls fn for every string in v, including those in nested
+// structs and lists, with its dotted path. Strings in maps are not visited.
+func walkStrings(v reflect.Value, path string, fn func(path string, field reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return walkStrings(v.Elem(), path, fn)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if err := walkStrings(v.Field(i), joinPath(path, name), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), joinPath(path, strconv.Itoa(i)), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		return fn(path, v)
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// resolveSecrets replaces every file:// reference in config with the content
+// of the file, without a trailing newline, and adds the references to
+// templates, which holds the interpolated strings by path. Rule definitions
+// are left alone: a file:// value there is a URL to match, not a reference.
+func resolveSecrets(config *types.ProxyConfig, templates map[string]stringTemplate, dir string) error {
+	return walkStrings(reflect.ValueOf(config), "", func(path string, field reflect.Value) error {
+		value := field.String()
+		if !strings.HasPrefix(value, SecretPrefix) || strings.HasPrefix(path, "rules.rules.") {
+			return nil
+		}
+		file := strings.TrimPrefix(value, SecretPrefix)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read secret for %s: %w", path, err)
+		}
+		secret := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		field.SetString(secret)
+
+		reference := value
+		if template, exists := templates[path]; exists && template.value == value {
+			reference = template.raw // keep any ${VAR} in the reference
+		}
+		templates[path] = stringTemplate{raw: reference, value: secret, secret: true}
+		return nil
+	})
+}
+
+// restoreTemplates returns a copy of config with every string that still
+// holds a resolved value replaced by the reference it was resolved from
+func restoreTemplates(config *types.ProxyConfig, templates map[string]stringTemplate) (*types.ProxyConfig, error) {
+	if len(templates) == 0 {
+		return config, nil
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	restored := &types.ProxyConfig{}
+	if err := json.Unmarshal(data, restored); err != nil {
+		return nil, err
+	}
+
+	err = walkStrings(reflect.ValueOf(restored), "", func(path string, field reflect.Value) error {
+		if template, exists := templates[path]; exists && field.String() == template.value {
+			field.SetString(template.raw)
+		}
+		return nil
+	})
+	return restored, err
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-proxy/pkg/types"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config layers, from lowest to highest precedence
+const (
+	LayerDefault = "default"
+	LayerFile    = "file"
+	LayerEnv     = "env"
+	LayerFlag    = "flag"
+)
+
+// EnvPrefix prefixes the environment variable of every config field, as in
+// PROXY_SERVER_PORT for server.port
+const EnvPrefix = "PROXY_"
+
+// ConfigFileEnv names the config file when no path is given
+const ConfigFileEnv = "PROXY_CONFIG_FILE"
+
+// envAliases are the short environment variables of earlier releases. The
+// full names take precedence.
+var envAliases = map[string]string{
+	"PROXY_PORT":      "server.port",
+	"PROXY_LOG_LEVEL": "logging.level",
+}
+
+// flagAliases are the short flags of earlier releases
+var flagAliases = map[string]string{
+	"port":      "server.port",
+	"log-level": "logging.level",
+}
+
+// FieldSource tells which layer supplied the value of a config field
+type FieldSource struct {
+	Key    string // dotted path, as in "server.port"
+	Value  string
+	Layer  string
+	Origin string // config file path, environment variable or flag name
+}
+
+// configField is a settable leaf of the config, such as server.port
+type configField struct {
+	key   string
+	value reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configFields returns every field of config that can be set from a string,
+// keyed by its YAML path. Lists of structs, such as rules, are file-only.
+func configFields(config *types.ProxyConfig) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			key := prefix + name
+			field := v.Field(i)
+			switch {
+			case field.Kind() == reflect.Struct:
+				walk(field, key+".")
+			case settable(field.Type()):
+				fields = append(fields, configField{key: key, value: field})
+			}
+		}
+	}
+	walk(reflect.ValueOf(config).Elem(), "")
+	return fields
+}
+
+// settable reports whether setField can parse a value of type t
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setField parses raw into field. Lists are comma-separated.
+func setField(field reflect.Value, raw string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Kind() == reflect.Slice:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = reflect.Append(list, reflect.ValueOf(item).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(list)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	}
+	return nil
+}
+
+// formatField formats a field the way setField parses it
+func formatField(field reflect.Value) string {
+	if field.Kind() == reflect.Slice {
+		items := make([]string, field.Len())
+		for i := range items {
+			items[i] = field.Index(i).String()
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// EnvName returns the environment variable that overrides a config key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// RegisterFlags adds a flag for every config field, named by its key as in
+// -server.port, plus -config for the config file path. The flags that are
+// set override the file and the environment on every load.
+func (cm *ConfigManager) RegisterFlags(fs *flag.FlagSet) {
+	cm.flags = fs
+	fs.StringVar(&cm.configPath, "config", cm.configPath, "config file path (env "+ConfigFileEnv+")")
+	for _, field := range configFields(&types.ProxyConfig{}) {
+		fs.String(field.key, "", fmt.Sprintf("override %s (env %s)", field.key, EnvName(field.key)))
+	}
+	for alias, key := range flagAliases {
+		fs.String(alias, "", "alias of -"+key)
+	}
+}
+
+// applyOverrides applies the environment and the flags that are set to
+// config and returns the source of every field. fileKeys holds the keys
+// present in the config file, if any.
+func (cm *ConfigManager) applyOverrides(config *types.ProxyConfig, fileKeys map[string]interface{}) ([]FieldSource, error) {
+	setFlags := make(map[string]string)
+	if cm.flags != nil {
+		cm.flags.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = f.Value.String()
+		})
+	}
+
+	fields := configFields(config)
+	sources := make([]FieldSource, 0, len(fields))
+	for _, field := range fields {
+		source := FieldSource{Key: field.key, Layer: LayerDefault}
+		if cm.configPath != "" && hasKey(fileKeys, field.key) {
+			source.Layer, source.Origin = LayerFile, cm.configPath
+		}
+
+		var raw string
+		for alias, key := range envAliases {
+			if value, ok := os.LookupEnv(alias); ok && key == field.key {
+				raw, source.Layer, source.Origin = value, LayerEnv, alias
+			}
+		}
+		if value, ok := os.LookupEnv(EnvName(field.key)); ok {
+			raw, source.Layer, source.Origin = value, LayerEnv, EnvName(field.key)
+		}
+		for alias, key := range flagAliases {
+			if value, ok := setFlags[alias]; ok && key == field.key {
+				raw, source.Layer, source.Origin = value, LayerFlag, "-"+alias
+			}
+		}
+		if value, ok := setFlags[field.key]; ok {
+			raw, source.Layer, source.Origin = value, LayerFlag, "-"+field.key
+		}
+
+		if source.Layer == LayerEnv || source.Layer == LayerFlag {
+			if err := setField(field.value, raw); err != nil {
+				return nil, fmt.Errorf("invalid %s value %q from %s: %w", field.key, raw, source.Origin, err)
+			}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// fileKeys returns the raw tree of a config file, used to tell which keys it sets
+func fileKeys(data []byte, ext string) map[string]interface{} {
+	keys := make(map[string]interface{})
+	switch ext {
+	case ".yaml", ".yml":
+		yaml.Unmarshal(data, &keys)
+	case ".json":
+		json.Unmarshal(data, &keys)
+	case ".toml":
+		toml.Unmarshal(data, &keys)
+	}
+	return keys
+}
+
+// hasKey reports whether the dotted key is present in a config file tree
+func hasKey(tree map[string]interface{}, key string) bool {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, exists := tree[part]
+		if !exists {
+			return false
+		}
+		if i == len(parts)-1 {
+			return true
+		}
+		if tree, exists = value.(map[string]interface{}); !exists {
+			return false
+		}
+	}
+	return false
+}
+
+// Sources returns the layer that supplied every field of the last loaded
+// config. Values read from file:// secrets are redacted.
+func (cm *ConfigManager) Sources() []FieldSource {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if cm.config == nil {
+		return nil
+	}
+	fields := configFields(cm.config)
+	sources := make([]FieldSource, len(fields))
+	for i, field := range fields {
+		switch {
+		case len(cm.sources) == len(fields):
+			sources[i] = cm.sources[i]
+		case cm.configPath != "":
+			// Saved without being loaded, so the file holds every field
+			sources[i] = FieldSource{Key: field.key, Layer: LayerFile, Origin: cm.configPath}
+		default:
+			sources[i] = FieldSource{Key: field.key, Layer: LayerDefault}
+		}
+		sources[i].Value = formatField(field.value)
+		if template, exists := cm.templates[sources[i].Key]; exists && template.secret {
+			sources[i].Value = Redacted
+		}
+	}
+	return sources
+}
+
+// DumpSources writes every config field with its value and the layer that
+// supplied it, for debugging which setting wins
+func (cm *ConfigManager) DumpSources(w io.Writer) error {
+	for _, source := range cm.Sources() {
+		line := fmt.Sprintf("%s = %s (%s", source.Key, source.Value, source.Layer)
+		if source.Origin != "" {
+			line += " " + source.Origin
+		}
+		if _, err := fmt.Fprintln(w, line+")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
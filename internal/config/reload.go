@@ -0,0 +1,278 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"http-proxy/internal/filewatch"
+	"http-proxy/internal/logger"
+	"http-proxy/internal/rules"
+	"http-proxy/pkg/types"
+)
+
+// ConfigChange describes a changed section of the configuration
+type ConfigChange struct {
+	Section string
+
+	// RestartRequired is set for sections that cannot change at runtime. The
+	// running value is kept until the proxy restarts.
+	RestartRequired bool
+}
+
+// ReloadFunc is called after a new configuration was applied. config is the
+// running configuration, which keeps the previous value of every section
+// that requires a restart, and changes lists every section that differs.
+type ReloadFunc func(previous, config *types.ProxyConfig, changes []ConfigChange)
+
+// configSection is a part of the configuration that is compared and, when
+// it cannot change at runtime, kept from the running configuration on reload
+type configSection struct {
+	name    string
+	restart bool
+	fields  func(config *types.ProxyConfig) []interface{}
+	keep    func(dst, src *types.ProxyConfig)
+}
+
+// configSections lists every section in the order changes are reported
+var configSections = []configSection{
+	{
+		name:    "server.listen",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Server.Host, c.Server.Port}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			dst.Server.Host, dst.Server.Port = src.Server.Host, src.Server.Port
+		},
+	},
+	{
+		name:    "server.timeouts",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Server.ReadTimeout, c.Server.WriteTimeout, c.Server.IdleTimeout, c.Server.MaxHeaderBytes}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			dst.Server.ReadTimeout, dst.Server.WriteTimeout = src.Server.ReadTimeout, src.Server.WriteTimeout
+			dst.Server.IdleTimeout, dst.Server.MaxHeaderBytes = src.Server.IdleTimeout, src.Server.MaxHeaderBytes
+		},
+	},
+	{
+		name:    "server.proxy_protocol",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Server.ProxyProtocol}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			dst.Server.ProxyProtocol = src.Server.ProxyProtocol
+		},
+	},
+	{
+		name: "server.trusted_proxies",
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Server.TrustedProxies}
+		},
+	},
+	{
+		name: "backend",
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Backend}
+		},
+	},
+	{
+		name: "rules",
+		fields: func(c *types.ProxyConfig) []interface{} {
+			r := c.Rules
+			return []interface{}{r.Rules, r.DefaultAction, r.ShadowMode, r.LegacyCaseMatching, r.BodyInspection, r.ExpiredRuleAction}
+		},
+	},
+	{
+		name:    "rules.sources",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			r := c.Rules
+			return []interface{}{r.RulesFile, r.RulesDir, r.WatchRulesFile, r.ReloadInterval, r.IPSets,
+				r.ExpiryCheckInterval, r.HistorySize, r.FileBackups}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			d, s := &dst.Rules, &src.Rules
+			d.RulesFile, d.RulesDir, d.WatchRulesFile, d.ReloadInterval = s.RulesFile, s.RulesDir, s.WatchRulesFile, s.ReloadInterval
+			d.IPSets, d.ExpiryCheckInterval, d.HistorySize, d.FileBackups = s.IPSets, s.ExpiryCheckInterval, s.HistorySize, s.FileBackups
+		},
+	},
+	{
+		name: "logging.level",
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Logging.Level}
+		},
+	},
+	{
+		name:    "logging.output",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			l := c.Logging
+			return []interface{}{l.File, l.MaxSize, l.MaxBackups, l.MaxAge, l.Compress, l.AuditEnabled, l.AuditFile}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			level := dst.Logging.Level
+			dst.Logging = src.Logging
+			dst.Logging.Level = level
+		},
+	},
+	{
+		name: "security.rate_limiting",
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.Security.RateLimiting}
+		},
+	},
+	{
+		name:    "geoip",
+		restart: true,
+		fields: func(c *types.ProxyConfig) []interface{} {
+			return []interface{}{c.GeoIP}
+		},
+		keep: func(dst, src *types.ProxyConfig) {
+			dst.GeoIP = src.GeoIP
+		},
+	},
+}
+
+// DiffConfigs returns the sections that differ between two configurations
+func DiffConfigs(previous, config *types.ProxyConfig) []ConfigChange {
+	var changes []ConfigChange
+	for _, section := range configSections {
+		if !reflect.DeepEqual(section.fields(previous), section.fields(config)) {
+			changes = append(changes, ConfigChange{Section: section.name, RestartRequired: section.restart})
+		}
+	}
+	return changes
+}
+
+// OnReload registers a function called after every reload that changed the configuration
+func (cm *ConfigManager) OnReload(fn ReloadFunc) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onReload = append(cm.onReload, fn)
+}
+
+// ApplyOnReload registers a reload function that applies the log level to
+// appLogger and the rules section to rulesManager, through ApplyConfig.
+// Either may be nil. The other live sections, such as backend and rate
+// limiting, are applied by the embedding program's own OnReload functions.
+func (cm *ConfigManager) ApplyOnReload(appLogger *logger.Logger, rulesManager *rules.Manager) {
+	cm.OnReload(func(previous, config *types.ProxyConfig, changes []ConfigChange) {
+		for _, change := range changes {
+			switch {
+			case change.Section == "logging.level" && appLogger != nil:
+				appLogger.SetLevel(config.Logging.Level)
+			case change.Section == "rules" && rulesManager != nil:
+				if err := rulesManager.ApplyConfig(&config.Rules); err != nil {
+					log.Printf("Failed to apply reloaded rules from %s: %v", cm.configPath, err)
+				}
+			}
+		}
+	})
+}
+
+// Reload reloads the config file if its content changed. A file that fails
+// to parse or validate leaves the running configuration untouched. Sections
+// that require a restart keep their running value; the returned changes flag them.
+func (cm *ConfigManager) Reload() ([]ConfigChange, error) {
+	if cm.configPath == "" {
+		return nil, fmt.Errorf("no config path specified")
+	}
+
+	cm.reloadMu.Lock()
+	defer cm.reloadMu.Unlock()
+
+	data, err := os.ReadFile(cm.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", cm.configPath, err)
+	}
+
+	cm.mu.RLock()
+	previous, lastDigest := cm.config, cm.lastDigest
+	cm.mu.RUnlock()
+	if contentDigest(data) == lastDigest {
+		return nil, nil
+	}
+
+	parsed, err := cm.parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config := parsed.config
+	if previous == nil {
+		previous = cm.getDefaultConfig()
+	}
+
+	changes := DiffConfigs(previous, config)
+	for _, section := range configSections {
+		if section.restart {
+			section.keep(config, previous)
+		}
+	}
+
+	cm.setConfig(parsed, data)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	var applied, pending []string
+	for _, change := range changes {
+		if change.RestartRequired {
+			pending = append(pending, change.Section)
+		} else {
+			applied = append(applied, change.Section)
+		}
+	}
+	if len(applied) > 0 {
+		log.Printf("Reloaded config from %s: %s changed", cm.configPath, strings.Join(applied, ", "))
+	}
+	if len(pending) > 0 {
+		log.Printf("Config changes to %s in %s require a restart; keeping the running values", strings.Join(pending, ", "), cm.configPath)
+	}
+
+	cm.mu.RLock()
+	callbacks := append([]ReloadFunc(nil), cm.onReload...)
+	cm.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn(previous, config, changes)
+	}
+	return changes, nil
+}
+
+// StartWatching reloads the config file whenever it changes. interval is the
+// polling interval used when the file cannot be watched for events.
+func (cm *ConfigManager) StartWatching(interval time.Duration) error {
+	if cm.configPath == "" {
+		return fmt.Errorf("no config path specified")
+	}
+
+	cm.StopWatching()
+	cm.watcher = filewatch.New(cm.configPath, interval, filewatch.DefaultDebounce, func() {
+		if _, err := cm.Reload(); err != nil {
+			log.Printf("Keeping previous config, failed to reload %s: %v", cm.configPath, err)
+		}
+	})
+	return nil
+}
+
+// StopWatching stops watching the config file
+func (cm *ConfigManager) StopWatching() {
+	if cm.watcher != nil {
+		cm.watcher.Close()
+		cm.watcher = nil
+	}
+}
+
+// contentDigest returns the hex SHA-256 of a file's content
+func contentDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,150 @@
+// Package fileutil writes configuration and rules files so that readers never
+// see a partially written file, keeping timestamped backups of earlier versions.
+package fileutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBackups is the number of backups kept when not configured
+const DefaultBackups = 5
+
+// backupTimeFormat sorts lexically in time order
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// WriteFileAtomic writes data to a temporary file in the directory of path,
+// syncs it and renames it over path, so that path holds either the old or the
+// new content even if the process crashes mid-write
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	// Persist the rename itself; not every platform can sync a directory
+	if d, dirErr := os.Open(dir); dirErr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// WriteFileWithBackup backs up the current content of path, keeping the newest
+// keep backups, and then writes data atomically. A keep of zero or less keeps
+// no backups.
+func WriteFileWithBackup(path string, data []byte, perm os.FileMode, keep int) error {
+	if keep > 0 {
+		if _, err := Backup(path, keep); err != nil {
+			return err
+		}
+	}
+	return WriteFileAtomic(path, data, perm)
+}
+
+// Backup copies path to a timestamped backup next to it, such as
+// rules.yaml.20240102T150405.000000000Z.bak, and removes all but the newest
+// keep backups. It returns the backup path, or "" if path does not exist.
+func Backup(path string, keep int) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to open %s for backup: %w", path, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for backup: %w", path, err)
+	}
+
+	backup := path + "." + time.Now().UTC().Format(backupTimeFormat) + ".bak"
+	if err := WriteFileAtomic(backup, data, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	if err := pruneBackups(path, keep); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// Backups returns the backups of path, oldest first
+func Backups(path string) ([]string, error) {
+	matches, err := filepath.Glob(globEscape(path) + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := path + "."
+	backups := matches[:0]
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, prefix), ".bak")
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest keep backups of path
+func pruneBackups(path string, keep int) error {
+	backups, err := Backups(path)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// globEscape escapes the pattern characters of filepath.Match in path
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) && filepath.Separator != '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %q, got %q (%v)", content, data, err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestWriteFileAtomic_MissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "rules.yaml")
+	if err := WriteFileAtomic(path, []byte("data"), 0644); err == nil {
+		t.Error("Expected error writing into a missing directory")
+	}
+}
+
+func TestWriteFileWithBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	// The first write has nothing to back up
+	if err := WriteFileWithBackup(path, []byte("v0"), 0644, 2); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, content := range []string{"v1", "v2", "v3"} {
+		if err := WriteFileWithBackup(path, []byte(content), 0644, 2); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	backups, err := Backups(path)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %v", backups)
+	}
+
+	for i, expected := range []string{"v1", "v2"} {
+		data, err := os.ReadFile(backups[i])
+		if err != nil || string(data) != expected {
+			t.Errorf("Expected backup %d to hold %q, got %q (%v)", i, expected, data, err)
+		}
+		if !strings.HasSuffix(backups[i], ".bak") {
+			t.Errorf("Expected backup name to end in .bak, got %s", backups[i])
+		}
+	}
+
+	// Other files next to the original are not mistaken for backups
+	if err := os.WriteFile(path+".old.bak", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := Backups(path); len(backups) != 2 {
+		t.Errorf("Expected unrelated .bak file to be ignored, got %v", backups)
+	}
+}
+
+func TestWriteFileWithBackup_Disabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	for _, content := range []string{"a", "b"} {
+		if err := WriteFileWithBackup(path, []byte(content), 0644, 0); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if backups, _ := Backups(path); len(backups) != 0 {
+		t.Errorf("Expected no backups, got %v", backups)
+	}
+}
@@ -0,0 +1,97 @@
+// Package filewatch notifies about changes to a file. On Linux it watches the
+// file's directory with inotify, which sees editors that save by renaming a
+// temporary file and the symlink swaps Kubernetes uses to update ConfigMap
+// volumes. Elsewhere, or when inotify is unavailable, it polls.
+package filewatch
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDebounce is how long events are collected before notifying
+const DefaultDebounce = 100 * time.Millisecond
+
+// Watcher calls a function when a file may have changed. Notifications are
+// hints: they are debounced but can be spurious, so callers should compare
+// the file content, for example by hash, to decide whether it changed.
+type Watcher struct {
+	path     string
+	dir      bool // watch every file in path rather than path itself
+	interval time.Duration
+	debounce time.Duration
+	onChange func()
+
+	polling   atomic.Bool
+	stop      chan bool
+	done      chan bool
+	closeOnce sync.Once
+}
+
+// New starts watching path, calling onChange from the watcher goroutine.
+// interval is the polling interval used when inotify is unavailable.
+func New(path string, interval, debounce time.Duration, onChange func()) *Watcher {
+	return start(&Watcher{path: path}, interval, debounce, onChange)
+}
+
+// NewDir starts watching the files in directory dir, like New
+func NewDir(dir string, interval, debounce time.Duration, onChange func()) *Watcher {
+	return start(&Watcher{path: dir, dir: true}, interval, debounce, onChange)
+}
+
+func start(w *Watcher, interval, debounce time.Duration, onChange func()) *Watcher {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+
+	w.interval = interval
+	w.debounce = debounce
+	w.onChange = onChange
+	w.stop = make(chan bool)
+	w.done = make(chan bool)
+
+	if err := w.startNotify(); err != nil {
+		log.Printf("Falling back to polling %s every %v: %v", w.path, interval, err)
+		w.polling.Store(true)
+		go w.poll()
+	}
+	return w
+}
+
+// Polling reports whether the watcher polls instead of using inotify
+func (w *Watcher) Polling() bool {
+	return w.polling.Load()
+}
+
+// Close stops the watcher and waits for its goroutine to exit
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// poll notifies every interval until the watcher is closed
+func (w *Watcher) poll() {
+	defer close(w.done)
+	w.pollLoop()
+}
+
+func (w *Watcher) pollLoop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.onChange()
+		case <-w.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+//go:build linux
+
+package filewatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify holds the inotify instance of a watcher and its watched directories
+type inotify struct {
+	fd   int
+	file *os.File // non-blocking, so Close interrupts a pending Read
+
+	mu      sync.Mutex
+	dir     string           // directory of the watched path
+	base    string           // file name of the watched path, empty for any file in dir
+	mainWD  int32            // watch descriptor of dir
+	targets map[int32]string // other directories watched for a file name
+}
+
+// startNotify watches the directory of the path, and the directory of its
+// symlink target if that lies elsewhere. Directories are watched themselves.
+func (w *Watcher) startNotify() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	n := &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dir:     filepath.Dir(filepath.Clean(w.path)),
+		base:    filepath.Base(w.path),
+		targets: make(map[int32]string),
+	}
+	if w.dir {
+		n.dir, n.base = filepath.Clean(w.path), ""
+	}
+	wd, err := syscall.InotifyAddWatch(fd, n.dir, watchMask)
+	if err != nil {
+		n.file.Close()
+		return fmt.Errorf("failed to watch %s: %w", n.dir, err)
+	}
+	n.mainWD = int32(wd)
+	n.watchTarget(w.path)
+
+	go w.notifyLoop(n)
+	return nil
+}
+
+// notifyLoop debounces inotify events until the watcher is closed, falling
+// back to polling if the watched directory goes away
+func (w *Watcher) notifyLoop(n *inotify) {
+	defer close(w.done)
+
+	changed := make(chan bool, 1)
+	gone := make(chan bool)
+	go n.readEvents(changed, gone)
+
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-changed:
+			// Each event restarts the debounce period
+			fire = time.After(w.debounce)
+		case <-fire:
+			fire = nil
+			n.watchTarget(w.path)
+			w.onChange()
+		case <-gone:
+			n.file.Close()
+			log.Printf("Directory %s is no longer watched, falling back to polling every %v", n.dir, w.interval)
+			w.polling.Store(true)
+			w.onChange()
+			w.pollLoop()
+			return
+		case <-w.stop:
+			n.file.Close()
+			return
+		}
+	}
+}
+
+// readEvents reads events until the inotify file is closed, signalling
+// changed for events that concern the watched file and closing gone when the
+// watch on its directory is removed
+func (n *inotify) readEvents(changed chan<- bool, gone chan<- bool) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			if start+nameLen > count {
+				break
+			}
+			name := strings.TrimRight(string(buf[start:start+nameLen]), "\x00")
+			offset = start + nameLen
+
+			switch n.classify(wd, mask, name) {
+			case eventRelevant:
+				select {
+				case changed <- true:
+				default:
+				}
+			case eventGone:
+				close(gone)
+				return
+			}
+		}
+	}
+}
+
+type eventKind int
+
+const (
+	eventIgnored eventKind = iota
+	eventRelevant
+	eventGone
+)
+
+// classify decides whether an event may have changed the watched file
+func (n *inotify) classify(wd int32, mask uint32, name string) eventKind {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return eventRelevant
+	}
+	if wd == n.mainWD {
+		switch {
+		case mask&syscall.IN_IGNORED != 0:
+			return eventGone
+		case name == "":
+			return eventRelevant // the directory itself was moved or deleted
+		case n.base == "" || name == n.base || strings.HasPrefix(name, ".."):
+			// The file itself, or the "..data" symlink Kubernetes swaps
+			return eventRelevant
+		}
+		return eventIgnored
+	}
+
+	target, exists := n.targets[wd]
+	if !exists {
+		return eventIgnored
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(n.targets, wd)
+		return eventRelevant
+	}
+	if name == target || name == "" {
+		return eventRelevant
+	}
+	return eventIgnored
+}
+
+// watchTarget adds a watch on the directory of the file path resolves to,
+// unless it is the watched directory or inside it
+func (n *inotify) watchTarget(path string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.base == "" {
+		return
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(resolved)
+	if realDir, err := filepath.EvalSymlinks(n.dir); err == nil {
+		rel, err := filepath.Rel(realDir, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+	}
+
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, watchMask)
+	if err != nil {
+		log.Printf("Failed to watch %s: %v", dir, err)
+		return
+	}
+	n.targets[int32(wd)] = filepath.Base(resolved)
+}
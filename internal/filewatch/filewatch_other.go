@@ -0,0 +1,10 @@
+//go:build !linux
+
+package filewatch
+
+import "errors"
+
+// startNotify reports that event-driven watching is unsupported
+func (w *Watcher) startNotify() error {
+	return errors.New("inotify is not supported on this platform")
+}
@@ -0,0 +1,201 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startWatcher watches path and returns the number of notifications so far
+func startWatcher(t *testing.T, path string) (*Watcher, *atomic.Int64) {
+	var notifications atomic.Int64
+	w := New(path, 50*time.Millisecond, 20*time.Millisecond, func() { notifications.Add(1) })
+	t.Cleanup(w.Close)
+	return w, &notifications
+}
+
+// waitFor polls cond for up to two seconds
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestWatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir, path string)
+	}{
+		{"write in place", func(t *testing.T, dir, path string) {
+			if err := os.WriteFile(path, []byte("v2"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"rename over", func(t *testing.T, dir, path string) {
+			tmp := filepath.Join(dir, ".rules.yaml.swp")
+			if err := os.WriteFile(tmp, []byte("v2"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"delete", func(t *testing.T, dir, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rules.yaml")
+			if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, notifications := startWatcher(t, path)
+			tt.change(t, dir, path)
+
+			if !waitFor(func() bool { return notifications.Load() > 0 }) {
+				t.Error("Expected a notification")
+			}
+		})
+	}
+}
+
+func TestWatcher_Debounce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(path, []byte("v0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, notifications := startWatcher(t, path)
+	if w.Polling() {
+		t.Skip("inotify is not available")
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !waitFor(func() bool { return notifications.Load() > 0 }) {
+		t.Fatal("Expected a notification")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := notifications.Load(); got > 2 {
+		t.Errorf("Expected writes in quick succession to be debounced, got %d notifications", got)
+	}
+
+	// Changes to other files in the directory are ignored
+	before := notifications.Load()
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := notifications.Load(); got != before {
+		t.Errorf("Expected unrelated file to be ignored, got %d notifications", got-before)
+	}
+}
+
+func TestWatcher_SymlinkSwap(t *testing.T) {
+	// Kubernetes ConfigMap volumes: rules.yaml -> ..data/rules.yaml and
+	// ..data -> ..<timestamp>, updated by atomically replacing ..data
+	dir := t.TempDir()
+	for _, version := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, version, "rules.yaml"), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Skipf("Cannot create symlinks: %v", err)
+	}
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.Symlink(filepath.Join("..data", "rules.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	_, notifications := startWatcher(t, path)
+
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitFor(func() bool { return notifications.Load() > 0 }) {
+		t.Error("Expected a notification for the symlink swap")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "..v2" {
+		t.Errorf("Expected the new version through the symlink, got %q (%v)", data, err)
+	}
+}
+
+func TestWatcher_SymlinkTarget(t *testing.T) {
+	targetDir := t.TempDir()
+	target := filepath.Join(targetDir, "rules.yaml")
+	if err := os.WriteFile(target, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.Symlink(target, path); err != nil {
+		t.Skipf("Cannot create symlinks: %v", err)
+	}
+
+	_, notifications := startWatcher(t, path)
+	if err := os.WriteFile(target, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return notifications.Load() > 0 }) {
+		t.Error("Expected a notification for a change to the symlink target")
+	}
+}
+
+func TestWatcher_FallsBackToPolling(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rules")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rules.yaml")
+
+	w, notifications := startWatcher(t, path)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitFor(w.Polling) {
+		t.Fatal("Expected the watcher to poll once its directory is gone")
+	}
+	before := notifications.Load()
+	if !waitFor(func() bool { return notifications.Load() > before }) {
+		t.Error("Expected polling notifications")
+	}
+}
+
+func TestWatcher_Dir(t *testing.T) {
+	dir := t.TempDir()
+	var notifications atomic.Int64
+	w := NewDir(dir, 50*time.Millisecond, 20*time.Millisecond, func() { notifications.Add(1) })
+	defer w.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "team-a.yaml"), []byte("rules: []"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return notifications.Load() > 0 }) {
+		t.Error("Expected a notification for a new file in the directory")
+	}
+}
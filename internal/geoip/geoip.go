@@ -0,0 +1,214 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+// Database resolves client IPs to country and autonomous system using local
+// MaxMind-format databases, reloading them when the files change
+type Database struct {
+	mu         sync.RWMutex
+	config     types.GeoIPConfig
+	country    *databaseFile
+	asn        *databaseFile
+	stopWatch  chan bool
+	pollTicker *time.Ticker
+}
+
+// databaseFile is a loaded database together with the modification time it was read at
+type databaseFile struct {
+	path    string
+	reader  *Reader
+	modTime time.Time
+}
+
+// NewDatabase opens the databases configured in config and starts watching
+// them for changes when a reload interval is set
+func NewDatabase(config *types.GeoIPConfig) (*Database, error) {
+	db := &Database{
+		config:    *config,
+		stopWatch: make(chan bool, 1),
+	}
+
+	if config.CountryDatabase != "" {
+		file, err := loadDatabaseFile(config.CountryDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load country database: %w", err)
+		}
+		db.country = file
+	}
+
+	if config.ASNDatabase != "" {
+		if config.ASNDatabase == config.CountryDatabase {
+			db.asn = db.country
+		} else {
+			file, err := loadDatabaseFile(config.ASNDatabase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load ASN database: %w", err)
+			}
+			db.asn = file
+		}
+	}
+
+	if config.ReloadInterval > 0 {
+		db.startFileWatcher(config.ReloadInterval)
+	}
+
+	return db, nil
+}
+
+// loadDatabaseFile reads a database file and records its modification time
+func loadDatabaseFile(path string) (*databaseFile, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat database %s: %w", path, err)
+	}
+
+	reader, err := OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &databaseFile{path: path, reader: reader, modTime: fileInfo.ModTime()}, nil
+}
+
+// Lookup resolves the country and ASN of ip
+func (db *Database) Lookup(ip net.IP) (*types.GeoInfo, bool) {
+	if ip == nil {
+		return nil, false
+	}
+
+	db.mu.RLock()
+	country, asn := db.country, db.asn
+	db.mu.RUnlock()
+
+	info := &types.GeoInfo{}
+	found := false
+
+	if country != nil {
+		if record, err := country.reader.Lookup(ip); err == nil && record != nil {
+			if code, ok := lookupPath(record, "country", "iso_code").(string); ok {
+				info.Country = strings.ToUpper(code)
+				found = true
+			} else if code, ok := lookupPath(record, "registered_country", "iso_code").(string); ok {
+				info.Country = strings.ToUpper(code)
+				found = true
+			}
+		}
+	}
+
+	if asn != nil {
+		if record, err := asn.reader.Lookup(ip); err == nil && record != nil {
+			if number := toUint64(lookupPath(record, "autonomous_system_number")); number != 0 {
+				info.ASN = uint32(number)
+				info.ASOrganization, _ = lookupPath(record, "autonomous_system_organization").(string)
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, false
+	}
+	return info, true
+}
+
+// lookupPath walks nested maps of a decoded record
+func lookupPath(record interface{}, keys ...string) interface{} {
+	current := record
+	for _, key := range keys {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = fields[key]
+	}
+	return current
+}
+
+// Reload re-reads any database file whose modification time has changed
+func (db *Database) Reload() error {
+	db.mu.RLock()
+	country, asn := db.country, db.asn
+	db.mu.RUnlock()
+
+	newCountry, err := reloadIfChanged(country)
+	if err != nil {
+		return fmt.Errorf("failed to reload country database: %w", err)
+	}
+
+	newASN := newCountry
+	if asn != country {
+		newASN, err = reloadIfChanged(asn)
+		if err != nil {
+			return fmt.Errorf("failed to reload ASN database: %w", err)
+		}
+	}
+
+	db.mu.Lock()
+	db.country = newCountry
+	db.asn = newASN
+	db.mu.Unlock()
+
+	return nil
+}
+
+// reloadIfChanged returns a freshly loaded file when it changed on disk, or file otherwise
+func reloadIfChanged(file *databaseFile) (*databaseFile, error) {
+	if file == nil {
+		return nil, nil
+	}
+
+	fileInfo, err := os.Stat(file.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat database %s: %w", file.path, err)
+	}
+	if fileInfo.ModTime().Equal(file.modTime) {
+		return file, nil
+	}
+
+	reloaded, err := loadDatabaseFile(file.path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reloaded GeoIP database %s (%s)", file.path, reloaded.reader.Metadata().DatabaseType)
+	return reloaded, nil
+}
+
+// startFileWatcher polls the database files for changes
+func (db *Database) startFileWatcher(interval time.Duration) {
+	db.pollTicker = time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-db.pollTicker.C:
+				if err := db.Reload(); err != nil {
+					log.Printf("Error reloading GeoIP database: %v", err)
+				}
+			case <-db.stopWatch:
+				db.pollTicker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the database files
+func (db *Database) Close() {
+	if db.pollTicker != nil {
+		select {
+		case db.stopWatch <- true:
+		default:
+		}
+	}
+}
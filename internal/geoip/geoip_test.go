@@ -0,0 +1,341 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+// testNetwork is a network and the record stored for it in a test database
+type testNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// buildTestDatabase writes a minimal MaxMind DB containing networks
+func buildTestDatabase(t *testing.T, ipVersion, recordSize int, networks []testNetwork) []byte {
+	t.Helper()
+
+	var data bytes.Buffer
+	type leaf struct {
+		bits   []byte
+		prefix int
+		offset int
+	}
+	var leaves []leaf
+
+	for _, network := range networks {
+		ip, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		bits := []byte(ip.Mask(ipNet.Mask))
+		if ip4 := ip.To4(); ip4 != nil {
+			bits = []byte(ip4.Mask(ipNet.Mask))
+			if ipVersion == 6 {
+				bits = append(make([]byte, 12), bits...)
+				ones += 96
+			}
+		}
+		leaves = append(leaves, leaf{bits: bits, prefix: ones, offset: data.Len()})
+		encodeValue(&data, network.record)
+	}
+
+	// Build the search tree; -1 marks an empty record, values <= -2 mark data leaves
+	nodes := [][2]int{{-1, -1}}
+	for _, l := range leaves {
+		node := 0
+		for i := 0; i < l.prefix; i++ {
+			bit := int(l.bits[i>>3]>>(7-uint(i%8))) & 1
+			if i == l.prefix-1 {
+				nodes[node][bit] = -2 - l.offset
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var tree bytes.Buffer
+	for _, node := range nodes {
+		var records [2]uint32
+		for bit, value := range node {
+			switch {
+			case value == -1:
+				records[bit] = uint32(nodeCount)
+			case value <= -2:
+				records[bit] = uint32(nodeCount + 16 + (-2 - value))
+			default:
+				records[bit] = uint32(value)
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0])})
+			tree.Write([]byte{byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1])})
+		case 28:
+			tree.Write([]byte{byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0])})
+			tree.WriteByte(byte((records[0]>>24)<<4) | byte(records[1]>>24&0x0F))
+			tree.Write([]byte{byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1])})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, records)
+		}
+	}
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.Write(metadataMarker)
+	encodeValue(&file, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-DB",
+		"binary_format_major_version": uint16(2),
+		"build_epoch":                 uint64(time.Now().Unix()),
+	})
+
+	return file.Bytes()
+}
+
+// encodeValue appends the MaxMind DB encoding of value
+func encodeValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case []interface{}:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			encodeValue(buf, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeControl(buf, typeMap, len(v))
+		for _, key := range keys {
+			encodeValue(buf, key)
+			encodeValue(buf, v[key])
+		}
+	}
+}
+
+func writeUint(buf *bytes.Buffer, fieldType int, value uint64) {
+	var payload []byte
+	for value > 0 {
+		payload = append([]byte{byte(value)}, payload...)
+		value >>= 8
+	}
+	writeControl(buf, fieldType, len(payload))
+	buf.Write(payload)
+}
+
+func writeControl(buf *bytes.Buffer, fieldType, size int) {
+	var sizeBits int
+	var extra []byte
+	switch {
+	case size < 29:
+		sizeBits = size
+	case size < 285:
+		sizeBits, extra = 29, []byte{byte(size - 29)}
+	default:
+		sizeBits, extra = 30, []byte{byte((size - 285) >> 8), byte(size - 285)}
+	}
+
+	if fieldType > 7 {
+		buf.WriteByte(byte(sizeBits))
+		buf.WriteByte(byte(fieldType - 7))
+	} else {
+		buf.WriteByte(byte(fieldType<<5 | sizeBits))
+	}
+	buf.Write(extra)
+}
+
+func countryRecord(code string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": code,
+			"names":    map[string]interface{}{"en": code},
+		},
+	}
+}
+
+func TestReader_Lookup(t *testing.T) {
+	networks := []testNetwork{
+		{cidr: "1.0.0.0/24", record: countryRecord("AU")},
+		{cidr: "81.2.69.0/23", record: countryRecord("GB")},
+		{cidr: "2001:db8::/32", record: countryRecord("DE")},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		reader, err := NewReader(buildTestDatabase(t, 6, recordSize, networks))
+		if err != nil {
+			t.Fatalf("record size %d: failed to open database: %v", recordSize, err)
+		}
+
+		tests := []struct {
+			ip            string
+			expectCountry string
+		}{
+			{"1.0.0.55", "AU"},
+			{"81.2.68.1", "GB"},
+			{"81.2.69.200", "GB"},
+			{"81.2.70.1", ""},
+			{"2001:db8::1", "DE"},
+			{"2001:db9::1", ""},
+		}
+
+		for _, tt := range tests {
+			record, err := reader.Lookup(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatalf("record size %d: lookup %s failed: %v", recordSize, tt.ip, err)
+			}
+			country, _ := lookupPath(record, "country", "iso_code").(string)
+			if country != tt.expectCountry {
+				t.Errorf("record size %d: expected %s to resolve to %q, got %q", recordSize, tt.ip, tt.expectCountry, country)
+			}
+		}
+	}
+}
+
+func TestReader_IPv4Database(t *testing.T) {
+	reader, err := NewReader(buildTestDatabase(t, 4, 24, []testNetwork{
+		{cidr: "10.0.0.0/8", record: countryRecord("US")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Metadata().IPVersion != 4 || reader.Metadata().DatabaseType != "Test-DB" {
+		t.Errorf("Unexpected metadata: %+v", reader.Metadata())
+	}
+
+	record, err := reader.Lookup(net.ParseIP("10.1.2.3"))
+	if err != nil || record == nil {
+		t.Fatalf("Expected record for 10.1.2.3, got %v, %v", record, err)
+	}
+
+	if _, err := reader.Lookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Errorf("Expected error looking up IPv6 address in IPv4 database")
+	}
+}
+
+func TestNewReader_Invalid(t *testing.T) {
+	if _, err := NewReader([]byte("not a database")); err == nil {
+		t.Errorf("Expected error for data without metadata")
+	}
+}
+
+func TestDecoder_PointerCycle(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"self", []byte{0x20, 0x00}},
+		{"two pointers", []byte{0x20, 0x02, 0x20, 0x00}},
+		{"map value", []byte{0xe1, 0x41, 'k', 0x20, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newDecoder(tt.data).decode(0)
+			if err == nil || !strings.Contains(err.Error(), "nested deeper than") {
+				t.Errorf("Expected depth error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDatabase_LookupAndReload(t *testing.T) {
+	tempDir := t.TempDir()
+	countryFile := filepath.Join(tempDir, "country.mmdb")
+	asnFile := filepath.Join(tempDir, "asn.mmdb")
+
+	writeFile := func(path string, data []byte) {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(countryFile, buildTestDatabase(t, 6, 24, []testNetwork{
+		{cidr: "203.0.113.0/24", record: countryRecord("JP")},
+	}))
+	writeFile(asnFile, buildTestDatabase(t, 6, 24, []testNetwork{
+		{cidr: "203.0.113.0/24", record: map[string]interface{}{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example Networks",
+		}},
+	}))
+
+	db, err := NewDatabase(&types.GeoIPConfig{CountryDatabase: countryFile, ASNDatabase: asnFile})
+	if err != nil {
+		t.Fatalf("Expected no error opening databases, got: %v", err)
+	}
+	defer db.Close()
+
+	info, found := db.Lookup(net.ParseIP("203.0.113.9"))
+	if !found {
+		t.Fatal("Expected 203.0.113.9 to resolve")
+	}
+	if info.Country != "JP" || info.ASN != 64500 || info.ASOrganization != "Example Networks" {
+		t.Errorf("Unexpected geo info: %+v", info)
+	}
+
+	if _, found := db.Lookup(net.ParseIP("198.51.100.1")); found {
+		t.Errorf("Expected 198.51.100.1 not to resolve")
+	}
+
+	// Replace the country database and make sure the change is picked up
+	writeFile(countryFile, buildTestDatabase(t, 6, 24, []testNetwork{
+		{cidr: "203.0.113.0/24", record: countryRecord("KR")},
+	}))
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(countryFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Expected no error reloading, got: %v", err)
+	}
+
+	info, _ = db.Lookup(net.ParseIP("203.0.113.9"))
+	if info.Country != "KR" {
+		t.Errorf("Expected country KR after reload, got %s", info.Country)
+	}
+}
+
+func TestNewDatabase_MissingFile(t *testing.T) {
+	_, err := NewDatabase(&types.GeoIPConfig{CountryDatabase: "/non/existent.mmdb"})
+	if err == nil {
+		t.Errorf("Expected error for missing database file")
+	}
+}
@@ -0,0 +1,394 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata section of a MaxMind DB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparatorSize is the number of zero bytes between the search tree and the data section
+const dataSectionSeparatorSize = 16
+
+// Metadata describes a MaxMind DB file
+type Metadata struct {
+	DatabaseType string
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	BuildEpoch   uint64
+}
+
+// Reader reads records from a MaxMind DB (MMDB) file held in memory
+type Reader struct {
+	buffer      []byte
+	metadata    Metadata
+	treeSize    uint
+	dataSection []byte
+	ipv4Start   uint
+}
+
+// OpenReader reads and parses the MaxMind DB file at path
+func OpenReader(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database %s: %w", path, err)
+	}
+	return NewReader(data)
+}
+
+// NewReader parses a MaxMind DB from its raw bytes
+func NewReader(buffer []byte) (*Reader, error) {
+	markerIndex := bytes.LastIndex(buffer, metadataMarker)
+	if markerIndex == -1 {
+		return nil, fmt.Errorf("invalid MaxMind DB: metadata section not found")
+	}
+
+	metadataStart := markerIndex + len(metadataMarker)
+	rawMetadata, _, err := newDecoder(buffer[metadataStart:]).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	fields, ok := rawMetadata.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: expected map")
+	}
+
+	metadata := Metadata{
+		NodeCount:  uint(toUint64(fields["node_count"])),
+		RecordSize: uint(toUint64(fields["record_size"])),
+		IPVersion:  uint(toUint64(fields["ip_version"])),
+		BuildEpoch: toUint64(fields["build_epoch"]),
+	}
+	metadata.DatabaseType, _ = fields["database_type"].(string)
+
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind DB record size: %d", metadata.RecordSize)
+	}
+
+	treeSize := metadata.NodeCount * metadata.RecordSize / 4
+	dataStart := treeSize + dataSectionSeparatorSize
+	if dataStart > uint(markerIndex) {
+		return nil, fmt.Errorf("invalid MaxMind DB: search tree exceeds file size")
+	}
+
+	reader := &Reader{
+		buffer:      buffer,
+		metadata:    metadata,
+		treeSize:    treeSize,
+		dataSection: buffer[dataStart:markerIndex],
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < metadata.NodeCount; i++ {
+			node = reader.readNode(node, 0)
+		}
+		reader.ipv4Start = node
+	}
+
+	return reader, nil
+}
+
+// Metadata returns the database metadata
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the decoded record for ip, or nil when the database has no entry
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node, bitCount, err := r.startNode(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	address := ip.To16()
+	if bitCount == 32 {
+		address = ip.To4()
+	}
+
+	for i := 0; i < bitCount && node < r.metadata.NodeCount; i++ {
+		bit := uint(address[i>>3]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	if node == r.metadata.NodeCount {
+		return nil, nil
+	}
+	if node < r.metadata.NodeCount {
+		return nil, fmt.Errorf("invalid MaxMind DB: search tree is deeper than the address")
+	}
+
+	offset := node - r.metadata.NodeCount - dataSectionSeparatorSize
+	if offset >= uint(len(r.dataSection)) {
+		return nil, fmt.Errorf("invalid MaxMind DB: record offset %d out of range", offset)
+	}
+
+	record, _, err := newDecoder(r.dataSection).decode(offset)
+	return record, err
+}
+
+// startNode returns the tree node and number of bits to walk for ip
+func (r *Reader) startNode(ip net.IP) (uint, int, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		if r.metadata.IPVersion == 6 {
+			return r.ipv4Start, 32, nil
+		}
+		return 0, 32, nil
+	}
+	if ip.To16() == nil {
+		return 0, 0, fmt.Errorf("invalid IP address: %v", ip)
+	}
+	if r.metadata.IPVersion == 4 {
+		return 0, 0, fmt.Errorf("cannot look up IPv6 address %s in an IPv4-only database", ip)
+	}
+	return 0, 128, nil
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a tree node
+func (r *Reader) readNode(node, bit uint) uint {
+	switch r.metadata.RecordSize {
+	case 24:
+		offset := node*6 + bit*3
+		b := r.buffer[offset : offset+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		offset := node * 7
+		b := r.buffer[offset : offset+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		offset := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.buffer[offset : offset+4]))
+	}
+}
+
+// MaxMind DB data section field types
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxDecodeDepth bounds the nesting of maps, arrays and pointers, as in
+// libmaxminddb, so that pointer cycles in a malformed file fail to decode
+const maxDecodeDepth = 512
+
+// decoder decodes values of a MaxMind DB data section
+type decoder struct {
+	buffer []byte
+	depth  int
+}
+
+func newDecoder(buffer []byte) *decoder {
+	return &decoder{buffer: buffer}
+}
+
+// decode decodes the value at offset and returns it with the offset following it
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("data nested deeper than %d levels at offset %d", maxDecodeDepth, offset)
+	}
+
+	fieldType, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if fieldType == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	return d.decodeValue(fieldType, size, offset)
+}
+
+// decodeControl parses a control byte and returns the field type and payload size
+func (d *decoder) decodeControl(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+	control := d.buffer[offset]
+	offset++
+
+	fieldType := int(control >> 5)
+	if fieldType == typeExtended {
+		if offset >= uint(len(d.buffer)) {
+			return 0, 0, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+		}
+		fieldType = int(d.buffer[offset]) + 7
+		offset++
+	}
+
+	size := uint(control & 0x1f)
+	if fieldType == typePointer || size < 29 {
+		return fieldType, size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(d.buffer)) {
+		return 0, 0, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+	value := uint(0)
+	for _, b := range d.buffer[offset : offset+extra] {
+		value = value<<8 | uint(b)
+	}
+	switch extra {
+	case 1:
+		size = 29 + value
+	case 2:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+
+	return fieldType, size, offset + extra, nil
+}
+
+// decodePointer resolves a pointer whose size bits are given by size
+func (d *decoder) decodePointer(size, offset uint) (uint, uint, error) {
+	pointerSize := ((size >> 3) & 0x3) + 1
+	if offset+pointerSize > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+
+	value := uint(0)
+	if pointerSize != 4 {
+		value = size & 0x7
+	}
+	for _, b := range d.buffer[offset : offset+pointerSize] {
+		value = value<<8 | uint(b)
+	}
+
+	switch pointerSize {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+
+	return value, offset + pointerSize, nil
+}
+
+// decodeValue decodes a non-pointer value of the given type
+func (d *decoder) decodeValue(fieldType int, size, offset uint) (interface{}, uint, error) {
+	switch fieldType {
+	case typeMap:
+		result := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key at offset %d is not a string", offset)
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[keyString] = value
+			offset = next
+		}
+		return result, offset, nil
+	case typeArray:
+		result := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("unexpected end of data at offset %d", offset)
+	}
+	payload := d.buffer[offset : offset+size]
+	next := offset + size
+
+	switch fieldType {
+	case typeString:
+		return string(payload), next, nil
+	case typeBytes:
+		return append([]byte(nil), payload...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), next, nil
+	case typeUint16, typeUint32, typeUint64, typeUint128:
+		if size > 8 {
+			// Values wider than 64 bits are not needed for lookups; keep the raw bytes
+			return append([]byte(nil), payload...), next, nil
+		}
+		value := uint64(0)
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, next, nil
+	case typeInt32:
+		value := uint32(0)
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int64(int32(value)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown field type %d at offset %d", fieldType, offset)
+	}
+}
+
+// toUint64 converts decoded unsigned values to uint64
+func toUint64(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	}
+	return 0
+}
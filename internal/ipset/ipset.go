@@ -0,0 +1,245 @@
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+// Set is a named collection of IP prefixes
+type Set struct {
+	Name string
+	tree *Tree
+}
+
+// NewSet creates an empty named set
+func NewSet(name string) *Set {
+	return &Set{Name: name, tree: NewTree()}
+}
+
+// Add adds a CIDR range or single address to the set
+func (s *Set) Add(entry string) error {
+	network, err := ParseNetwork(entry)
+	if err != nil {
+		return err
+	}
+	s.tree.Insert(network, nil)
+	return nil
+}
+
+// Contains reports whether ip is in the set
+func (s *Set) Contains(ip net.IP) bool {
+	return s.tree.Contains(ip)
+}
+
+// Len returns the number of prefixes in the set
+func (s *Set) Len() int {
+	return s.tree.Len()
+}
+
+// ParseNetwork parses a CIDR range or a single address as a host prefix
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %s: %w", entry, err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ParseSet parses one CIDR or address per line. Blank lines and text after
+// '#' are ignored.
+func ParseSet(name string, data []byte) (*Set, error) {
+	set := NewSet(name)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if index := strings.IndexByte(line, '#'); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := set.Add(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// LoadSet reads a set from a file with one CIDR per line
+func LoadSet(name, path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IP set file: %w", err)
+	}
+	set, err := ParseSet(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse IP set file %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// Registry holds named IP sets loaded from files and reloads each file when it changes
+type Registry struct {
+	mu         sync.RWMutex
+	sets       map[string]*Set
+	files      map[string]*setFile
+	stopWatch  chan bool
+	pollTicker *time.Ticker
+}
+
+// setFile tracks the file a set was loaded from
+type setFile struct {
+	name    string
+	path    string
+	modTime time.Time
+}
+
+// NewRegistry loads the configured IP set files
+func NewRegistry(configs []types.IPSetConfig) (*Registry, error) {
+	registry := &Registry{
+		sets:      make(map[string]*Set),
+		files:     make(map[string]*setFile),
+		stopWatch: make(chan bool, 1),
+	}
+
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("IP set for file %s has no name", config.File)
+		}
+		if _, exists := registry.files[config.Name]; exists {
+			return nil, fmt.Errorf("duplicate IP set name: %s", config.Name)
+		}
+
+		file := &setFile{name: config.Name, path: config.File}
+		if err := registry.loadFile(file); err != nil {
+			return nil, err
+		}
+		registry.files[config.Name] = file
+	}
+
+	return registry, nil
+}
+
+// loadFile (re)loads a set file if it changed since it was last read
+func (r *Registry) loadFile(file *setFile) error {
+	fileInfo, err := os.Stat(file.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat IP set file %s: %w", file.path, err)
+	}
+
+	if !fileInfo.ModTime().After(file.modTime) {
+		return nil
+	}
+
+	set, err := LoadSet(file.name, file.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.sets[file.name] = set
+	file.modTime = fileInfo.ModTime()
+	r.mu.Unlock()
+
+	log.Printf("Loaded IP set %s with %d entries from %s", file.name, set.Len(), file.path)
+	return nil
+}
+
+// Set stores a set under its name, replacing any set with the same name
+func (r *Registry) Set(set *Set) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sets[set.Name] = set
+}
+
+// Get returns the set with the given name
+func (r *Registry) Get(name string) (*Set, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set, exists := r.sets[name]
+	return set, exists
+}
+
+// Contains reports whether ip is in the named set; exists is false when no
+// set has that name
+func (r *Registry) Contains(name string, ip net.IP) (matched bool, exists bool) {
+	set, exists := r.Get(name)
+	if !exists {
+		return false, false
+	}
+	return set.Contains(ip), true
+}
+
+// Reload reloads every set file that changed. A file that fails to load
+// keeps its previous contents and does not prevent other sets from reloading.
+func (r *Registry) Reload() error {
+	var errs []string
+	for _, file := range r.files {
+		if err := r.loadFile(file); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to reload IP sets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// StartFileWatcher polls the set files for changes
+func (r *Registry) StartFileWatcher(interval time.Duration) {
+	if len(r.files) == 0 || interval <= 0 {
+		return
+	}
+
+	r.pollTicker = time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-r.pollTicker.C:
+				if err := r.Reload(); err != nil {
+					log.Printf("Error reloading IP sets: %v", err)
+				}
+			case <-r.stopWatch:
+				r.pollTicker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the set files
+func (r *Registry) Close() {
+	if r.pollTicker != nil {
+		select {
+		case r.stopWatch <- true:
+		default:
+		}
+	}
+}
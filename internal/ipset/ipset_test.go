@@ -0,0 +1,119 @@
+package ipset
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+func TestParseSet(t *testing.T) {
+	data := []byte(`
+# Known bad networks
+203.0.113.0/24
+198.51.100.7     # single host
+2001:db8::/48
+`)
+
+	set, err := ParseSet("denylist", data)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if set.Len() != 3 {
+		t.Errorf("Expected 3 entries, got %d", set.Len())
+	}
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"203.0.113.50", true},
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"2001:db8:0:1::1", true},
+		{"2001:db8:1::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := set.Contains(net.ParseIP(tt.ip)); got != tt.expected {
+			t.Errorf("Contains(%s) = %v, expected %v", tt.ip, got, tt.expected)
+		}
+	}
+}
+
+func TestParseSet_InvalidLine(t *testing.T) {
+	_, err := ParseSet("bad", []byte("10.0.0.0/8\nnot-an-ip\n"))
+	if err == nil {
+		t.Fatal("Expected error for invalid entry")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected error to mention line 2, got: %v", err)
+	}
+}
+
+func TestRegistry_LoadAndReload(t *testing.T) {
+	tempDir := t.TempDir()
+	setFile := filepath.Join(tempDir, "denylist.txt")
+	if err := os.WriteFile(setFile, []byte("10.0.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry([]types.IPSetConfig{{Name: "denylist", File: setFile}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer registry.Close()
+
+	matched, exists := registry.Contains("denylist", net.ParseIP("10.1.1.1"))
+	if !exists || !matched {
+		t.Errorf("Expected 10.1.1.1 in denylist, got matched=%v exists=%v", matched, exists)
+	}
+
+	if _, exists := registry.Contains("unknown", net.ParseIP("10.1.1.1")); exists {
+		t.Errorf("Expected unknown set not to exist")
+	}
+
+	// Update the file; an invalid update keeps the previous contents
+	future := time.Now().Add(time.Minute)
+	if err := os.WriteFile(setFile, []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(setFile, future, future)
+	if err := registry.Reload(); err == nil {
+		t.Errorf("Expected error reloading invalid set file")
+	}
+	if matched, _ := registry.Contains("denylist", net.ParseIP("10.1.1.1")); !matched {
+		t.Errorf("Expected previous set contents to be kept after failed reload")
+	}
+
+	future = future.Add(time.Minute)
+	if err := os.WriteFile(setFile, []byte("172.16.0.0/12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(setFile, future, future)
+	if err := registry.Reload(); err != nil {
+		t.Fatalf("Expected no error reloading, got: %v", err)
+	}
+
+	if matched, _ := registry.Contains("denylist", net.ParseIP("10.1.1.1")); matched {
+		t.Errorf("Expected 10.1.1.1 to be removed after reload")
+	}
+	if matched, _ := registry.Contains("denylist", net.ParseIP("172.20.0.1")); !matched {
+		t.Errorf("Expected 172.20.0.1 in reloaded set")
+	}
+}
+
+func TestNewRegistry_Errors(t *testing.T) {
+	if _, err := NewRegistry([]types.IPSetConfig{{Name: "missing", File: "/non/existent.txt"}}); err == nil {
+		t.Errorf("Expected error for missing set file")
+	}
+
+	if _, err := NewRegistry([]types.IPSetConfig{{File: "set.txt"}}); err == nil {
+		t.Errorf("Expected error for unnamed set")
+	}
+}
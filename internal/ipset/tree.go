@@ -0,0 +1,178 @@
+package ipset
+
+import (
+	"net"
+)
+
+// Tree is a path-compressed binary radix tree of IP prefixes. IPv4 and IPv6
+// prefixes are kept in separate trees so IPv4 addresses never match IPv6
+// prefixes such as ::/0. A Tree is not safe for concurrent modification.
+type Tree struct {
+	root4 *node
+	root6 *node
+	size  int
+}
+
+// node is a prefix of bits length; nodes without a value are branch points
+type node struct {
+	key      [16]byte
+	bits     int
+	children [2]*node
+	hasValue bool
+	value    interface{}
+}
+
+// NewTree creates an empty prefix tree
+func NewTree() *Tree {
+	return &Tree{}
+}
+
+// Len returns the number of prefixes stored in the tree
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Insert stores value for network, replacing any value already stored for it
+func (t *Tree) Insert(network *net.IPNet, value interface{}) {
+	key, bits, root := t.prefixKey(network)
+	if root == nil {
+		return
+	}
+
+	current := root
+	for {
+		if *current == nil {
+			*current = &node{key: key, bits: bits, hasValue: true, value: value}
+			t.size++
+			return
+		}
+
+		n := *current
+		common := commonPrefixLen(n.key, key, min(n.bits, bits))
+		if common < n.bits {
+			// Split n at the first differing bit
+			split := &node{key: maskKey(key, common), bits: common}
+			split.children[bitAt(n.key, common)] = n
+			*current = split
+			if common == bits {
+				split.hasValue = true
+				split.value = value
+			} else {
+				split.children[bitAt(key, common)] = &node{key: key, bits: bits, hasValue: true, value: value}
+			}
+			t.size++
+			return
+		}
+
+		if n.bits == bits {
+			if !n.hasValue {
+				t.size++
+			}
+			n.hasValue = true
+			n.value = value
+			return
+		}
+
+		current = &n.children[bitAt(key, n.bits)]
+	}
+}
+
+// Contains reports whether ip is covered by any prefix in the tree
+func (t *Tree) Contains(ip net.IP) bool {
+	_, found := t.Lookup(ip)
+	return found
+}
+
+// Lookup returns the value of the longest prefix covering ip
+func (t *Tree) Lookup(ip net.IP) (interface{}, bool) {
+	var value interface{}
+	found := false
+	t.Walk(ip, func(network *net.IPNet, v interface{}) bool {
+		value, found = v, true
+		return true
+	})
+	return value, found
+}
+
+// Walk calls fn for every prefix covering ip, from the shortest to the longest
+// prefix, until fn returns false
+func (t *Tree) Walk(ip net.IP, fn func(network *net.IPNet, value interface{}) bool) {
+	key, addrBits, n := t.addressKey(ip)
+	for n != nil {
+		if commonPrefixLen(n.key, key, n.bits) < n.bits {
+			return
+		}
+		if n.hasValue && !fn(n.network(addrBits), n.value) {
+			return
+		}
+		if n.bits == addrBits {
+			return
+		}
+		n = n.children[bitAt(key, n.bits)]
+	}
+}
+
+// prefixKey returns the key, prefix length and root pointer for network
+func (t *Tree) prefixKey(network *net.IPNet) ([16]byte, int, **node) {
+	var key [16]byte
+	ones, bits := network.Mask.Size()
+	if ip4 := network.IP.To4(); ip4 != nil && bits == 32 {
+		copy(key[:], ip4)
+		return maskKey(key, ones), ones, &t.root4
+	}
+	if ip6 := network.IP.To16(); ip6 != nil && bits == 128 {
+		copy(key[:], ip6)
+		return maskKey(key, ones), ones, &t.root6
+	}
+	return key, 0, nil
+}
+
+// addressKey returns the key, address length and root node for ip
+func (t *Tree) addressKey(ip net.IP) ([16]byte, int, *node) {
+	var key [16]byte
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(key[:], ip4)
+		return key, 32, t.root4
+	}
+	if ip6 := ip.To16(); ip6 != nil {
+		copy(key[:], ip6)
+		return key, 128, t.root6
+	}
+	return key, 0, nil
+}
+
+// network converts a node back to the prefix it represents
+func (n *node) network(addrBits int) *net.IPNet {
+	ip := make(net.IP, addrBits/8)
+	copy(ip, n.key[:])
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(n.bits, addrBits)}
+}
+
+// bitAt returns bit i (0 = most significant) of key
+func bitAt(key [16]byte, i int) int {
+	return int(key[i>>3]>>(7-uint(i&7))) & 1
+}
+
+// maskKey clears all bits of key after the first bits
+func maskKey(key [16]byte, bits int) [16]byte {
+	var masked [16]byte
+	for i := 0; i < 16; i++ {
+		switch {
+		case bits >= (i+1)*8:
+			masked[i] = key[i]
+		case bits > i*8:
+			masked[i] = key[i] & (0xFF << (8 - uint(bits-i*8)))
+		}
+	}
+	return masked
+}
+
+// commonPrefixLen returns the number of leading bits, up to limit, shared by a and b
+func commonPrefixLen(a, b [16]byte, limit int) int {
+	for i := 0; i < limit; i++ {
+		if bitAt(a, i) != bitAt(b, i) {
+			return i
+		}
+	}
+	return limit
+}
@@ -0,0 +1,107 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t testing.TB, cidr string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network
+}
+
+func TestTree_Lookup(t *testing.T) {
+	tree := NewTree()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "192.168.1.0/24", "2001:db8::/32", "::/0"} {
+		tree.Insert(mustParseCIDR(t, cidr), cidr)
+	}
+
+	if tree.Len() != 6 {
+		t.Errorf("Expected 6 prefixes, got %d", tree.Len())
+	}
+
+	tests := []struct {
+		ip           string
+		expectPrefix string
+	}{
+		{"10.9.9.9", "10.0.0.0/8"},
+		{"10.1.9.9", "10.1.0.0/16"},
+		{"10.1.2.3", "10.1.2.0/24"},
+		{"192.168.1.255", "192.168.1.0/24"},
+		{"192.168.2.1", ""},
+		{"11.0.0.1", ""},
+		{"2001:db8:1::1", "2001:db8::/32"},
+		{"2001:db9::1", "::/0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			value, found := tree.Lookup(net.ParseIP(tt.ip))
+			if tt.expectPrefix == "" {
+				if found {
+					t.Errorf("Expected no match, got %v", value)
+				}
+				return
+			}
+			if !found || value != tt.expectPrefix {
+				t.Errorf("Expected longest match %s, got %v (found %v)", tt.expectPrefix, value, found)
+			}
+		})
+	}
+}
+
+func TestTree_Walk(t *testing.T) {
+	tree := NewTree()
+	for _, cidr := range []string{"10.1.2.0/24", "10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16"} {
+		tree.Insert(mustParseCIDR(t, cidr), cidr)
+	}
+
+	var matches []string
+	tree.Walk(net.ParseIP("10.1.2.3"), func(network *net.IPNet, value interface{}) bool {
+		if network.String() != value {
+			t.Errorf("Walk reported network %s for value %v", network, value)
+		}
+		matches = append(matches, value.(string))
+		return true
+	})
+
+	expected := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}
+	if fmt.Sprint(matches) != fmt.Sprint(expected) {
+		t.Errorf("Expected matches %v, got %v", expected, matches)
+	}
+}
+
+func TestTree_InsertReplace(t *testing.T) {
+	tree := NewTree()
+	tree.Insert(mustParseCIDR(t, "10.0.0.0/8"), "first")
+	tree.Insert(mustParseCIDR(t, "10.0.0.0/8"), "second")
+
+	if tree.Len() != 1 {
+		t.Errorf("Expected 1 prefix, got %d", tree.Len())
+	}
+	if value, _ := tree.Lookup(net.ParseIP("10.0.0.1")); value != "second" {
+		t.Errorf("Expected replaced value, got %v", value)
+	}
+}
+
+func BenchmarkTree_Contains(b *testing.B) {
+	tree := NewTree()
+	for i := 0; i < 50000; i++ {
+		network := &net.IPNet{
+			IP:   net.IPv4(byte(i>>16)+1, byte(i>>8), byte(i), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}
+		tree.Insert(network, nil)
+	}
+	ip := net.ParseIP("1.150.200.7")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Contains(ip)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"http-proxy/pkg/types"
@@ -29,6 +30,7 @@ type AuditEvent struct {
 	Timestamp    time.Time           `json:"timestamp"`
 	RequestID    string              `json:"request_id"`
 	ClientIP     string              `json:"client_ip"`
+	PeerIP       string              `json:"peer_ip,omitempty"` // connecting peer when it differs from the client
 	Method       string              `json:"method"`
 	URL          string              `json:"url"`
 	UserAgent    string              `json:"user_agent"`
@@ -40,14 +42,23 @@ type AuditEvent struct {
 	ResponseCode int                 `json:"response_code,omitempty"`
 	ResponseSize int64               `json:"response_size,omitempty"`
 	Headers      map[string][]string `json:"headers,omitempty"`
+	Country      string              `json:"country,omitempty"`
+	ASN          uint32              `json:"asn,omitempty"`
+
+	ShadowRuleMatched string       `json:"shadow_rule_matched,omitempty"`
+	ShadowAction      types.Action `json:"shadow_action,omitempty"`
+
+	RuleSetVersion int `json:"ruleset_version,omitempty"` // version of the rules that made the decision
 }
 
 // Logger represents the proxy logger
 type Logger struct {
 	appLogger   *log.Logger
 	auditLogger *log.Logger
-	level       LogLevel
 	config      *types.LoggingConfig
+
+	mu    sync.RWMutex // guards level, which can change at runtime
+	level LogLevel
 }
 
 // NewLogger creates a new logger instance
@@ -174,6 +185,36 @@ func (l *Logger) LogRequest(requestID, clientIP, method, url, userAgent string,
 	result *types.RuleResult, duration time.Duration, responseCode int, responseSize int64,
 	headers map[string][]string) {
 
+	l.LogAuditEvent(l.requestEvent(requestID, clientIP, method, url, userAgent, requestSize,
+		result, duration, responseCode, responseSize, headers))
+}
+
+// RequestLogOptions holds the audit details LogRequestWithOptions records
+// in addition to those of LogRequest
+type RequestLogOptions struct {
+	PeerIP string // connecting peer, recorded when a trusted proxy forwarded the request
+}
+
+// LogRequestWithOptions logs a request like LogRequest, adding the peer IP
+func (l *Logger) LogRequestWithOptions(requestID, clientIP, method, url, userAgent string, requestSize int64,
+	result *types.RuleResult, duration time.Duration, responseCode int, responseSize int64,
+	headers map[string][]string, opts RequestLogOptions) {
+
+	event := l.requestEvent(requestID, clientIP, method, url, userAgent, requestSize,
+		result, duration, responseCode, responseSize, headers)
+
+	if opts.PeerIP != "" && opts.PeerIP != clientIP {
+		event.PeerIP = opts.PeerIP
+	}
+
+	l.LogAuditEvent(event)
+}
+
+// requestEvent builds the audit event of a request/response cycle
+func (l *Logger) requestEvent(requestID, clientIP, method, url, userAgent string, requestSize int64,
+	result *types.RuleResult, duration time.Duration, responseCode int, responseSize int64,
+	headers map[string][]string) *AuditEvent {
+
 	event := &AuditEvent{
 		Timestamp:    time.Now().UTC(),
 		RequestID:    requestID,
@@ -193,12 +234,24 @@ func (l *Logger) LogRequest(requestID, clientIP, method, url, userAgent string,
 		event.RuleMatched = result.Rule.ID
 	}
 
+	if result.ShadowRule != nil {
+		event.ShadowRuleMatched = result.ShadowRule.ID
+		event.ShadowAction = result.ShadowRule.Action
+	}
+
+	if result.Geo != nil {
+		event.Country = result.Geo.Country
+		event.ASN = result.Geo.ASN
+	}
+
+	event.RuleSetVersion = result.RuleSetVersion
+
 	// Only include headers if debug level
 	if l.shouldLog(LevelDebug) {
 		event.Headers = headers
 	}
 
-	l.LogAuditEvent(event)
+	return event
 }
 
 // LogRuleAction logs when a rule action is taken
@@ -244,7 +297,7 @@ func (l *Logger) shouldLog(level LogLevel) bool {
 		LevelError: 3,
 	}
 
-	configuredLevel, exists := levelOrder[l.level]
+	configuredLevel, exists := levelOrder[LogLevel(l.GetLevel())]
 	if !exists {
 		configuredLevel = levelOrder[LevelInfo] // Default to info
 	}
@@ -266,12 +319,16 @@ func (l *Logger) Close() error {
 
 // SetLevel changes the logging level at runtime
 func (l *Logger) SetLevel(level string) {
+	l.mu.Lock()
 	l.level = LogLevel(level)
+	l.mu.Unlock()
 	l.Info("Log level changed to: %s", level)
 }
 
 // GetLevel returns the current logging level
 func (l *Logger) GetLevel() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return string(l.level)
 }
 
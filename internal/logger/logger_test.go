@@ -327,6 +327,104 @@ func TestLogger_LogRequest(t *testing.T) {
 	}
 }
 
+func TestLogger_LogRequest_RuleContext(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := NewLogger(&types.LoggingConfig{Level: "info", AuditEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	logger.auditLogger.SetOutput(&buf)
+
+	result := &types.RuleResult{
+		Action:     types.ActionAllow,
+		Reason:     "no rules matched, using default action",
+		Geo:        &types.GeoInfo{Country: "NL", ASN: 1136},
+		ShadowRule: &types.Rule{ID: "new-block-rule", Action: types.ActionBlock},
+
+		RuleSetVersion: 7,
+	}
+
+	logger.LogRequest("req-788", "203.0.113.7", "GET", "/", "curl/8.0", 0, result, time.Millisecond, 200, 10, nil)
+
+	var event AuditEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Logged request should be valid JSON: %v", err)
+	}
+
+	if event.Country != "NL" || event.ASN != 1136 {
+		t.Errorf("Expected country NL and ASN 1136, got %s and %d", event.Country, event.ASN)
+	}
+	if event.ShadowRuleMatched != "new-block-rule" || event.ShadowAction != types.ActionBlock {
+		t.Errorf("Expected shadow match of new-block-rule with action block, got %s/%s", event.ShadowRuleMatched, event.ShadowAction)
+	}
+	if event.RuleSetVersion != 7 {
+		t.Errorf("Expected ruleset version 7, got %d", event.RuleSetVersion)
+	}
+	if event.PeerIP != "" {
+		t.Errorf("Expected no peer IP, got %s", event.PeerIP)
+	}
+}
+
+func TestLogger_LogRequestWithOptions(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := NewLogger(&types.LoggingConfig{Level: "info", AuditEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	logger.auditLogger.SetOutput(&buf)
+
+	result := &types.RuleResult{
+		Action:     types.ActionAllow,
+		Reason:     "no rules matched, using default action",
+		Geo:        &types.GeoInfo{Country: "NL", ASN: 1136},
+		ShadowRule: &types.Rule{ID: "new-block-rule", Action: types.ActionBlock},
+
+		RuleSetVersion: 7,
+	}
+
+	logger.LogRequestWithOptions("req-789", "203.0.113.7", "GET", "/", "curl/8.0", 0, result, time.Millisecond, 200, 10, nil,
+		RequestLogOptions{PeerIP: "10.0.0.1"})
+
+	var event AuditEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Logged request should be valid JSON: %v", err)
+	}
+
+	if event.ClientIP != "203.0.113.7" || event.PeerIP != "10.0.0.1" {
+		t.Errorf("Expected client IP 203.0.113.7 via peer 10.0.0.1, got %s via %s", event.ClientIP, event.PeerIP)
+	}
+	if event.Country != "NL" || event.ASN != 1136 {
+		t.Errorf("Expected country NL and ASN 1136, got %s and %d", event.Country, event.ASN)
+	}
+	if event.ShadowRuleMatched != "new-block-rule" || event.ShadowAction != types.ActionBlock {
+		t.Errorf("Expected shadow match of new-block-rule with action block, got %s/%s", event.ShadowRuleMatched, event.ShadowAction)
+	}
+	if !strings.Contains(buf.String(), `"shadow_rule_matched":"new-block-rule"`) {
+		t.Errorf("Expected shadow_rule_matched field in audit log, got %s", buf.String())
+	}
+	if event.RuleSetVersion != 7 {
+		t.Errorf("Expected ruleset version 7, got %d", event.RuleSetVersion)
+	}
+
+	// The peer of a direct client is not recorded
+	buf.Reset()
+	logger.LogRequestWithOptions("req-790", "10.0.0.2", "GET", "/", "curl/8.0", 0, result, time.Millisecond, 200, 10, nil,
+		RequestLogOptions{PeerIP: "10.0.0.2"})
+	event = AuditEvent{}
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Logged request should be valid JSON: %v", err)
+	}
+	if event.PeerIP != "" {
+		t.Errorf("Expected no peer IP for a direct client, got %s", event.PeerIP)
+	}
+}
+
 func TestLogger_LogProxyError(t *testing.T) {
 	var appBuf, auditBuf bytes.Buffer
 
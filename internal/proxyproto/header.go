@@ -0,0 +1,241 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol, versions 1 (text) and 2 (binary), which load balancers use to pass
+// the original client address of a TCP connection.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Command is the command of a version 2 header
+type Command byte
+
+const (
+	CommandLocal Command = 0x0 // health check or connection from the proxy itself; addresses are not used
+	CommandProxy Command = 0x1 // connection relayed on behalf of a client
+)
+
+// Well-known TLV types of version 2 headers
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+)
+
+// TLV is a type-length-value extension of a version 2 header
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a parsed PROXY protocol header
+type Header struct {
+	Version int
+	Command Command
+
+	// Source and Destination are nil for LOCAL commands, UNKNOWN connections
+	// and address families other than TCP or UDP over IPv4 and IPv6
+	Source      net.Addr
+	Destination net.Addr
+
+	TLVs []TLV
+}
+
+// TLV returns the value of the first TLV of the given type
+func (h *Header) TLV(tlvType byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == tlvType {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ErrNoHeader is returned when a connection does not start with a PROXY header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+var (
+	signatureV1 = []byte("PROXY ")
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// maxHeaderV1 is the longest valid version 1 header, including the CRLF
+const maxHeaderV1 = 107
+
+// ReadHeader reads a version 1 or 2 header from r
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(signatureV1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if bytes.Equal(prefix, signatureV1) {
+		return readHeaderV1(r)
+	}
+
+	prefix, err = r.Peek(len(signatureV2))
+	if err == nil && bytes.Equal(prefix, signatureV2) {
+		return readHeaderV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readHeaderV1 parses "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readHeaderV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY protocol v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxHeaderV1 {
+			return nil, fmt.Errorf("PROXY protocol v1 header exceeds %d bytes", maxHeaderV1)
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY protocol v1 header does not end with CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &Header{Version: 1, Command: CommandProxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+
+	var wantV4 bool
+	switch fields[1] {
+	case "TCP4":
+		wantV4 = true
+	case "TCP6":
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v1 protocol %q", fields[1])
+	}
+
+	source, err := parseAddrV1(fields[2], fields[4], wantV4)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseAddrV1(fields[3], fields[5], wantV4)
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = source, destination
+	return header, nil
+}
+
+func parseAddrV1(address, port string, wantV4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(address)
+	if ip == nil || (ip.To4() != nil) != wantV4 || (wantV4 && strings.Contains(address, ":")) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 address %q", address)
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 port %q", port)
+	}
+	if wantV4 {
+		ip = ip.To4()
+	}
+	return &net.TCPAddr{IP: ip, Port: int(number)}, nil
+}
+
+// readHeaderV2 parses a binary header
+func readHeaderV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol v2 header: %w", err)
+	}
+
+	if version := fixed[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := Command(fixed[12] & 0x0f)
+	if command != CommandLocal && command != CommandProxy {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 command %d", command)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol v2 addresses: %w", err)
+	}
+
+	header := &Header{Version: 2, Command: command}
+
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	var addressLen int
+	switch family {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		addressLen = 12
+	case 0x2: // AF_INET6
+		addressLen = 36
+	case 0x3: // AF_UNIX
+		addressLen = 216
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 address family %d", family)
+	}
+	if len(payload) < addressLen {
+		return nil, fmt.Errorf("PROXY protocol v2 address block is %d bytes, need %d", len(payload), addressLen)
+	}
+
+	if command == CommandProxy && (family == 0x1 || family == 0x2) {
+		ipLen := 4
+		if family == 0x2 {
+			ipLen = 16
+		}
+		src := net.IP(append([]byte(nil), payload[:ipLen]...))
+		dst := net.IP(append([]byte(nil), payload[ipLen:2*ipLen]...))
+		srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+		dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+
+		switch transport {
+		case 0x1: // STREAM
+			header.Source = &net.TCPAddr{IP: src, Port: srcPort}
+			header.Destination = &net.TCPAddr{IP: dst, Port: dstPort}
+		case 0x2: // DGRAM
+			header.Source = &net.UDPAddr{IP: src, Port: srcPort}
+			header.Destination = &net.UDPAddr{IP: dst, Port: dstPort}
+		}
+	}
+
+	tlvs, err := parseTLVs(payload[addressLen:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	return header, nil
+}
+
+// parseTLVs parses the TLV vector following the addresses
+func parseTLVs(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("truncated PROXY protocol v2 TLV")
+		}
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, fmt.Errorf("PROXY protocol v2 TLV of type 0x%02x exceeds the header", data[0])
+		}
+		tlvs = append(tlvs, TLV{Type: data[0], Value: append([]byte(nil), data[3:3+length]...)})
+		data = data[3+length:]
+	}
+	return tlvs, nil
+}
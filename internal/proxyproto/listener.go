@@ -0,0 +1,159 @@
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"http-proxy/internal/ipset"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its header
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener accepts connections carrying a PROXY protocol header. Headers are
+// only read from peers in the allowed source ranges, which must send one;
+// connections from other peers are returned unchanged.
+type Listener struct {
+	net.Listener
+	allowed *ipset.Set
+	timeout time.Duration
+}
+
+// NewListener wraps inner, accepting headers from the given CIDR ranges and
+// addresses. A zero headerTimeout uses DefaultHeaderTimeout.
+func NewListener(inner net.Listener, allowedSources []string, headerTimeout time.Duration) (*Listener, error) {
+	allowed := ipset.NewSet("proxy_protocol")
+	for _, entry := range allowedSources {
+		if err := allowed.Add(strings.TrimSpace(entry)); err != nil {
+			return nil, fmt.Errorf("invalid PROXY protocol source: %w", err)
+		}
+	}
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: inner, allowed: allowed, timeout: headerTimeout}, nil
+}
+
+// Accept waits for the next connection. The header is read on the first call
+// to Read, RemoteAddr, LocalAddr or Header, so a slow peer does not block the
+// accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.allowed.Contains(addrIP(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// Conn is a connection whose addresses are taken from its PROXY header
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu       sync.Mutex
+	deadline time.Time // read deadline set by the caller
+}
+
+// Read reads data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the original client address, or the peer address when
+// the header carries none
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, or the local
+// address when the header carries none
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Header returns the parsed header, or the error reading it
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readHeader reads the header once, bounded by the header timeout and any
+// earlier read deadline set by the caller, which is restored afterwards
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		deadline := time.Now().Add(c.timeout)
+		if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+			deadline = c.deadline
+		}
+		if err := c.Conn.SetReadDeadline(deadline); err != nil {
+			c.err = err
+			return
+		}
+
+		c.header, c.err = ReadHeader(c.reader)
+		if c.err != nil {
+			log.Printf("Rejecting connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+			return
+		}
+		c.err = c.Conn.SetReadDeadline(c.deadline)
+	})
+}
+
+// addrIP returns the IP of a TCP or UDP address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
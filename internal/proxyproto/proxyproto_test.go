@@ -0,0 +1,192 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headerV2 builds a version 2 header with the given command, family and
+// payload
+func headerV2(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), signatureV2...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{203, 0, 113, 5, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(append(net.ParseIP("2001:db8::5").To16(), net.ParseIP("2001:db8::1").To16()...), 0x13, 0x88, 0x00, 0x50)
+	tlvs := []byte{TLVTypeAuthority, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', TLVTypeNoop, 0, 0}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		version  int
+		command  Command
+		source   string
+		dest     string
+		tlvCount int
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\n"), 1, CommandProxy, "203.0.113.5:56324", "10.0.0.1:443", 0},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::5 2001:db8::1 5000 80\r\n"), 1, CommandProxy, "[2001:db8::5]:5000", "[2001:db8::1]:80", 0},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), 1, CommandProxy, "", "", 0},
+		{"v2 TCP4", headerV2(0x1, 0x11, ipv4), 2, CommandProxy, "203.0.113.5:56324", "10.0.0.1:443", 0},
+		{"v2 TCP6", headerV2(0x1, 0x21, ipv6), 2, CommandProxy, "[2001:db8::5]:5000", "[2001:db8::1]:80", 0},
+		{"v2 UDP4", headerV2(0x1, 0x12, ipv4), 2, CommandProxy, "203.0.113.5:56324", "10.0.0.1:443", 0},
+		{"v2 with TLVs", headerV2(0x1, 0x11, append(append([]byte(nil), ipv4...), tlvs...)), 2, CommandProxy, "203.0.113.5:56324", "10.0.0.1:443", 2},
+		{"v2 LOCAL", headerV2(0x0, 0x11, ipv4), 2, CommandLocal, "", "", 0},
+		{"v2 unspecified family", headerV2(0x1, 0x00, nil), 2, CommandProxy, "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.data), strings.NewReader("GET / HTTP/1.1\r\n")))
+			header, err := ReadHeader(reader)
+			if err != nil {
+				t.Fatalf("Failed to read header: %v", err)
+			}
+			if header.Version != tt.version || header.Command != tt.command {
+				t.Errorf("Expected version %d command %d, got %d %d", tt.version, tt.command, header.Version, header.Command)
+			}
+			if got := addrString(header.Source); got != tt.source {
+				t.Errorf("Expected source %q, got %q", tt.source, got)
+			}
+			if got := addrString(header.Destination); got != tt.dest {
+				t.Errorf("Expected destination %q, got %q", tt.dest, got)
+			}
+			if len(header.TLVs) != tt.tlvCount {
+				t.Errorf("Expected %d TLVs, got %d", tt.tlvCount, len(header.TLVs))
+			}
+
+			rest, _ := reader.ReadString('\n')
+			if rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("Expected header to be consumed exactly, got remaining %q", rest)
+			}
+		})
+	}
+
+	header, err := ReadHeader(bufio.NewReader(bytes.NewReader(headerV2(0x1, 0x11, append(ipv4, tlvs...)))))
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if value, ok := header.TLV(TLVTypeAuthority); !ok || string(value) != "example.com" {
+		t.Errorf("Expected authority TLV example.com, got %q", value)
+	}
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	ipv4 := []byte{203, 0, 113, 5, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no header", []byte("GET / HTTP/1.1\r\n\r\n")},
+		{"v1 missing CR", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\n")},
+		{"v1 too long", []byte("PROXY TCP6 " + strings.Repeat("f", 120) + "\r\n")},
+		{"v1 wrong protocol", []byte("PROXY UDP4 203.0.113.5 10.0.0.1 56324 443\r\n")},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::5 10.0.0.1 56324 443\r\n")},
+		{"v1 invalid port", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 65536 443\r\n")},
+		{"v1 missing fields", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 56324\r\n")},
+		{"v2 wrong version", append(append(append([]byte(nil), signatureV2...), 0x11, 0x11, 0, 12), ipv4...)},
+		{"v2 invalid command", headerV2(0x2, 0x11, ipv4)},
+		{"v2 short address block", headerV2(0x1, 0x21, ipv4)},
+		{"v2 truncated payload", headerV2(0x1, 0x11, ipv4)[:20]},
+		{"v2 TLV exceeds header", headerV2(0x1, 0x11, append(append([]byte(nil), ipv4...), TLVTypeNoop, 0, 4, 1))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if header, err := ReadHeader(bufio.NewReader(bytes.NewReader(tt.data))); err == nil {
+				t.Errorf("Expected error, got header %+v", header)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		prefix   string
+		remote   string
+		expected string
+	}{
+		{"allowed source with header", []string{"127.0.0.0/8"}, "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\n", "203.0.113.5:56324", "hello"},
+		{"allowed source with LOCAL header", []string{"127.0.0.1"}, string(headerV2(0x0, 0x00, nil)), "127.0.0.1", "hello"},
+		{"other source is unchanged", []string{"10.0.0.0/8"}, "", "127.0.0.1", "hello"},
+		{"header from other source is not parsed", []string{"10.0.0.0/8"}, "PROXY UNKNOWN\r\n", "127.0.0.1", "PROXY UNKNOWN\r\nhello"},
+		{"allowed source without header is rejected", []string{"127.0.0.0/8"}, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("Cannot listen on loopback: %v", err)
+			}
+			listener, err := NewListener(inner, tt.allowed, time.Second)
+			if err != nil {
+				t.Fatalf("Failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			go func() {
+				client, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return
+				}
+				defer client.Close()
+				client.Write([]byte(tt.prefix + "hello"))
+				io.Copy(io.Discard, client)
+			}()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Failed to accept: %v", err)
+			}
+			defer conn.Close()
+
+			data, err := io.ReadAll(io.LimitReader(conn, int64(len(tt.expected))))
+			if tt.expected == "" {
+				if err == nil && len(data) == 0 {
+					if _, err = conn.Read(make([]byte, 1)); err == nil {
+						t.Error("Expected connection without header to be rejected")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, data)
+			}
+
+			remote := conn.RemoteAddr().String()
+			if !strings.HasPrefix(remote, tt.remote) {
+				t.Errorf("Expected remote address %s, got %s", tt.remote, remote)
+			}
+		})
+	}
+}
+
+func TestNewListener_InvalidSource(t *testing.T) {
+	if _, err := NewListener(nil, []string{"10.0.0.0/33"}, 0); err == nil {
+		t.Error("Expected invalid CIDR range to be rejected")
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,98 @@
+package rules
+
+// ahoCorasick finds all patterns contained in a text in a single pass
+type ahoCorasick struct {
+	nodes []acNode
+}
+
+// acNode is a state of the automaton
+type acNode struct {
+	next    map[byte]int32
+	fail    int32
+	output  []int // rule positions of patterns ending here
+	dictSuf int32 // nearest state on the fail chain with output, or -1
+}
+
+// newAhoCorasick creates an automaton with only the root state
+func newAhoCorasick() *ahoCorasick {
+	return &ahoCorasick{nodes: []acNode{{next: map[byte]int32{}, dictSuf: -1}}}
+}
+
+// add registers pattern for rule position pos; build must be called afterwards
+func (ac *ahoCorasick) add(pattern string, pos int) {
+	state := int32(0)
+	for i := 0; i < len(pattern); i++ {
+		next, ok := ac.nodes[state].next[pattern[i]]
+		if !ok {
+			ac.nodes = append(ac.nodes, acNode{next: map[byte]int32{}, dictSuf: -1})
+			next = int32(len(ac.nodes) - 1)
+			ac.nodes[state].next[pattern[i]] = next
+		}
+		state = next
+	}
+	ac.nodes[state].output = append(ac.nodes[state].output, pos)
+}
+
+// build computes failure and dictionary suffix links breadth-first
+func (ac *ahoCorasick) build() {
+	queue := make([]int32, 0, len(ac.nodes))
+	for _, child := range ac.nodes[0].next {
+		ac.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for b, child := range ac.nodes[state].next {
+			fail := ac.nodes[state].fail
+			for {
+				if next, ok := ac.nodes[fail].next[b]; ok && next != child {
+					fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = ac.nodes[fail].fail
+			}
+			ac.nodes[child].fail = fail
+
+			if len(ac.nodes[fail].output) > 0 {
+				ac.nodes[child].dictSuf = fail
+			} else {
+				ac.nodes[child].dictSuf = ac.nodes[fail].dictSuf
+			}
+			queue = append(queue, child)
+		}
+	}
+}
+
+// search calls emit for the rule positions of every pattern found in text.
+// Patterns may be reported more than once.
+func (ac *ahoCorasick) search(text string, emit func(pos int)) {
+	for _, pos := range ac.nodes[0].output {
+		emit(pos)
+	}
+
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		for {
+			if next, ok := ac.nodes[state].next[text[i]]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = ac.nodes[state].fail
+		}
+
+		for out := state; out > 0; out = ac.nodes[out].dictSuf {
+			for _, pos := range ac.nodes[out].output {
+				emit(pos)
+			}
+		}
+	}
+}
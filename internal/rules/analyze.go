@@ -0,0 +1,397 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"http-proxy/pkg/matcher"
+	"http-proxy/pkg/types"
+)
+
+// FindingKind classifies a ruleset analysis finding
+type FindingKind string
+
+const (
+	FindingShadowed     FindingKind = "shadowed"      // an earlier rule decides every request the rule matches
+	FindingConflict     FindingKind = "conflict"      // allow and block rules at the same priority overlap
+	FindingDuplicate    FindingKind = "duplicate"     // another rule has the same condition and action
+	FindingNeverMatches FindingKind = "never_matches" // the rule cannot match any request
+)
+
+// Finding is a problem found by Analyze
+type Finding struct {
+	Kind        FindingKind `json:"kind"`
+	RuleID      string      `json:"rule_id"`
+	OtherRuleID string      `json:"other_rule_id,omitempty"`
+	Message     string      `json:"message"`
+}
+
+// conditionKind is the shape of a rule condition
+type conditionKind int
+
+const (
+	stringCondition conditionKind = iota
+	networkCondition
+	setCondition
+	sizeCondition
+	asnCondition
+)
+
+// condition is the normalized match condition of a rule
+type condition struct {
+	ruleType types.RuleType
+	key      string // header name or body field
+	kind     conditionKind
+
+	operator      types.MatchOperator
+	value         string
+	caseSensitive bool // prefix/suffix/contains operators normally fold case
+
+	network  *net.IPNet
+	min, max int64 // inclusive size bounds, or the AS number in min
+}
+
+// signature identifies conditions that match exactly the same requests
+func (c condition) signature() string {
+	network := ""
+	if c.network != nil {
+		network = c.network.String()
+	}
+	return fmt.Sprintf("%s|%s|%d|%s|%s|%t|%s|%d|%d",
+		c.ruleType, c.key, c.kind, c.operator, c.value, c.caseSensitive, network, c.min, c.max)
+}
+
+// Analyze reports enabled rules that are shadowed by earlier rules, allow and
+// block rules with overlapping conditions at the same priority, rules with
+// duplicate conditions and rules that can never match. Rules are considered
+// in evaluation order; conditions are compared per rule type, so overlaps
+// between different request fields are not detected. String conditions are
+// compared with the default case handling, not legacy_case_matching.
+func Analyze(rules []types.Rule) []Finding {
+	ordered := make([]types.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled {
+			ordered = append(ordered, rule)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority < ordered[j].Priority
+	})
+
+	var findings []Finding
+	conditions := make([]condition, len(ordered))
+	valid := make([]bool, len(ordered))
+
+	for i, rule := range ordered {
+		if reason := neverMatches(rule); reason != "" {
+			findings = append(findings, Finding{Kind: FindingNeverMatches, RuleID: rule.ID, Message: reason})
+			continue
+		}
+		conditions[i], valid[i] = conditionOf(rule)
+	}
+
+	for j, later := range ordered {
+		if !valid[j] {
+			continue
+		}
+		for i := 0; i < j; i++ {
+			if !valid[i] {
+				continue
+			}
+			if finding, found := comparePair(ordered[i], conditions[i], later, conditions[j]); found {
+				findings = append(findings, finding)
+				break
+			}
+		}
+	}
+
+	return findings
+}
+
+// comparePair checks a later rule against an earlier one
+func comparePair(earlier types.Rule, a condition, later types.Rule, b condition) (Finding, bool) {
+	samePriority := earlier.Priority == later.Priority
+
+	if a.signature() == b.signature() {
+		switch {
+		case earlier.Action == later.Action:
+			return Finding{
+				Kind:        FindingDuplicate,
+				RuleID:      later.ID,
+				OtherRuleID: earlier.ID,
+				Message:     fmt.Sprintf("same condition and action as rule %s", earlier.ID),
+			}, true
+		case samePriority:
+			return conflict(earlier, later), true
+		}
+	} else if samePriority && earlier.Action != later.Action && (covers(a, b) || covers(b, a)) {
+		return conflict(earlier, later), true
+	}
+
+	if !samePriority && alwaysDecides(earlier) && covers(a, b) {
+		message := fmt.Sprintf("every request it matches is already matched by rule %s (priority %d)", earlier.ID, earlier.Priority)
+		if earlier.Action != later.Action {
+			message += fmt.Sprintf(", which %ss it", earlier.Action)
+		}
+		return Finding{Kind: FindingShadowed, RuleID: later.ID, OtherRuleID: earlier.ID, Message: message}, true
+	}
+
+	return Finding{}, false
+}
+
+func conflict(earlier, later types.Rule) Finding {
+	return Finding{
+		Kind:        FindingConflict,
+		RuleID:      later.ID,
+		OtherRuleID: earlier.ID,
+		Message: fmt.Sprintf("overlaps rule %s at priority %d with the opposite action; which one applies is undefined",
+			earlier.ID, earlier.Priority),
+	}
+}
+
+// alwaysDecides reports whether a matching rule always decides the request
+func alwaysDecides(rule types.Rule) bool {
+	return rule.Mode != types.RuleModeShadow && rule.Schedule == nil && rule.ExpiresAt == nil && rule.TTL == 0
+}
+
+// neverMatches returns why a rule cannot match any request, or ""
+func neverMatches(rule types.Rule) string {
+	operators, known := ruleOperators[rule.Type]
+	if def, custom := matcher.Lookup(rule.Type); !known && custom {
+		if _, problems := validateCustomRule(rule, def); len(problems) > 0 {
+			return problems[0]
+		}
+		return ""
+	}
+	if !known {
+		return fmt.Sprintf("unknown rule type %q", rule.Type)
+	}
+	if !hasOperator(operators, rule.Operator) {
+		return fmt.Sprintf("operator %q is not supported by rule type %s", rule.Operator, rule.Type)
+	}
+	if rule.Schedule != nil {
+		if _, err := compileSchedule(rule.Schedule); err != nil {
+			return fmt.Sprintf("invalid schedule: %v", err)
+		}
+	}
+
+	switch rule.Type {
+	case types.RuleTypeIPv4, types.RuleTypeIPv6:
+		if problems := validateIPRule(rule); len(problems) > 0 {
+			return problems[0]
+		}
+		if rule.Operator == types.MatchEquals {
+			if canonical := net.ParseIP(rule.Value).String(); canonical != rule.Value {
+				return fmt.Sprintf("IP address %s is compared as text and is not in canonical form %s", rule.Value, canonical)
+			}
+		}
+		return ""
+	case types.RuleTypeSize:
+		if problems := validateSizeRule(rule); len(problems) > 0 {
+			return problems[0]
+		}
+		if rule.Operator == types.MatchLTE && *rule.MaxSize < 0 {
+			return fmt.Sprintf("max_size %d is negative", *rule.MaxSize)
+		}
+		return ""
+	case types.RuleTypeHeader:
+		if rule.HeaderName == "" {
+			return "header rule has no header_name"
+		}
+	case types.RuleTypeJSONField, types.RuleTypeFormField:
+		if rule.Field == "" {
+			return fmt.Sprintf("%s rule has no field", rule.Type)
+		}
+	case types.RuleTypeCountry:
+		if rule.Operator == types.MatchEquals && len(rule.Value) != 2 {
+			return fmt.Sprintf("country %q is not a two-letter ISO code", rule.Value)
+		}
+	case types.RuleTypeASN:
+		if rule.Operator == types.MatchEquals {
+			if _, err := parseASN(rule.Value); err != nil {
+				return fmt.Sprintf("invalid ASN %q", rule.Value)
+			}
+		}
+	case types.RuleTypeExpression:
+		if _, err := compileExpression(rule.Value); err != nil {
+			return fmt.Sprintf("invalid expression: %v", err)
+		}
+		return ""
+	}
+
+	switch pattern := rulePattern(rule); positiveOperator(rule.Operator) {
+	case types.MatchRegex:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Sprintf("invalid regex %q: %v", pattern, err)
+		}
+	case types.MatchWildcard:
+		if _, err := compileGlob(pattern, false, true); err != nil {
+			return fmt.Sprintf("invalid wildcard pattern %q: %v", pattern, err)
+		}
+	}
+	return ""
+}
+
+// conditionOf normalizes the condition of a rule that can match. Expressions,
+// custom rule types and negated, list and presence operators are not
+// normalized and are not compared.
+func conditionOf(rule types.Rule) (condition, bool) {
+	c := condition{ruleType: rule.Type, operator: rule.Operator}
+
+	switch {
+	case rule.Type == types.RuleTypeExpression, ruleOperators[rule.Type] == nil:
+		return c, false
+	case isNegated(rule.Operator), rule.Operator == types.MatchIn,
+		rule.Operator == types.MatchExists, rule.Operator == types.MatchNotExists:
+		return c, false
+	}
+
+	switch rule.Type {
+	case types.RuleTypeIPv4, types.RuleTypeIPv6:
+		if rule.Operator == types.MatchInSet {
+			c.kind, c.value = setCondition, rule.Value
+			return c, true
+		}
+		network, err := ipRuleNetwork(rule)
+		if err != nil {
+			return c, false
+		}
+		c.kind, c.operator, c.network = networkCondition, types.MatchInRange, network
+		return c, true
+
+	case types.RuleTypeSize:
+		c.kind, c.operator = sizeCondition, types.MatchInRange
+		c.min, c.max = math.MinInt64, math.MaxInt64
+		switch rule.Operator {
+		case types.MatchGTE:
+			c.min = *rule.MinSize
+		case types.MatchLTE:
+			c.max = *rule.MaxSize
+		case types.MatchInRange:
+			c.min, c.max = *rule.MinSize, *rule.MaxSize
+		case types.MatchEquals:
+			size, _ := strconv.ParseInt(rule.Value, 10, 64)
+			c.min, c.max = size, size
+		}
+		return c, true
+
+	case types.RuleTypeASN:
+		if rule.Operator == types.MatchEquals {
+			asn, _ := parseASN(rule.Value)
+			c.kind, c.min = asnCondition, int64(asn)
+			return c, true
+		}
+
+	case types.RuleTypeHeader:
+		c.key = strings.ToLower(rule.HeaderName)
+
+	case types.RuleTypeJSONField, types.RuleTypeFormField:
+		c.key = rule.Field
+	}
+
+	// Mirror matchStringValueDirect: normalize the field, then fold case
+	// unless the rule is case-sensitive. Regex case folding happens in matchesValue.
+	c.kind, c.value = stringCondition, rulePattern(rule)
+	c.caseSensitive = rule.CaseSensitive != nil && *rule.CaseSensitive
+	if rule.Operator != types.MatchRegex {
+		c.value = normalizeValue(rule.Type, c.value)
+		if rule.Type == types.RuleTypeCountry {
+			c.value = strings.ToUpper(c.value)
+		}
+		if !c.caseSensitive {
+			c.value = strings.ToLower(c.value)
+		}
+	}
+	if rule.Operator == types.MatchEquals && rule.Type == types.RuleTypeURISuffix {
+		// uri_suffix "equals" is a suffix match
+		c.operator = types.MatchEndsWith
+	}
+	return c, true
+}
+
+// covers reports whether every request matched by condition b is also
+// matched by condition a. It errs on the side of false.
+func covers(a, b condition) bool {
+	if a.ruleType != b.ruleType || a.key != b.key || a.kind != b.kind {
+		return false
+	}
+
+	switch a.kind {
+	case networkCondition:
+		aOnes, aBits := a.network.Mask.Size()
+		bOnes, bBits := b.network.Mask.Size()
+		return aBits == bBits && aOnes <= bOnes && a.network.Contains(b.network.IP)
+	case setCondition:
+		return a.value == b.value
+	case sizeCondition:
+		return a.min <= b.min && b.max <= a.max
+	case asnCondition:
+		return a.min == b.min
+	}
+
+	if a.operator == b.operator && a.value == b.value && a.caseSensitive == b.caseSensitive {
+		return true
+	}
+
+	// A case-sensitive condition cannot cover a case-insensitive one
+	if a.caseSensitive && !b.caseSensitive {
+		return false
+	}
+
+	// b matches a single exact value: a covers b if a matches that value
+	if b.operator == types.MatchEquals {
+		return matchesValue(a, b.value)
+	}
+
+	// b matches a set of strings described by a pattern; compare the patterns
+	value := b.value
+	if !a.caseSensitive {
+		value = strings.ToLower(value)
+	}
+	switch a.operator {
+	case types.MatchStartsWith:
+		return b.operator == types.MatchStartsWith && strings.HasPrefix(value, a.value)
+	case types.MatchEndsWith:
+		return b.operator == types.MatchEndsWith && strings.HasSuffix(value, a.value)
+	case types.MatchContains:
+		switch b.operator {
+		case types.MatchStartsWith, types.MatchEndsWith, types.MatchContains:
+			return strings.Contains(value, a.value)
+		}
+	}
+	return false
+}
+
+// matchesValue reports whether condition a matches the exact value
+func matchesValue(a condition, value string) bool {
+	if !a.caseSensitive {
+		value = strings.ToLower(value)
+	}
+
+	switch a.operator {
+	case types.MatchEquals:
+		return a.value == value
+	case types.MatchStartsWith:
+		return strings.HasPrefix(value, a.value)
+	case types.MatchEndsWith:
+		return strings.HasSuffix(value, a.value)
+	case types.MatchContains:
+		return strings.Contains(value, a.value)
+	case types.MatchWildcard:
+		glob, err := compileGlob(a.value, a.ruleType == types.RuleTypeDomain, true)
+		return err == nil && glob.MatchString(value)
+	case types.MatchRegex:
+		pattern := a.value
+		if !a.caseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		return err == nil && regex.MatchString(value)
+	}
+	return false
+}
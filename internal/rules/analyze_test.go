@@ -0,0 +1,160 @@
+package rules
+
+import (
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+func TestAnalyze(t *testing.T) {
+	size := func(n int64) *int64 { return &n }
+	caseSensitive := true
+	rule := func(id string, ruleType types.RuleType, operator types.MatchOperator, value string, action types.Action, priority int) types.Rule {
+		return types.Rule{ID: id, Type: ruleType, Operator: operator, Value: value, Action: action, Priority: priority, Enabled: true}
+	}
+
+	tests := []struct {
+		name     string
+		rules    []types.Rule
+		expected []Finding // only Kind, RuleID and OtherRuleID are compared
+	}{
+		{
+			name: "no findings",
+			rules: []types.Rule{
+				rule("block-admin", types.RuleTypeURL, types.MatchStartsWith, "/admin", types.ActionBlock, 100),
+				rule("allow-api", types.RuleTypeURL, types.MatchStartsWith, "/api", types.ActionAllow, 200),
+				rule("allow-admin-health", types.RuleTypeURL, types.MatchEquals, "/admin/health", types.ActionAllow, 50),
+			},
+		},
+		{
+			name: "prefix shadows longer prefix and exact value",
+			rules: []types.Rule{
+				rule("block-admin", types.RuleTypeURL, types.MatchStartsWith, "/admin", types.ActionBlock, 100),
+				rule("block-admin-users", types.RuleTypeURL, types.MatchStartsWith, "/ADMIN/users", types.ActionBlock, 200),
+				rule("allow-admin-health", types.RuleTypeURL, types.MatchEquals, "/admin/health", types.ActionAllow, 300),
+			},
+			expected: []Finding{
+				{Kind: FindingShadowed, RuleID: "block-admin-users", OtherRuleID: "block-admin"},
+				{Kind: FindingShadowed, RuleID: "allow-admin-health", OtherRuleID: "block-admin"},
+			},
+		},
+		{
+			name: "contains, regex and wildcard cover other patterns",
+			rules: []types.Rule{
+				rule("bots", types.RuleTypeUserAgent, types.MatchContains, "bot", types.ActionBlock, 10),
+				rule("googlebot", types.RuleTypeUserAgent, types.MatchStartsWith, "Googlebot", types.ActionAllow, 20),
+				rule("php", types.RuleTypeURL, types.MatchRegex, `\.php$`, types.ActionBlock, 30),
+				rule("index-php", types.RuleTypeURL, types.MatchEquals, "/index.php", types.ActionBlock, 40),
+				rule("example", types.RuleTypeDomain, types.MatchWildcard, "*.example.com", types.ActionBlock, 50),
+				rule("www-example", types.RuleTypeDomain, types.MatchEquals, "www.example.com", types.ActionBlock, 60),
+			},
+			expected: []Finding{
+				{Kind: FindingShadowed, RuleID: "googlebot", OtherRuleID: "bots"},
+				{Kind: FindingShadowed, RuleID: "index-php", OtherRuleID: "php"},
+				{Kind: FindingShadowed, RuleID: "www-example", OtherRuleID: "example"},
+			},
+		},
+		{
+			name: "CIDR and size containment",
+			rules: []types.Rule{
+				rule("private", types.RuleTypeIPv4, types.MatchInRange, "10.0.0.0/8", types.ActionBlock, 10),
+				rule("office", types.RuleTypeIPv4, types.MatchInRange, "10.20.0.0/16", types.ActionAllow, 20),
+				rule("host", types.RuleTypeIPv4, types.MatchEquals, "10.20.0.5", types.ActionAllow, 30),
+				{ID: "large", Type: types.RuleTypeSize, Operator: types.MatchGTE, MinSize: size(1000), Action: types.ActionBlock, Priority: 40, Enabled: true},
+				{ID: "huge", Type: types.RuleTypeSize, Operator: types.MatchInRange, MinSize: size(5000), MaxSize: size(9000), Action: types.ActionBlock, Priority: 50, Enabled: true},
+				{ID: "small", Type: types.RuleTypeSize, Operator: types.MatchLTE, MaxSize: size(100), Action: types.ActionBlock, Priority: 60, Enabled: true},
+			},
+			expected: []Finding{
+				{Kind: FindingShadowed, RuleID: "office", OtherRuleID: "private"},
+				{Kind: FindingShadowed, RuleID: "host", OtherRuleID: "private"},
+				{Kind: FindingShadowed, RuleID: "huge", OtherRuleID: "large"},
+			},
+		},
+		{
+			name: "conflicts at the same priority",
+			rules: []types.Rule{
+				rule("allow-private", types.RuleTypeIPv4, types.MatchInRange, "10.0.0.0/8", types.ActionAllow, 50),
+				rule("block-subnet", types.RuleTypeIPv4, types.MatchInRange, "10.1.0.0/16", types.ActionBlock, 50),
+				rule("allow-post", types.RuleTypeMethod, types.MatchEquals, "POST", types.ActionAllow, 60),
+				rule("block-post", types.RuleTypeMethod, types.MatchEquals, "POST", types.ActionBlock, 60),
+				rule("allow-get", types.RuleTypeMethod, types.MatchEquals, "GET", types.ActionAllow, 60),
+			},
+			expected: []Finding{
+				{Kind: FindingConflict, RuleID: "block-subnet", OtherRuleID: "allow-private"},
+				{Kind: FindingConflict, RuleID: "block-post", OtherRuleID: "allow-post"},
+			},
+		},
+		{
+			name: "duplicate conditions",
+			rules: []types.Rule{
+				rule("block-admin", types.RuleTypeURL, types.MatchStartsWith, "/admin", types.ActionBlock, 10),
+				rule("block-admin-again", types.RuleTypeURL, types.MatchStartsWith, "/Admin", types.ActionBlock, 10),
+				rule("block-host", types.RuleTypeIPv4, types.MatchEquals, "192.0.2.1", types.ActionBlock, 20),
+				rule("block-host-cidr", types.RuleTypeIPv4, types.MatchInRange, "192.0.2.1/32", types.ActionBlock, 30),
+			},
+			expected: []Finding{
+				{Kind: FindingDuplicate, RuleID: "block-admin-again", OtherRuleID: "block-admin"},
+				{Kind: FindingDuplicate, RuleID: "block-host-cidr", OtherRuleID: "block-host"},
+			},
+		},
+		{
+			name: "rules that can never match",
+			rules: []types.Rule{
+				rule("v4-in-v6", types.RuleTypeIPv6, types.MatchInRange, "10.0.0.0/8", types.ActionBlock, 10),
+				rule("uppercase-v6", types.RuleTypeIPv6, types.MatchEquals, "2001:DB8::1", types.ActionBlock, 20),
+				rule("bad-regex", types.RuleTypeURL, types.MatchRegex, "(", types.ActionBlock, 30),
+				rule("long-country", types.RuleTypeCountry, types.MatchEquals, "USA", types.ActionBlock, 40),
+				{ID: "no-min", Type: types.RuleTypeSize, Operator: types.MatchGTE, Action: types.ActionBlock, Priority: 50, Enabled: true},
+				rule("bad-operator", types.RuleTypeIPv4, types.MatchContains, "10.", types.ActionBlock, 60),
+			},
+			expected: []Finding{
+				{Kind: FindingNeverMatches, RuleID: "v4-in-v6"},
+				{Kind: FindingNeverMatches, RuleID: "uppercase-v6"},
+				{Kind: FindingNeverMatches, RuleID: "bad-regex"},
+				{Kind: FindingNeverMatches, RuleID: "long-country"},
+				{Kind: FindingNeverMatches, RuleID: "no-min"},
+				{Kind: FindingNeverMatches, RuleID: "bad-operator"},
+			},
+		},
+		{
+			name: "scheduled, shadow-mode and disabled rules do not shadow",
+			rules: []types.Rule{
+				{ID: "scheduled", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionBlock, Priority: 10, Enabled: true, Schedule: &types.Schedule{Days: []string{"sat"}}},
+				{ID: "dry-run", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin/", Action: types.ActionBlock, Priority: 20, Enabled: true, Mode: types.RuleModeShadow},
+				{ID: "disabled", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/", Action: types.ActionBlock, Priority: 30},
+				rule("admin-users", types.RuleTypeURL, types.MatchStartsWith, "/admin/users", types.ActionAllow, 40),
+			},
+		},
+		{
+			name: "different headers and case-sensitive rules do not cover case-insensitive ones",
+			rules: []types.Rule{
+				{ID: "x-debug", Type: types.RuleTypeHeader, Operator: types.MatchContains, HeaderName: "X-Debug", HeaderValue: "1", Action: types.ActionBlock, Priority: 10, Enabled: true},
+				{ID: "x-trace", Type: types.RuleTypeHeader, Operator: types.MatchEquals, HeaderName: "X-Trace", HeaderValue: "1", Action: types.ActionBlock, Priority: 20, Enabled: true},
+				{ID: "php-suffix", Type: types.RuleTypeURISuffix, Operator: types.MatchEquals, Value: ".php", CaseSensitive: &caseSensitive, Action: types.ActionBlock, Priority: 30, Enabled: true},
+				rule("PHP-suffix", types.RuleTypeURISuffix, types.MatchEquals, ".PHP", types.ActionBlock, 40),
+				rule("index-suffix", types.RuleTypeURISuffix, types.MatchEquals, "/index.php", types.ActionBlock, 50),
+			},
+			expected: []Finding{
+				{Kind: FindingShadowed, RuleID: "index-suffix", OtherRuleID: "PHP-suffix"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := Analyze(tt.rules)
+			if len(findings) != len(tt.expected) {
+				t.Fatalf("Expected %d findings, got %d: %+v", len(tt.expected), len(findings), findings)
+			}
+			for i, expected := range tt.expected {
+				got := findings[i]
+				if got.Kind != expected.Kind || got.RuleID != expected.RuleID || got.OtherRuleID != expected.OtherRuleID {
+					t.Errorf("Expected finding %+v, got %+v", expected, got)
+				}
+				if got.Message == "" {
+					t.Errorf("Expected a message for finding %+v", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"http-proxy/pkg/types"
+)
+
+// APIPrefix is the path the rules API is served under
+const APIPrefix = "/proxy/rules"
+
+// APIHandler serves the rules management API:
+//
+//	GET    /proxy/rules                 list all rules
+//	POST   /proxy/rules                 add a rule
+//	GET    /proxy/rules/stats           match statistics of all rules
+//	POST   /proxy/rules/explain         trace the evaluation of a request
+//	GET    /proxy/rules/files           status of the rules directory files
+//	GET    /proxy/rules/versions        ruleset version history
+//	GET    /proxy/rules/versions/diff?from=&to=
+//	                                    rule changes between two versions
+//	POST   /proxy/rules/versions/{n}/rollback
+//	                                    restore the rules of a version
+//	GET    /proxy/rules/{id}            get a rule
+//	PUT    /proxy/rules/{id}            replace a rule
+//	DELETE /proxy/rules/{id}            delete a rule
+//	PATCH  /proxy/rules/{id}?action=    enable or disable a rule
+//	GET    /proxy/rules/{id}/stats      match statistics of a rule
+type APIHandler struct {
+	manager *Manager
+}
+
+// NewAPIHandler creates a rules API handler backed by manager
+func NewAPIHandler(manager *Manager) *APIHandler {
+	return &APIHandler{manager: manager}
+}
+
+// ServeHTTP routes a rules API request
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	if path == "" {
+		h.handleRules(w, r)
+		return
+	}
+	if path == "stats" {
+		h.handleAllStats(w, r)
+		return
+	}
+	if path == "explain" {
+		h.handleExplain(w, r)
+		return
+	}
+	if path == "files" {
+		h.handleFiles(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case parts[0] == "versions":
+		h.handleVersions(w, r, parts[1:])
+	case len(parts) == 1:
+		h.handleRule(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "stats":
+		h.handleRuleStats(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleRules lists or adds rules
+func (h *APIHandler) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.manager.GetRules())
+	case http.MethodPost:
+		rule, err := decodeRule(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, exists := h.manager.GetRuleByID(rule.ID); exists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("rule %s already exists", rule.ID))
+			return
+		}
+		if err := h.manager.AddRule(rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, rule)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleRule gets, replaces, deletes, enables or disables a single rule
+func (h *APIHandler) handleRule(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		rule, exists := h.manager.GetRuleByID(id)
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("rule %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		rule, err := decodeRule(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if rule.ID != id {
+			writeError(w, http.StatusBadRequest, "rule ID does not match path")
+			return
+		}
+		if err := h.manager.UpdateRule(rule); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrRuleNotFound) {
+				status = http.StatusNotFound
+			}
+			writeError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	case http.MethodDelete:
+		if !h.manager.RemoveRule(id) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("rule %s not found", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		var found bool
+		switch action := r.URL.Query().Get("action"); action {
+		case "enable":
+			found = h.manager.EnableRule(id)
+		case "disable":
+			found = h.manager.DisableRule(id)
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid action: %s", action))
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("rule %s not found", id))
+			return
+		}
+		rule, _ := h.manager.GetRuleByID(id)
+		writeJSON(w, http.StatusOK, rule)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAllStats returns the match statistics of every rule
+func (h *APIHandler) handleAllStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.manager.GetRuleStats())
+}
+
+// handleRuleStats returns the match statistics of one rule
+func (h *APIHandler) handleRuleStats(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	stats, exists := h.manager.GetRuleStatsByID(id)
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("rule %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleFiles returns the status of the rules directory files
+func (h *APIHandler) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.manager.RuleFiles())
+}
+
+// handleVersions lists, diffs or rolls back ruleset versions
+func (h *APIHandler) handleVersions(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 0:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, h.manager.RuleSetVersions())
+	case len(parts) == 1 && parts[0] == "diff":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		from, errFrom := strconv.Atoi(r.URL.Query().Get("from"))
+		to, errTo := strconv.Atoi(r.URL.Query().Get("to"))
+		if errFrom != nil || errTo != nil {
+			writeError(w, http.StatusBadRequest, "from and to must be version numbers")
+			return
+		}
+		diff, err := h.manager.DiffRuleSets(from, to)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, diff)
+	case len(parts) == 2 && parts[1] == "rollback":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid version: %s", parts[0]))
+			return
+		}
+		current, err := h.manager.Rollback(version)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrVersionNotFound) {
+				status = http.StatusNotFound
+			}
+			writeError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, current)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// explainRequest is a synthetic request to explain. The body is given as
+// plain text rather than base64.
+type explainRequest struct {
+	types.RequestInfo
+	Body string `json:"body,omitempty"`
+}
+
+// handleExplain evaluates a synthetic request and returns the rule trace
+func (h *APIHandler) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var explain explainRequest
+	if err := json.NewDecoder(r.Body).Decode(&explain); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	req := explain.RequestInfo
+	req.Body = []byte(explain.Body)
+	normalizeRequestInfo(&req)
+
+	writeJSON(w, http.StatusOK, h.manager.Explain(&req))
+}
+
+// normalizeRequestInfo fills fields of a synthetic request that the proxy
+// derives from the HTTP request
+func normalizeRequestInfo(req *types.RequestInfo) {
+	req.Method = strings.ToUpper(req.Method)
+
+	headers := make(map[string][]string, len(req.Headers))
+	for name, values := range req.Headers {
+		headers[strings.ToLower(name)] = values
+	}
+	req.Headers = headers
+
+	if parsed, err := url.Parse(req.URL); err == nil {
+		if req.Path == "" {
+			req.Path = parsed.Path
+		}
+		if req.Domain == "" {
+			req.Domain = parsed.Hostname()
+		}
+	}
+	if req.UserAgent == "" && len(headers["user-agent"]) > 0 {
+		req.UserAgent = headers["user-agent"][0]
+	}
+	if req.Size == 0 {
+		req.Size = int64(len(req.Body))
+	}
+}
+
+// decodeRule reads a rule from the request body
+func decodeRule(r *http.Request) (types.Rule, error) {
+	var rule types.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		return rule, fmt.Errorf("invalid rule: %w", err)
+	}
+	if rule.ID == "" {
+		return rule, fmt.Errorf("invalid rule: id is required")
+	}
+	return rule, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
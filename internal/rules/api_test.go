@@ -0,0 +1,187 @@
+package rules
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+func newTestAPI(t *testing.T) (*APIHandler, *Manager) {
+	manager, err := NewManager(&types.RulesConfig{
+		DefaultAction: types.ActionAllow,
+		Rules: []types.Rule{
+			{ID: "block-admin", Name: "Block admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionBlock, Priority: 100, Enabled: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	t.Cleanup(manager.Close)
+	return NewAPIHandler(manager), manager
+}
+
+func serveAPI(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAPIHandler_Rules(t *testing.T) {
+	handler, manager := newTestAPI(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+	}{
+		{"list rules", http.MethodGet, "/proxy/rules", "", http.StatusOK},
+		{"get rule", http.MethodGet, "/proxy/rules/block-admin", "", http.StatusOK},
+		{"get missing rule", http.MethodGet, "/proxy/rules/missing", "", http.StatusNotFound},
+		{"add rule", http.MethodPost, "/proxy/rules", `{"id":"new-rule","type":"url","operator":"equals","value":"/new","action":"block","priority":50,"enabled":true}`, http.StatusCreated},
+		{"add duplicate rule", http.MethodPost, "/proxy/rules", `{"id":"new-rule"}`, http.StatusConflict},
+		{"add malformed rule", http.MethodPost, "/proxy/rules", `{`, http.StatusBadRequest},
+		{"add invalid rule", http.MethodPost, "/proxy/rules", `{"id":"bad","type":"url","operator":"regex","value":"(","action":"block"}`, http.StatusBadRequest},
+		{"update rule", http.MethodPut, "/proxy/rules/new-rule", `{"id":"new-rule","type":"url","operator":"equals","value":"/newer","action":"block","priority":50,"enabled":true}`, http.StatusOK},
+		{"update with invalid rule", http.MethodPut, "/proxy/rules/new-rule", `{"id":"new-rule","type":"size","operator":"gte","action":"block"}`, http.StatusBadRequest},
+		{"update missing rule", http.MethodPut, "/proxy/rules/missing", `{"id":"missing","type":"url","operator":"equals","value":"/","action":"block"}`, http.StatusNotFound},
+		{"update with mismatched id", http.MethodPut, "/proxy/rules/new-rule", `{"id":"other"}`, http.StatusBadRequest},
+		{"disable rule", http.MethodPatch, "/proxy/rules/new-rule?action=disable", "", http.StatusOK},
+		{"invalid patch action", http.MethodPatch, "/proxy/rules/new-rule?action=toggle", "", http.StatusBadRequest},
+		{"delete rule", http.MethodDelete, "/proxy/rules/new-rule", "", http.StatusNoContent},
+		{"delete missing rule", http.MethodDelete, "/proxy/rules/new-rule", "", http.StatusNotFound},
+		{"unsupported method", http.MethodPost, "/proxy/rules/block-admin", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveAPI(handler, tt.method, tt.target, tt.body)
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	if len(manager.GetRules()) != 1 {
+		t.Errorf("Expected 1 rule after API calls, got %d", len(manager.GetRules()))
+	}
+}
+
+func TestAPIHandler_Stats(t *testing.T) {
+	handler, manager := newTestAPI(t)
+
+	manager.EvaluateRequest(&types.RequestInfo{URL: "/admin", ClientIP: net.ParseIP("192.0.2.7")})
+
+	recorder := serveAPI(handler, http.MethodGet, "/proxy/rules/stats", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+	var all []types.RuleStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &all); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if len(all) != 1 || all[0].Matches != 1 {
+		t.Errorf("Expected one rule with one match, got %+v", all)
+	}
+
+	recorder = serveAPI(handler, http.MethodGet, "/proxy/rules/block-admin/stats", "")
+	var stats types.RuleStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if stats.LastClientIP != "192.0.2.7" || stats.LastMatched == nil {
+		t.Errorf("Expected last match metadata, got %+v", stats)
+	}
+
+	if recorder := serveAPI(handler, http.MethodGet, "/proxy/rules/missing/stats", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for missing rule, got %d", recorder.Code)
+	}
+}
+
+func TestAPIHandler_Explain(t *testing.T) {
+	handler, manager := newTestAPI(t)
+	manager.AddRule(types.Rule{ID: "block-curl", Type: types.RuleTypeUserAgent, Operator: types.MatchContains, Value: "curl", Action: types.ActionBlock, Priority: 50, Enabled: true})
+
+	body := `{"method":"get","url":"/admin/users","headers":{"User-Agent":["Mozilla/5.0"]},"client_ip":"192.0.2.1"}`
+	recorder := serveAPI(handler, http.MethodPost, "/proxy/rules/explain", body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var explanation types.Explanation
+	if err := json.Unmarshal(recorder.Body.Bytes(), &explanation); err != nil {
+		t.Fatalf("Failed to decode explanation: %v", err)
+	}
+	if explanation.RuleID != "block-admin" || explanation.Action != types.ActionBlock {
+		t.Errorf("Expected block-admin to decide, got %+v", explanation)
+	}
+	if len(explanation.Trace) != 2 || explanation.Trace[0].RuleID != "block-curl" || explanation.Trace[0].Status != types.TraceNoMatch {
+		t.Errorf("Expected block-curl to be traced as no match, got %+v", explanation.Trace)
+	}
+
+	if stats, _ := manager.GetRuleStatsByID("block-admin"); stats.Matches != 0 {
+		t.Errorf("Expected explain not to count matches, got %+v", stats)
+	}
+
+	if recorder := serveAPI(handler, http.MethodPost, "/proxy/rules/explain", "not json"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid request, got %d", recorder.Code)
+	}
+	if recorder := serveAPI(handler, http.MethodGet, "/proxy/rules/explain", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", recorder.Code)
+	}
+}
+
+func TestAPIHandler_Versions(t *testing.T) {
+	handler, manager := newTestAPI(t)
+
+	serveAPI(handler, http.MethodPost, "/proxy/rules", `{"id":"block-api","type":"url","operator":"starts_with","value":"/api","action":"block","priority":50,"enabled":true}`)
+
+	recorder := serveAPI(handler, http.MethodGet, "/proxy/rules/versions", "")
+	var versions []types.RuleSetVersion
+	if err := json.Unmarshal(recorder.Body.Bytes(), &versions); err != nil || len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %s", recorder.Body.String())
+	}
+
+	recorder = serveAPI(handler, http.MethodGet, "/proxy/rules/versions/diff?from=1&to=2", "")
+	var diff types.RuleSetDiff
+	if err := json.Unmarshal(recorder.Body.Bytes(), &diff); err != nil || len(diff.Added) != 1 || diff.Added[0].ID != "block-api" {
+		t.Errorf("Expected block-api in diff, got %s", recorder.Body.String())
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		expected int
+	}{
+		{"diff with missing version", http.MethodGet, "/proxy/rules/versions/diff?from=1&to=9", http.StatusNotFound},
+		{"diff without versions", http.MethodGet, "/proxy/rules/versions/diff", http.StatusBadRequest},
+		{"rollback missing version", http.MethodPost, "/proxy/rules/versions/9/rollback", http.StatusNotFound},
+		{"rollback invalid version", http.MethodPost, "/proxy/rules/versions/latest/rollback", http.StatusBadRequest},
+		{"rollback with GET", http.MethodGet, "/proxy/rules/versions/1/rollback", http.StatusMethodNotAllowed},
+		{"rollback", http.MethodPost, "/proxy/rules/versions/1/rollback", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveAPI(handler, tt.method, tt.target, "")
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	if _, exists := manager.GetRuleByID("block-api"); exists {
+		t.Error("Expected rollback to remove block-api")
+	}
+	if current := manager.RuleSetVersion(); current.Version != 3 || current.Source != types.RuleSetSourceRollback {
+		t.Errorf("Expected version 3 from rollback, got %+v", current)
+	}
+}
@@ -0,0 +1,174 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ReadRequestBody buffers up to limit bytes of the request body for inspection
+// and restores r.Body so the full body can still be forwarded upstream.
+// truncated reports whether the body was larger than limit.
+func ReadRequestBody(r *http.Request, limit int64) (body []byte, truncated bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody || limit <= 0 {
+		return nil, false, nil
+	}
+
+	// Read one extra byte to detect bodies exceeding the limit
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	r.Body = &restoredBody{
+		Reader: io.MultiReader(bytes.NewReader(buf), r.Body),
+		closer: r.Body,
+	}
+
+	if int64(len(buf)) > limit {
+		return buf[:limit], true, nil
+	}
+	return buf, false, nil
+}
+
+// restoredBody replays the buffered prefix before the unread remainder
+type restoredBody struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (b *restoredBody) Close() error {
+	return b.closer.Close()
+}
+
+// parsedBody lazily parses the request body once per evaluation
+type parsedBody struct {
+	raw         []byte
+	contentType string
+
+	jsonParsed bool
+	jsonDoc    interface{}
+	jsonErr    error
+
+	formParsed bool
+	form       url.Values
+	formErr    error
+}
+
+func newParsedBody(raw []byte, headers map[string][]string) *parsedBody {
+	pb := &parsedBody{raw: raw}
+	if values := headers["content-type"]; len(values) > 0 {
+		pb.contentType = values[0]
+	}
+	return pb
+}
+
+// json returns the decoded JSON document
+func (pb *parsedBody) json() (interface{}, error) {
+	if !pb.jsonParsed {
+		pb.jsonParsed = true
+		decoder := json.NewDecoder(bytes.NewReader(pb.raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&pb.jsonDoc); err != nil {
+			pb.jsonErr = fmt.Errorf("invalid JSON body: %w", err)
+		}
+	}
+	return pb.jsonDoc, pb.jsonErr
+}
+
+// formValues returns the decoded urlencoded or multipart form fields
+func (pb *parsedBody) formValues() (url.Values, error) {
+	if !pb.formParsed {
+		pb.formParsed = true
+		pb.form, pb.formErr = parseForm(pb.raw, pb.contentType)
+	}
+	return pb.form, pb.formErr
+}
+
+// parseForm decodes form fields; file parts of multipart bodies are ignored
+func parseForm(raw []byte, contentType string) (url.Values, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		values, err := url.ParseQuery(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form body: %w", err)
+		}
+		return values, nil
+	}
+
+	values := url.Values{}
+	reader := multipart.NewReader(bytes.NewReader(raw), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if part.FormName() == "" || part.FileName() != "" {
+			continue
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		values.Add(part.FormName(), string(data))
+	}
+}
+
+// lookupJSONPath resolves a dot-separated path such as "user.roles.0" or
+// "items[1].id" and returns the value rendered as a string
+func lookupJSONPath(doc interface{}, path string) (string, bool) {
+	current := doc
+	for _, segment := range splitJSONPath(path) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return "", false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", false
+			}
+			current = node[index]
+		default:
+			return "", false
+		}
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, true
+	case nil:
+		return "null", true
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
+
+// splitJSONPath splits a JSON path into keys, treating "[n]" as ".n"
+func splitJSONPath(path string) []string {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
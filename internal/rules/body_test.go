@@ -0,0 +1,136 @@
+package rules
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadRequestBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		limit           int64
+		expectBody      string
+		expectTruncated bool
+	}{
+		{
+			name:       "Body within limit",
+			body:       `{"user":"alice"}`,
+			limit:      1024,
+			expectBody: `{"user":"alice"}`,
+		},
+		{
+			name:            "Body exceeds limit",
+			body:            "abcdefghij",
+			limit:           4,
+			expectBody:      "abcd",
+			expectTruncated: true,
+		},
+		{
+			name:       "Body exactly at limit",
+			body:       "abcd",
+			limit:      4,
+			expectBody: "abcd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, truncated, err := ReadRequestBody(req, tt.limit)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if string(body) != tt.expectBody {
+				t.Errorf("Expected body %q, got %q", tt.expectBody, string(body))
+			}
+			if truncated != tt.expectTruncated {
+				t.Errorf("Expected truncated %v, got %v", tt.expectTruncated, truncated)
+			}
+
+			// The full body must still be available for forwarding
+			forwarded, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(forwarded) != tt.body {
+				t.Errorf("Expected forwarded body %q, got %q", tt.body, string(forwarded))
+			}
+		})
+	}
+}
+
+func TestReadRequestBody_NoBody(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, truncated, err := ReadRequestBody(req, 1024)
+	if err != nil || body != nil || truncated {
+		t.Errorf("Expected empty result for request without body, got %q, %v, %v", body, truncated, err)
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	pb := newParsedBody([]byte(`{"user":{"name":"alice","roles":["admin","dev"],"age":42,"active":true},"items":[{"id":7}]}`), nil)
+	doc, err := pb.json()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		expectValue string
+		expectFound bool
+	}{
+		{"user.name", "alice", true},
+		{"user.roles.1", "dev", true},
+		{"user.age", "42", true},
+		{"user.active", "true", true},
+		{"items[0].id", "7", true},
+		{"user.roles", `["admin","dev"]`, true},
+		{"user.missing", "", false},
+		{"user.roles.5", "", false},
+		{"user.name.first", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			value, found := lookupJSONPath(doc, tt.path)
+			if found != tt.expectFound {
+				t.Errorf("Expected found %v, got %v", tt.expectFound, found)
+			}
+			if value != tt.expectValue {
+				t.Errorf("Expected value %q, got %q", tt.expectValue, value)
+			}
+		})
+	}
+}
+
+func TestParseForm_Multipart(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("--XYZ\r\nContent-Disposition: form-data; name=\"username\"\r\n\r\nalice\r\n")
+	buf.WriteString("--XYZ\r\nContent-Disposition: form-data; name=\"upload\"; filename=\"a.txt\"\r\n\r\nfile contents\r\n")
+	buf.WriteString("--XYZ--\r\n")
+
+	values, err := parseForm(buf.Bytes(), "multipart/form-data; boundary=XYZ")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if values.Get("username") != "alice" {
+		t.Errorf("Expected username 'alice', got %q", values.Get("username"))
+	}
+	if _, exists := values["upload"]; exists {
+		t.Errorf("File parts should not be treated as form fields")
+	}
+}
@@ -1,24 +1,114 @@
 package rules
 
 import (
+	"errors"
 	"fmt"
 	"net"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"http-proxy/pkg/matcher"
 	"http-proxy/pkg/types"
 )
 
+// ErrRuleNotFound is returned when a rule ID does not exist
+var ErrRuleNotFound = errors.New("rule not found")
+
 // Engine represents the rules engine for request filtering
 type Engine struct {
 	mu            sync.RWMutex
 	rules         []types.Rule
 	compiledRegex map[string]*regexp.Regexp
+	compiledCIDR  map[string]*net.IPNet
+	compiledExpr  map[string]*expression
+	custom        map[string]matcher.Matcher // matchers of registered rule types
+	schedules     map[string]*schedule
+	index         *ruleIndex
+	counters      map[string]*ruleCounters
+	shadowAll     bool
+	legacyCase    bool
 	defaultAction types.Action
+	bodyConfig    types.BodyInspectionConfig
+	geoResolver   GeoResolver
+	ipSets        IPSetLookup
+	now           func() time.Time
+}
+
+// IPSetLookup resolves membership of IPs in named IP sets
+type IPSetLookup interface {
+	// Contains reports whether ip is in the named set; exists is false when
+	// no set has that name
+	Contains(name string, ip net.IP) (matched bool, exists bool)
+}
+
+// ruleCounters holds per-rule counters updated without the write lock
+type ruleCounters struct {
+	matches       atomic.Int64
+	shadowMatches atomic.Int64
+	lastMatched   atomic.Int64 // unix nanoseconds
+	lastClientIP  atomic.Value // string
+}
+
+// record counts a match of the rule by the client at now
+func (c *ruleCounters) record(shadow bool, now time.Time, clientIP net.IP) {
+	if shadow {
+		c.shadowMatches.Add(1)
+	} else {
+		c.matches.Add(1)
+	}
+	c.lastMatched.Store(now.UnixNano())
+	if clientIP != nil {
+		c.lastClientIP.Store(clientIP.String())
+	}
+}
+
+// stats returns a snapshot of the counters
+func (c *ruleCounters) stats(ruleID string) types.RuleStats {
+	stats := types.RuleStats{
+		RuleID:        ruleID,
+		Matches:       c.matches.Load(),
+		ShadowMatches: c.shadowMatches.Load(),
+	}
+	if nanos := c.lastMatched.Load(); nanos != 0 {
+		lastMatched := time.Unix(0, nanos).UTC()
+		stats.LastMatched = &lastMatched
+	}
+	if clientIP, ok := c.lastClientIP.Load().(string); ok {
+		stats.LastClientIP = clientIP
+	}
+	return stats
+}
+
+// GeoResolver resolves client IPs to their country and autonomous system
+type GeoResolver interface {
+	Lookup(ip net.IP) (*types.GeoInfo, bool)
+}
+
+// evalContext carries per-request state shared by all rules of one evaluation
+type evalContext struct {
+	req  *types.RequestInfo
+	now  time.Time
+	body *parsedBody
+
+	geo         *types.GeoInfo
+	geoResolved bool
+}
+
+func newEvalContext(req *types.RequestInfo, now time.Time) *evalContext {
+	return &evalContext{req: req, now: now}
+}
+
+// parsedBody returns the lazily parsed request body
+func (c *evalContext) parsedBody() *parsedBody {
+	if c.body == nil {
+		c.body = newParsedBody(c.req.Body, c.req.Headers)
+	}
+	return c.body
 }
 
 // NewEngine creates a new rules engine
@@ -26,37 +116,97 @@ func NewEngine(rules []types.Rule, defaultAction types.Action) *Engine {
 	engine := &Engine{
 		rules:         make([]types.Rule, len(rules)),
 		compiledRegex: make(map[string]*regexp.Regexp),
+		compiledCIDR:  make(map[string]*net.IPNet),
+		compiledExpr:  make(map[string]*expression),
+		custom:        make(map[string]matcher.Matcher),
+		schedules:     make(map[string]*schedule),
+		counters:      make(map[string]*ruleCounters),
 		defaultAction: defaultAction,
+		now:           time.Now,
 	}
 
 	// Copy rules and sort by priority (lower number = higher priority)
 	copy(engine.rules, rules)
+	resolveTTLs(engine.rules, engine.now())
 	sort.Slice(engine.rules, func(i, j int) bool {
 		return engine.rules[i].Priority < engine.rules[j].Priority
 	})
 
-	// Pre-compile regex patterns
-	engine.compileRegexPatterns()
+	// Pre-compile regex patterns and CIDR ranges
+	engine.compilePatterns()
+	engine.rebuild()
 
 	return engine
 }
 
-// UpdateRules updates the rules in the engine
-func (e *Engine) UpdateRules(rules []types.Rule) {
+// UpdateRules replaces the rules in the engine. The rules are validated
+// first, and an invalid list is rejected as a whole.
+func (e *Engine) UpdateRules(rules []types.Rule) error {
+	custom, err := validateRules(rules)
+	if err != nil {
+		return err
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	e.rules = make([]types.Rule, len(rules))
 	copy(e.rules, rules)
+	resolveTTLs(e.rules, e.now())
 
 	// Sort by priority
 	sort.Slice(e.rules, func(i, j int) bool {
 		return e.rules[i].Priority < e.rules[j].Priority
 	})
 
-	// Clear and recompile regex patterns
+	// Clear and recompile patterns
 	e.compiledRegex = make(map[string]*regexp.Regexp)
-	e.compileRegexPatterns()
+	e.compiledCIDR = make(map[string]*net.IPNet)
+	e.compiledExpr = make(map[string]*expression)
+	e.custom = custom // compiled while validating
+	e.schedules = make(map[string]*schedule)
+	e.compilePatterns()
+	e.rebuild()
+	return nil
+}
+
+// SetBodyInspection sets how body inspection rules treat oversized or unparsable bodies
+func (e *Engine) SetBodyInspection(config types.BodyInspectionConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bodyConfig = config
+}
+
+// SetClock sets the clock used to evaluate rule schedules
+func (e *Engine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+// SetIPSets sets the lookup used by rules with the in_set operator
+func (e *Engine) SetIPSets(ipSets IPSetLookup) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ipSets = ipSets
+}
+
+// SetGeoResolver sets the resolver used by geo_country and asn rules
+func (e *Engine) SetGeoResolver(resolver GeoResolver) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.geoResolver = resolver
+}
+
+// resolveGeo resolves the client location once per evaluation
+func (e *Engine) resolveGeo(ctx *evalContext) *types.GeoInfo {
+	if !ctx.geoResolved {
+		ctx.geoResolved = true
+		if e.geoResolver != nil && ctx.req.ClientIP != nil {
+			ctx.geo, _ = e.geoResolver.Lookup(ctx.req.ClientIP)
+		}
+	}
+	return ctx.geo
 }
 
 // EvaluateRequest evaluates a request against all rules and returns the action to take
@@ -64,33 +214,216 @@ func (e *Engine) EvaluateRequest(req *types.RequestInfo) *types.RuleResult {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
+	ctx := newEvalContext(req, e.now())
+	geo := e.resolveGeo(ctx)
+
+	// Only rules the index selected can match; visit them in priority order
+	var result *types.RuleResult
+	var shadowRule *types.Rule
+	var shadowReason string
+	e.index.candidates(req).forEach(func(pos int) bool {
+		rule := e.rules[pos]
+
+		if active, _ := e.isActive(&rule, ctx.now); !active {
+			return true
+		}
+
+		matched, action, reason := e.evaluateRule(&rule, ctx)
+		if !matched {
+			return true
+		}
+
+		// Shadow matches are recorded and evaluation continues
+		shadow := e.isShadow(&rule)
+		e.counters[rule.ID].record(shadow, ctx.now, req.ClientIP)
+		if shadow {
+			if shadowRule == nil {
+				shadowRule, shadowReason = &rule, reason
+			}
+			return true
+		}
+
+		result = &types.RuleResult{
+			Rule:    &rule,
+			Matched: true,
+			Action:  action,
+			Reason:  reason,
+		}
+		return false
+	})
+
+	if result == nil {
+		// No rules matched, use default action
+		result = &types.RuleResult{
+			Rule:    nil,
+			Matched: false,
+			Action:  e.defaultAction,
+			Reason:  "no rules matched, using default action",
+		}
+	}
+	result.Geo = geo
+	result.ShadowRule = shadowRule
+	result.ShadowReason = shadowReason
+
+	return result
+}
+
+// Explain evaluates a request like EvaluateRequest and reports the status of
+// every rule in priority order. Rules after the deciding rule are skipped.
+// Explaining a request does not update rule statistics.
+func (e *Engine) Explain(req *types.RequestInfo) *types.Explanation {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ctx := newEvalContext(req, e.now())
+	explanation := &types.Explanation{
+		Action: e.defaultAction,
+		Reason: "no rules matched, using default action",
+		Geo:    e.resolveGeo(ctx),
+		Trace:  make([]types.RuleTrace, 0, len(e.rules)),
+	}
+
 	for _, rule := range e.rules {
-		if !rule.Enabled {
-			continue
+		trace := types.RuleTrace{
+			RuleID:   rule.ID,
+			Name:     rule.Name,
+			Priority: rule.Priority,
+			Action:   rule.Action,
 		}
 
-		matched, reason := e.matchRule(&rule, req)
-		if matched {
-			return &types.RuleResult{
-				Rule:    &rule,
-				Matched: true,
-				Action:  rule.Action,
-				Reason:  reason,
+		switch {
+		case !rule.Enabled:
+			trace.Status, trace.Reason = types.TraceDisabled, "rule is disabled"
+		case explanation.Matched:
+			trace.Status = types.TraceSkipped
+			trace.Reason = fmt.Sprintf("request already decided by rule %s", explanation.RuleID)
+		default:
+			if active, reason := e.isActive(&rule, ctx.now); !active {
+				trace.Status, trace.Reason = types.TraceInactive, reason
+				break
+			}
+
+			matched, action, reason := e.evaluateRule(&rule, ctx)
+			trace.Action, trace.Reason = action, reason
+			switch {
+			case !matched:
+				trace.Status = types.TraceNoMatch
+			case e.isShadow(&rule):
+				trace.Status = types.TraceShadowMatch
+				if explanation.ShadowRuleID == "" {
+					explanation.ShadowRuleID = rule.ID
+				}
+			default:
+				trace.Status = types.TraceMatched
+				explanation.Action = action
+				explanation.Matched = true
+				explanation.RuleID = rule.ID
+				explanation.Reason = reason
 			}
 		}
+
+		explanation.Trace = append(explanation.Trace, trace)
+	}
+
+	return explanation
+}
+
+// evaluateRule matches a rule and returns the action it decides on, which
+// differs from the rule action when a body rule cannot inspect the body
+func (e *Engine) evaluateRule(rule *types.Rule, ctx *evalContext) (bool, types.Action, string) {
+	if isBodyRule(rule.Type) {
+		if action, reason, failed := e.checkBody(rule, ctx); failed {
+			return action != "", action, reason
+		}
+	}
+
+	matched, reason := e.match(rule, ctx)
+	return matched, rule.Action, reason
+}
+
+// isShadow reports whether a rule's matches are only recorded
+func (e *Engine) isShadow(rule *types.Rule) bool {
+	return e.shadowAll || rule.Mode == types.RuleModeShadow
+}
+
+// SetShadowMode runs every rule in shadow mode when enabled, so requests are
+// decided by the default action
+func (e *Engine) SetShadowMode(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shadowAll = enabled
+}
+
+// SetDefaultAction sets the action for requests that match no rule
+func (e *Engine) SetDefaultAction(action types.Action) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaultAction = action
+}
+
+// GetRuleStats returns match statistics for every rule in priority order
+func (e *Engine) GetRuleStats() []types.RuleStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stats := make([]types.RuleStats, 0, len(e.rules))
+	for _, rule := range e.rules {
+		stats = append(stats, e.counters[rule.ID].stats(rule.ID))
 	}
+	return stats
+}
 
-	// No rules matched, use default action
-	return &types.RuleResult{
-		Rule:    nil,
-		Matched: false,
-		Action:  e.defaultAction,
-		Reason:  "no rules matched, using default action",
+// GetRuleStatsByID returns match statistics for a single rule
+func (e *Engine) GetRuleStatsByID(id string) (types.RuleStats, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	counters, exists := e.counters[id]
+	if !exists {
+		return types.RuleStats{}, false
 	}
+	return counters.stats(id), true
+}
+
+// rebuild recomputes the rule index and per-rule counters after the rules
+// changed. Counters of rules that are still present are kept.
+func (e *Engine) rebuild() {
+	e.index = buildIndex(e.rules)
+
+	counters := make(map[string]*ruleCounters, len(e.rules))
+	for _, rule := range e.rules {
+		if existing, ok := e.counters[rule.ID]; ok {
+			counters[rule.ID] = existing
+		} else {
+			counters[rule.ID] = &ruleCounters{}
+		}
+	}
+	e.counters = counters
 }
 
 // matchRule checks if a single rule matches the request
 func (e *Engine) matchRule(rule *types.Rule, req *types.RequestInfo) (bool, string) {
+	return e.match(rule, newEvalContext(req, e.now()))
+}
+
+// isActive reports whether a rule has not expired and its schedule allows it at now
+func (e *Engine) isActive(rule *types.Rule, now time.Time) (bool, string) {
+	if rule.ExpiresAt != nil && !now.Before(*rule.ExpiresAt) {
+		return false, fmt.Sprintf("rule expired at %s", rule.ExpiresAt.Format(time.RFC3339))
+	}
+	if rule.Schedule == nil {
+		return true, ""
+	}
+	compiled, ok := e.schedules[rule.ID]
+	if !ok {
+		return false, "rule has an invalid schedule"
+	}
+	return compiled.active(now)
+}
+
+// match checks if a single rule matches the request of an evaluation context
+func (e *Engine) match(rule *types.Rule, ctx *evalContext) (bool, string) {
+	req := ctx.req
 	switch rule.Type {
 	case types.RuleTypeIPv4:
 		return e.matchIPv4(rule, req)
@@ -110,7 +443,20 @@ func (e *Engine) matchRule(rule *types.Rule, req *types.RequestInfo) (bool, stri
 		return e.matchMethod(rule, req)
 	case types.RuleTypeHeader:
 		return e.matchHeader(rule, req)
+	case types.RuleTypeJSONField:
+		return e.matchJSONField(rule, ctx)
+	case types.RuleTypeFormField:
+		return e.matchFormField(rule, ctx)
+	case types.RuleTypeCountry:
+		return e.matchCountry(rule, ctx)
+	case types.RuleTypeASN:
+		return e.matchASN(rule, ctx)
+	case types.RuleTypeExpression:
+		return e.matchExpression(rule, ctx)
 	default:
+		if custom, ok := e.custom[rule.ID]; ok {
+			return custom.Match(req)
+		}
 		return false, fmt.Sprintf("unknown rule type: %s", rule.Type)
 	}
 }
@@ -121,7 +467,7 @@ func (e *Engine) matchIPv4(rule *types.Rule, req *types.RequestInfo) (bool, stri
 		return false, "request IP is not IPv4"
 	}
 
-	return e.matchIP(rule, req.ClientIP.String())
+	return e.matchIP(rule, req.ClientIP)
 }
 
 // matchIPv6 matches IPv6 addresses
@@ -130,11 +476,12 @@ func (e *Engine) matchIPv6(rule *types.Rule, req *types.RequestInfo) (bool, stri
 		return false, "request IP is not IPv6"
 	}
 
-	return e.matchIP(rule, req.ClientIP.String())
+	return e.matchIP(rule, req.ClientIP)
 }
 
-// matchIP matches IP addresses with CIDR support
-func (e *Engine) matchIP(rule *types.Rule, clientIP string) (bool, string) {
+// matchIP matches IP addresses with CIDR and IP set support
+func (e *Engine) matchIP(rule *types.Rule, ip net.IP) (bool, string) {
+	clientIP := ip.String()
 	switch rule.Operator {
 	case types.MatchEquals:
 		if clientIP == rule.Value {
@@ -142,14 +489,27 @@ func (e *Engine) matchIP(rule *types.Rule, clientIP string) (bool, string) {
 		}
 	case types.MatchInRange:
 		// Check if IP is in CIDR range
-		_, network, err := net.ParseCIDR(rule.Value)
-		if err != nil {
-			return false, fmt.Sprintf("invalid CIDR range %s: %v", rule.Value, err)
+		network, ok := e.compiledCIDR[rule.ID]
+		if !ok {
+			var err error
+			if _, network, err = net.ParseCIDR(rule.Value); err != nil {
+				return false, fmt.Sprintf("invalid CIDR range %s: %v", rule.Value, err)
+			}
 		}
-		ip := net.ParseIP(clientIP)
-		if ip != nil && network.Contains(ip) {
+		if network.Contains(ip) {
 			return true, fmt.Sprintf("IP %s is in range %s", clientIP, rule.Value)
 		}
+	case types.MatchInSet:
+		if e.ipSets == nil {
+			return false, fmt.Sprintf("IP set %s not found", rule.Value)
+		}
+		matched, exists := e.ipSets.Contains(rule.Value, ip)
+		if !exists {
+			return false, fmt.Sprintf("IP set %s not found", rule.Value)
+		}
+		if matched {
+			return true, fmt.Sprintf("IP %s is in set %s", clientIP, rule.Value)
+		}
 	}
 
 	return false, fmt.Sprintf("IP %s does not match rule value %s with operator %s", clientIP, rule.Value, rule.Operator)
@@ -172,14 +532,18 @@ func (e *Engine) matchUserAgent(rule *types.Rule, req *types.RequestInfo) (bool,
 
 // matchURISuffix matches URI suffixes
 func (e *Engine) matchURISuffix(rule *types.Rule, req *types.RequestInfo) (bool, string) {
+	path, value := req.Path, rule.Value
+	if !e.caseSensitive(rule) {
+		path, value = strings.ToLower(path), strings.ToLower(value)
+	}
+
 	switch rule.Operator {
 	case types.MatchEquals:
-		if strings.HasSuffix(req.Path, rule.Value) {
+		if strings.HasSuffix(path, value) {
 			return true, fmt.Sprintf("URI path %s ends with %s", req.Path, rule.Value)
 		}
 	case types.MatchWildcard:
-		matched, _ := filepath.Match(rule.Value, req.Path)
-		if matched {
+		if regex, ok := e.wildcardRegex(rule); ok && regex.MatchString(req.Path) {
 			return true, fmt.Sprintf("URI path %s matches wildcard %s", req.Path, rule.Value)
 		}
 	case types.MatchRegex:
@@ -230,68 +594,470 @@ func (e *Engine) matchHeader(rule *types.Rule, req *types.RequestInfo) (bool, st
 	}
 
 	headerValues, exists := req.Headers[headerName]
-	if !exists {
+	switch rule.Operator {
+	case types.MatchExists:
+		if exists {
+			return true, fmt.Sprintf("header %s present", rule.HeaderName)
+		}
 		return false, fmt.Sprintf("header %s not present", rule.HeaderName)
+	case types.MatchNotExists:
+		if exists {
+			return false, fmt.Sprintf("header %s present", rule.HeaderName)
+		}
+		return true, fmt.Sprintf("header %s not present", rule.HeaderName)
 	}
 
-	// Check against all header values
-	for _, headerValue := range headerValues {
-		matched, reason := e.matchStringValueDirect(rule.Operator, rule.HeaderValue, headerValue, fmt.Sprintf("header %s", rule.HeaderName), rule.ID)
-		if matched {
-			return true, reason
+	if !exists && !isNegated(rule.Operator) {
+		return false, fmt.Sprintf("header %s not present", rule.HeaderName)
+	}
+
+	return e.matchValues(rule, rule.HeaderValue, headerValues, fmt.Sprintf("header %s", rule.HeaderName))
+}
+
+// matchValues matches the values of a repeated field. Operators match when
+// any value matches; negated operators match when every value does, which
+// includes a field without values.
+func (e *Engine) matchValues(rule *types.Rule, ruleValue string, values []string, fieldName string) (bool, string) {
+	negated := isNegated(rule.Operator)
+
+	for _, value := range values {
+		matched, reason := e.matchStringValueDirect(rule, ruleValue, value, fieldName)
+		if matched != negated {
+			return matched, reason
+		}
+	}
+
+	if negated {
+		return true, fmt.Sprintf("no %s value matches %s", fieldName, positiveOperator(rule.Operator))
+	}
+	return false, fmt.Sprintf("%s values do not match rule", fieldName)
+}
+
+// matchCountry matches the ISO country code of the client IP
+func (e *Engine) matchCountry(rule *types.Rule, ctx *evalContext) (bool, string) {
+	geo := e.resolveGeo(ctx)
+	if geo == nil || geo.Country == "" {
+		return false, fmt.Sprintf("country of client IP %s is unknown", ctx.req.ClientIP)
+	}
+
+	ruleValue := rule.Value
+	if rule.Operator != types.MatchRegex {
+		ruleValue = strings.ToUpper(ruleValue)
+	}
+
+	return e.matchStringValueDirect(rule, ruleValue, geo.Country, "country")
+}
+
+// matchASN matches the autonomous system of the client IP. The equals operator
+// compares AS numbers ("13335" or "AS13335"); other operators match the AS organization.
+func (e *Engine) matchASN(rule *types.Rule, ctx *evalContext) (bool, string) {
+	geo := e.resolveGeo(ctx)
+	if geo == nil || geo.ASN == 0 {
+		return false, fmt.Sprintf("ASN of client IP %s is unknown", ctx.req.ClientIP)
+	}
+
+	switch positiveOperator(rule.Operator) {
+	case types.MatchEquals, types.MatchIn:
+		values := rule.Values
+		if positiveOperator(rule.Operator) == types.MatchEquals {
+			values = []string{rule.Value}
 		}
+
+		found := false
+		for _, value := range values {
+			asn, err := parseASN(value)
+			if err != nil {
+				return false, fmt.Sprintf("invalid ASN %s: %v", value, err)
+			}
+			found = found || geo.ASN == asn
+		}
+
+		if found != isNegated(rule.Operator) {
+			return true, fmt.Sprintf("ASN AS%d %s %s", geo.ASN, rule.Operator, strings.Join(values, ", "))
+		}
+		return false, fmt.Sprintf("ASN AS%d does not satisfy %s %s", geo.ASN, rule.Operator, strings.Join(values, ", "))
+	}
+
+	return e.matchStringValueDirect(rule, rule.Value, geo.ASOrganization, "AS organization")
+}
+
+// matchExpression evaluates the compiled expression of an expression rule
+func (e *Engine) matchExpression(rule *types.Rule, ctx *evalContext) (bool, string) {
+	compiled, ok := e.compiledExpr[rule.ID]
+	if !ok {
+		return false, fmt.Sprintf("expression %q is not compiled", rule.Value)
+	}
+
+	if compiled.eval(&exprEnv{req: ctx.req, geo: e.resolveGeo(ctx)}) {
+		return true, fmt.Sprintf("expression %s is true", rule.Value)
+	}
+	return false, fmt.Sprintf("expression %s is false", rule.Value)
+}
+
+// parseASN parses an AS number with an optional "AS" prefix
+func parseASN(value string) (uint32, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > 2 && strings.EqualFold(value[:2], "AS") {
+		value = value[2:]
+	}
+	asn, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(asn), nil
+}
+
+// isBodyRule reports whether the rule type inspects the request body
+func isBodyRule(ruleType types.RuleType) bool {
+	return ruleType == types.RuleTypeJSONField || ruleType == types.RuleTypeFormField
+}
+
+// checkBody reports whether the body cannot be inspected for a body rule and
+// which configured action applies in that case
+func (e *Engine) checkBody(rule *types.Rule, ctx *evalContext) (types.Action, string, bool) {
+	if ctx.req.BodyTruncated {
+		return e.bodyConfig.OversizeAction, fmt.Sprintf("request body exceeds inspection limit of %d bytes", e.bodyConfig.MaxBodySize), true
+	}
+
+	body := ctx.parsedBody()
+	if len(body.raw) == 0 {
+		return "", "", false
+	}
+
+	var err error
+	switch rule.Type {
+	case types.RuleTypeJSONField:
+		_, err = body.json()
+	case types.RuleTypeFormField:
+		_, err = body.formValues()
+	}
+	if err != nil {
+		return e.bodyConfig.ParseErrorAction, err.Error(), true
+	}
+
+	return "", "", false
+}
+
+// matchJSONField matches a field of a JSON request body
+func (e *Engine) matchJSONField(rule *types.Rule, ctx *evalContext) (bool, string) {
+	body := ctx.parsedBody()
+	if len(body.raw) == 0 {
+		return false, "request has no body"
+	}
+
+	doc, err := body.json()
+	if err != nil {
+		return false, err.Error()
+	}
+
+	value, ok := lookupJSONPath(doc, rule.Field)
+	if !ok {
+		if isNegated(rule.Operator) {
+			return true, fmt.Sprintf("JSON field %s not present", rule.Field)
+		}
+		return false, fmt.Sprintf("JSON field %s not present", rule.Field)
+	}
+
+	return e.matchStringValueDirect(rule, rule.Value, value, fmt.Sprintf("JSON field %s", rule.Field))
+}
+
+// matchFormField matches a field of a urlencoded or multipart form body
+func (e *Engine) matchFormField(rule *types.Rule, ctx *evalContext) (bool, string) {
+	body := ctx.parsedBody()
+	if len(body.raw) == 0 {
+		return false, "request has no body"
+	}
+
+	form, err := body.formValues()
+	if err != nil {
+		return false, err.Error()
+	}
+
+	values, exists := form[rule.Field]
+	if !exists && !isNegated(rule.Operator) {
+		return false, fmt.Sprintf("form field %s not present", rule.Field)
 	}
 
-	return false, fmt.Sprintf("header %s values do not match rule", rule.HeaderName)
+	return e.matchValues(rule, rule.Value, values, fmt.Sprintf("form field %s", rule.Field))
 }
 
 // matchStringValue matches string values using various operators
 func (e *Engine) matchStringValue(rule *types.Rule, value, fieldName string) (bool, string) {
-	return e.matchStringValueDirect(rule.Operator, rule.Value, value, fieldName, rule.ID)
+	return e.matchStringValueDirect(rule, rule.Value, value, fieldName)
 }
 
-// matchStringValueDirect matches string values directly
-func (e *Engine) matchStringValueDirect(operator types.MatchOperator, ruleValue, actualValue, fieldName, ruleID string) (bool, string) {
+// matchStringValueDirect matches a request value against a rule value with the
+// rule's operator, after field normalization and case folding
+func (e *Engine) matchStringValueDirect(rule *types.Rule, ruleValue, actualValue, fieldName string) (bool, string) {
+	operator := positiveOperator(rule.Operator)
+	if operator == types.MatchIn {
+		ruleValue = strings.Join(rule.Values, ", ")
+	}
+
+	matched := e.matchOperator(rule, operator, ruleValue, actualValue)
+	if isNegated(rule.Operator) {
+		matched = !matched
+	}
+
+	if matched {
+		return true, fmt.Sprintf("%s '%s' %s '%s'", fieldName, actualValue, operatorDescriptions[rule.Operator], ruleValue)
+	}
+	return false, fmt.Sprintf("%s '%s' does not match '%s' with operator %s", fieldName, actualValue, ruleValue, rule.Operator)
+}
+
+// matchOperator applies a positive string operator
+func (e *Engine) matchOperator(rule *types.Rule, operator types.MatchOperator, ruleValue, actualValue string) bool {
+	sensitive := e.caseSensitive(rule)
+	fold := func(value string) string {
+		if !e.legacyCase {
+			value = normalizeValue(rule.Type, value)
+		}
+		if !sensitive {
+			value = strings.ToLower(value)
+		}
+		return value
+	}
+
+	if operator == types.MatchRegex || operator == types.MatchWildcard {
+		// Case folding is compiled into the regex
+		if !e.legacyCase {
+			actualValue = normalizeValue(rule.Type, actualValue)
+		}
+		regex, ok := e.compiledRegex[rule.ID]
+		if operator == types.MatchWildcard {
+			regex, ok = e.wildcardRegex(rule)
+		}
+		return ok && regex.MatchString(actualValue)
+	}
+
+	actual := fold(actualValue)
 	switch operator {
 	case types.MatchEquals:
-		if actualValue == ruleValue {
-			return true, fmt.Sprintf("%s '%s' equals '%s'", fieldName, actualValue, ruleValue)
-		}
+		return actual == fold(ruleValue)
 	case types.MatchContains:
-		if strings.Contains(strings.ToLower(actualValue), strings.ToLower(ruleValue)) {
-			return true, fmt.Sprintf("%s '%s' contains '%s'", fieldName, actualValue, ruleValue)
-		}
+		return strings.Contains(actual, fold(ruleValue))
 	case types.MatchStartsWith:
-		if strings.HasPrefix(strings.ToLower(actualValue), strings.ToLower(ruleValue)) {
-			return true, fmt.Sprintf("%s '%s' starts with '%s'", fieldName, actualValue, ruleValue)
-		}
+		return strings.HasPrefix(actual, fold(ruleValue))
 	case types.MatchEndsWith:
-		if strings.HasSuffix(strings.ToLower(actualValue), strings.ToLower(ruleValue)) {
-			return true, fmt.Sprintf("%s '%s' ends with '%s'", fieldName, actualValue, ruleValue)
+		return strings.HasSuffix(actual, fold(ruleValue))
+	case types.MatchIn:
+		for _, value := range rule.Values {
+			if actual == fold(value) {
+				return true
+			}
 		}
-	case types.MatchWildcard:
-		matched, _ := filepath.Match(ruleValue, actualValue)
-		if matched {
-			return true, fmt.Sprintf("%s '%s' matches wildcard '%s'", fieldName, actualValue, ruleValue)
+	}
+	return false
+}
+
+// negatedOperators maps each negated operator to the operator it negates
+var negatedOperators = map[types.MatchOperator]types.MatchOperator{
+	types.MatchNotEquals:     types.MatchEquals,
+	types.MatchNotContains:   types.MatchContains,
+	types.MatchNotStartsWith: types.MatchStartsWith,
+	types.MatchNotEndsWith:   types.MatchEndsWith,
+	types.MatchNotRegex:      types.MatchRegex,
+	types.MatchNotWildcard:   types.MatchWildcard,
+	types.MatchNotIn:         types.MatchIn,
+}
+
+// operatorDescriptions phrase a successful match in reasons
+var operatorDescriptions = map[types.MatchOperator]string{
+	types.MatchEquals:        "equals",
+	types.MatchContains:      "contains",
+	types.MatchStartsWith:    "starts with",
+	types.MatchEndsWith:      "ends with",
+	types.MatchRegex:         "matches regex",
+	types.MatchWildcard:      "matches wildcard",
+	types.MatchIn:            "is one of",
+	types.MatchNotEquals:     "does not equal",
+	types.MatchNotContains:   "does not contain",
+	types.MatchNotStartsWith: "does not start with",
+	types.MatchNotEndsWith:   "does not end with",
+	types.MatchNotRegex:      "does not match regex",
+	types.MatchNotWildcard:   "does not match wildcard",
+	types.MatchNotIn:         "is none of",
+}
+
+// isNegated reports whether operator is a negated operator
+func isNegated(operator types.MatchOperator) bool {
+	_, negated := negatedOperators[operator]
+	return negated
+}
+
+// positiveOperator returns the operator a negated operator negates, or the
+// operator itself
+func positiveOperator(operator types.MatchOperator) types.MatchOperator {
+	if positive, negated := negatedOperators[operator]; negated {
+		return positive
+	}
+	return operator
+}
+
+// caseSensitive reports whether a string rule compares case-sensitively
+func (e *Engine) caseSensitive(rule *types.Rule) bool {
+	if rule.CaseSensitive != nil {
+		return *rule.CaseSensitive
+	}
+	if e.legacyCase {
+		// Legacy matching: only equals, wildcard and regex are case-sensitive
+		switch positiveOperator(rule.Operator) {
+		case types.MatchEquals, types.MatchIn, types.MatchWildcard, types.MatchRegex:
+			return true
 		}
+	}
+	return false
+}
+
+// SetLegacyCaseMatching switches between the default case-insensitive
+// matching with field normalization and the legacy per-operator behavior
+func (e *Engine) SetLegacyCaseMatching(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.legacyCase = enabled
+
+	// Regex case folding depends on the setting
+	e.compiledRegex = make(map[string]*regexp.Regexp)
+	e.compilePatterns()
+}
+
+// normalizeValue applies the normalization of the request field matched by a
+// rule type: domains are lowercased without a trailing dot and methods uppercased
+func normalizeValue(ruleType types.RuleType, value string) string {
+	switch ruleType {
+	case types.RuleTypeDomain:
+		return strings.TrimRight(strings.ToLower(value), ".")
+	case types.RuleTypeMethod:
+		return strings.ToUpper(value)
+	}
+	return value
+}
+
+// compilePatterns pre-compiles regex patterns and CIDR ranges for better performance
+func (e *Engine) compilePatterns() {
+	for _, rule := range e.rules {
+		e.compileRule(rule)
+	}
+}
+
+// compileWildcard compiles the glob of a wildcard rule to a regex
+func (e *Engine) compileWildcard(rule types.Rule) (*regexp.Regexp, error) {
+	pattern := rulePattern(rule)
+	if !e.legacyCase {
+		pattern = normalizeValue(rule.Type, pattern)
+	}
+	return compileGlob(pattern, rule.Type == types.RuleTypeDomain, e.caseSensitive(&rule))
+}
+
+// wildcardRegex returns the compiled glob of a wildcard rule. Rules that
+// were not added to the engine are compiled on every call.
+func (e *Engine) wildcardRegex(rule *types.Rule) (*regexp.Regexp, bool) {
+	if regex, ok := e.compiledRegex[rule.ID]; ok {
+		return regex, true
+	}
+	regex, err := e.compileWildcard(*rule)
+	return regex, err == nil
+}
+
+// compileRule pre-compiles the pattern of a single rule. Invalid patterns are
+// skipped; rules are expected to have passed ValidateRules.
+func (e *Engine) compileRule(rule types.Rule) {
+	pattern := rulePattern(rule)
+	switch positiveOperator(rule.Operator) {
 	case types.MatchRegex:
-		if regex, ok := e.compiledRegex[ruleID]; ok && regex.MatchString(actualValue) {
-			return true, fmt.Sprintf("%s '%s' matches regex '%s'", fieldName, actualValue, ruleValue)
+		if pattern == "" {
+			break
+		}
+		if !e.caseSensitive(&rule) {
+			pattern = "(?i)" + pattern
+		}
+		if regex, err := regexp.Compile(pattern); err == nil {
+			e.compiledRegex[rule.ID] = regex
+		}
+	case types.MatchWildcard:
+		// Wildcards are compiled to regexes as well
+		if regex, err := e.compileWildcard(rule); err == nil {
+			e.compiledRegex[rule.ID] = regex
+		}
+	}
+
+	if rule.Schedule != nil {
+		if compiled, err := compileSchedule(rule.Schedule); err == nil {
+			e.schedules[rule.ID] = compiled
+		}
+	}
+
+	if rule.Type == types.RuleTypeExpression {
+		if compiled, err := compileExpression(rule.Value); err == nil {
+			e.compiledExpr[rule.ID] = compiled
+		}
+	}
+	// Custom matchers of validated rules were compiled during validation
+	if _, custom := matcher.Lookup(rule.Type); custom && e.custom[rule.ID] == nil {
+		if compiled, err := matcher.Compile(rule); err == nil {
+			e.custom[rule.ID] = compiled
+		}
+	}
+
+	isIPRule := rule.Type == types.RuleTypeIPv4 || rule.Type == types.RuleTypeIPv6
+	if isIPRule && rule.Operator == types.MatchInRange {
+		if _, network, err := net.ParseCIDR(rule.Value); err == nil {
+			e.compiledCIDR[rule.ID] = network
 		}
 	}
+}
 
-	return false, fmt.Sprintf("%s '%s' does not match '%s' with operator %s", fieldName, actualValue, ruleValue, operator)
+// resolveTTLs converts the TTLs of rules into absolute expiry times
+func resolveTTLs(rules []types.Rule, now time.Time) {
+	for i := range rules {
+		resolveTTL(&rules[i], now)
+	}
 }
 
-// compileRegexPatterns pre-compiles regex patterns for better performance
-func (e *Engine) compileRegexPatterns() {
+// resolveTTL sets ExpiresAt from TTL so the expiry survives saving and reloading
+func resolveTTL(rule *types.Rule, now time.Time) {
+	if rule.TTL <= 0 {
+		return
+	}
+	if rule.ExpiresAt == nil {
+		expiresAt := now.Add(rule.TTL).UTC()
+		rule.ExpiresAt = &expiresAt
+	}
+	rule.TTL = 0
+}
+
+// ExpireRules removes, or disables when disable is set, every enabled rule that
+// has expired at now and returns the affected rules
+func (e *Engine) ExpireRules(now time.Time, disable bool) []types.Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var expired []types.Rule
+	kept := e.rules[:0]
 	for _, rule := range e.rules {
-		if rule.Operator == types.MatchRegex && rule.Value != "" {
-			if regex, err := regexp.Compile(rule.Value); err == nil {
-				e.compiledRegex[rule.ID] = regex
-			}
+		if rule.ExpiresAt == nil || now.Before(*rule.ExpiresAt) || !rule.Enabled {
+			kept = append(kept, rule)
+			continue
+		}
+
+		expired = append(expired, rule)
+		if disable {
+			rule.Enabled = false
+			kept = append(kept, rule)
+			continue
 		}
+		delete(e.compiledRegex, rule.ID)
+		delete(e.compiledCIDR, rule.ID)
+		delete(e.compiledExpr, rule.ID)
+		delete(e.custom, rule.ID)
+		delete(e.schedules, rule.ID)
 	}
+	e.rules = kept
+
+	if len(expired) > 0 {
+		e.rebuild()
+	}
+	return expired
 }
 
 // GetRules returns a copy of all rules
@@ -317,11 +1083,20 @@ func (e *Engine) GetRuleByID(id string) (*types.Rule, bool) {
 	return nil, false
 }
 
-// AddRule adds a new rule to the engine
-func (e *Engine) AddRule(rule types.Rule) {
+// AddRule validates a rule and adds it to the engine
+func (e *Engine) AddRule(rule types.Rule) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	compiled, err := validateAddedRule(e.rules, rule)
+	if err != nil {
+		return err
+	}
+	if compiled != nil {
+		e.custom[rule.ID] = compiled
+	}
+
+	resolveTTL(&rule, e.now())
 	e.rules = append(e.rules, rule)
 
 	// Re-sort by priority
@@ -329,12 +1104,46 @@ func (e *Engine) AddRule(rule types.Rule) {
 		return e.rules[i].Priority < e.rules[j].Priority
 	})
 
-	// Compile regex if needed
-	if rule.Operator == types.MatchRegex && rule.Value != "" {
-		if regex, err := regexp.Compile(rule.Value); err == nil {
-			e.compiledRegex[rule.ID] = regex
+	// Compile patterns if needed
+	e.compileRule(rule)
+	e.rebuild()
+	return nil
+}
+
+// UpdateRule validates a rule and replaces the rule with the same ID, keeping
+// its counters. It returns ErrRuleNotFound if no rule has that ID.
+func (e *Engine) UpdateRule(rule types.Rule) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.rules {
+		if e.rules[i].ID != rule.ID {
+			continue
+		}
+
+		custom, err := validateRules([]types.Rule{rule})
+		if err != nil {
+			return err
+		}
+
+		resolveTTL(&rule, e.now())
+		e.rules[i] = rule
+		sort.Slice(e.rules, func(i, j int) bool {
+			return e.rules[i].Priority < e.rules[j].Priority
+		})
+		delete(e.compiledRegex, rule.ID)
+		delete(e.compiledCIDR, rule.ID)
+		delete(e.compiledExpr, rule.ID)
+		delete(e.custom, rule.ID)
+		delete(e.schedules, rule.ID)
+		if compiled, ok := custom[rule.ID]; ok {
+			e.custom[rule.ID] = compiled
 		}
+		e.compileRule(rule)
+		e.rebuild()
+		return nil
 	}
+	return fmt.Errorf("%w: %s", ErrRuleNotFound, rule.ID)
 }
 
 // RemoveRule removes a rule by its ID
@@ -346,6 +1155,11 @@ func (e *Engine) RemoveRule(id string) bool {
 		if rule.ID == id {
 			e.rules = append(e.rules[:i], e.rules[i+1:]...)
 			delete(e.compiledRegex, id)
+			delete(e.compiledCIDR, id)
+			delete(e.compiledExpr, id)
+			delete(e.custom, id)
+			delete(e.schedules, id)
+			e.rebuild()
 			return true
 		}
 	}
@@ -360,6 +1174,7 @@ func (e *Engine) EnableRule(id string) bool {
 	for i, rule := range e.rules {
 		if rule.ID == id {
 			e.rules[i].Enabled = true
+			e.rebuild()
 			return true
 		}
 	}
@@ -374,6 +1189,7 @@ func (e *Engine) DisableRule(id string) bool {
 	for i, rule := range e.rules {
 		if rule.ID == id {
 			e.rules[i].Enabled = false
+			e.rebuild()
 			return true
 		}
 	}
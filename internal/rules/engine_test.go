@@ -1,9 +1,11 @@
 package rules
 
 import (
+	"errors"
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"http-proxy/pkg/types"
 )
@@ -179,6 +181,18 @@ func TestEngine_MatchURL(t *testing.T) {
 			requestURL:  "/api/users/delete",
 			expectMatch: true,
 		},
+		{
+			name: "URL wildcard crosses path segments",
+			rule: types.Rule{
+				ID:       "url-wildcard-nested",
+				Type:     types.RuleTypeURL,
+				Operator: types.MatchWildcard,
+				Value:    "/api/*",
+				Action:   types.ActionBlock,
+			},
+			requestURL:  "/api/v1/users",
+			expectMatch: true,
+		},
 		{
 			name: "URL no match",
 			rule: types.Rule{
@@ -675,15 +689,25 @@ func TestEngine_UpdateRules(t *testing.T) {
 	newRules := []types.Rule{
 		{
 			ID:       "rule3",
+			Type:     types.RuleTypeURL,
+			Operator: types.MatchStartsWith,
+			Value:    "/admin",
+			Action:   types.ActionBlock,
 			Priority: 50,
 		},
 		{
 			ID:       "rule4",
+			Type:     types.RuleTypeURL,
+			Operator: types.MatchStartsWith,
+			Value:    "/api",
+			Action:   types.ActionAllow,
 			Priority: 150,
 		},
 	}
 
-	engine.UpdateRules(newRules)
+	if err := engine.UpdateRules(newRules); err != nil {
+		t.Fatalf("Expected no error updating rules, got: %v", err)
+	}
 
 	rules := engine.GetRules()
 	if len(rules) != 2 {
@@ -700,4 +724,722 @@ func TestEngine_UpdateRules(t *testing.T) {
 	if exists {
 		t.Errorf("Old rule should not exist after update")
 	}
+
+	// An invalid list is rejected as a whole
+	invalid := newRules[0]
+	invalid.ID, invalid.Operator, invalid.Value = "bad-regex", types.MatchRegex, "(["
+	if err := engine.UpdateRules([]types.Rule{newRules[1], invalid}); err == nil {
+		t.Errorf("Expected error for invalid regex rule")
+	}
+	if rules := engine.GetRules(); len(rules) != 2 || rules[0].ID != "rule3" {
+		t.Errorf("Expected rules to be unchanged after rejected update, got %d rules", len(rules))
+	}
+}
+
+func TestEngine_MatchBodyFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        types.Rule
+		contentType string
+		body        string
+		expectMatch bool
+	}{
+		{
+			name: "JSON field equals",
+			rule: types.Rule{
+				ID:       "json-role",
+				Type:     types.RuleTypeJSONField,
+				Operator: types.MatchEquals,
+				Field:    "user.role",
+				Value:    "admin",
+				Action:   types.ActionBlock,
+			},
+			contentType: "application/json",
+			body:        `{"user":{"role":"admin"}}`,
+			expectMatch: true,
+		},
+		{
+			name: "JSON array element contains",
+			rule: types.Rule{
+				ID:       "json-query",
+				Type:     types.RuleTypeJSONField,
+				Operator: types.MatchContains,
+				Field:    "queries[1]",
+				Value:    "drop table",
+				Action:   types.ActionBlock,
+			},
+			contentType: "application/json",
+			body:        `{"queries":["select 1","DROP TABLE users"]}`,
+			expectMatch: true,
+		},
+		{
+			name: "JSON field missing",
+			rule: types.Rule{
+				ID:       "json-missing",
+				Type:     types.RuleTypeJSONField,
+				Operator: types.MatchEquals,
+				Field:    "user.role",
+				Value:    "admin",
+				Action:   types.ActionBlock,
+			},
+			contentType: "application/json",
+			body:        `{"user":{}}`,
+			expectMatch: false,
+		},
+		{
+			name: "Form field starts with",
+			rule: types.Rule{
+				ID:       "form-redirect",
+				Type:     types.RuleTypeFormField,
+				Operator: types.MatchStartsWith,
+				Field:    "redirect",
+				Value:    "http://",
+				Action:   types.ActionBlock,
+			},
+			contentType: "application/x-www-form-urlencoded",
+			body:        "user=alice&redirect=http%3A%2F%2Fevil.example",
+			expectMatch: true,
+		},
+		{
+			name: "Form field not present",
+			rule: types.Rule{
+				ID:       "form-missing",
+				Type:     types.RuleTypeFormField,
+				Operator: types.MatchEquals,
+				Field:    "token",
+				Value:    "x",
+				Action:   types.ActionBlock,
+			},
+			contentType: "application/x-www-form-urlencoded",
+			body:        "user=alice",
+			expectMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine([]types.Rule{}, types.ActionAllow)
+
+			req := &types.RequestInfo{
+				Headers: map[string][]string{"content-type": {tt.contentType}},
+				Body:    []byte(tt.body),
+			}
+
+			matched, _ := engine.matchRule(&tt.rule, req)
+
+			if matched != tt.expectMatch {
+				t.Errorf("Expected match: %v, got: %v", tt.expectMatch, matched)
+			}
+		})
+	}
+}
+
+func TestEngine_BodyInspectionFailures(t *testing.T) {
+	rules := []types.Rule{
+		{
+			ID:       "json-role",
+			Type:     types.RuleTypeJSONField,
+			Operator: types.MatchEquals,
+			Field:    "role",
+			Value:    "admin",
+			Action:   types.ActionBlock,
+			Priority: 10,
+			Enabled:  true,
+		},
+	}
+
+	tests := []struct {
+		name           string
+		config         types.BodyInspectionConfig
+		request        *types.RequestInfo
+		expectedAction types.Action
+		expectedRuleID string
+	}{
+		{
+			name:           "Oversized body skips rule by default",
+			config:         types.BodyInspectionConfig{MaxBodySize: 8},
+			request:        &types.RequestInfo{Body: []byte(`{"role":`), BodyTruncated: true},
+			expectedAction: types.ActionAllow,
+		},
+		{
+			name:           "Oversized body uses configured action",
+			config:         types.BodyInspectionConfig{MaxBodySize: 8, OversizeAction: types.ActionBlock},
+			request:        &types.RequestInfo{Body: []byte(`{"role":`), BodyTruncated: true},
+			expectedAction: types.ActionBlock,
+			expectedRuleID: "json-role",
+		},
+		{
+			name:           "Invalid JSON uses configured action",
+			config:         types.BodyInspectionConfig{ParseErrorAction: types.ActionBlock},
+			request:        &types.RequestInfo{Body: []byte(`not json`)},
+			expectedAction: types.ActionBlock,
+			expectedRuleID: "json-role",
+		},
+		{
+			name:           "Empty body is not a parse error",
+			config:         types.BodyInspectionConfig{ParseErrorAction: types.ActionBlock},
+			request:        &types.RequestInfo{},
+			expectedAction: types.ActionAllow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(rules, types.ActionAllow)
+			engine.SetBodyInspection(tt.config)
+
+			result := engine.EvaluateRequest(tt.request)
+
+			if result.Action != tt.expectedAction {
+				t.Errorf("Expected action: %v, got: %v (%s)", tt.expectedAction, result.Action, result.Reason)
+			}
+
+			if tt.expectedRuleID != "" {
+				if result.Rule == nil || result.Rule.ID != tt.expectedRuleID {
+					t.Errorf("Expected rule %s to decide the request, got %+v", tt.expectedRuleID, result.Rule)
+				}
+			} else if result.Rule != nil {
+				t.Errorf("Expected no rule to match, but got: %s", result.Rule.ID)
+			}
+		})
+	}
+}
+
+// staticGeoResolver resolves every IP to the same location
+type staticGeoResolver struct {
+	info *types.GeoInfo
+}
+
+func (r staticGeoResolver) Lookup(ip net.IP) (*types.GeoInfo, bool) {
+	return r.info, r.info != nil
+}
+
+func TestEngine_MatchGeo(t *testing.T) {
+	geo := &types.GeoInfo{Country: "CN", ASN: 4134, ASOrganization: "Chinanet"}
+
+	tests := []struct {
+		name        string
+		rule        types.Rule
+		resolver    GeoResolver
+		expectMatch bool
+	}{
+		{
+			name:        "Country equals is case-insensitive",
+			rule:        types.Rule{ID: "geo-cn", Type: types.RuleTypeCountry, Operator: types.MatchEquals, Value: "cn"},
+			resolver:    staticGeoResolver{info: geo},
+			expectMatch: true,
+		},
+		{
+			name:        "Country regex",
+			rule:        types.Rule{ID: "geo-re", Type: types.RuleTypeCountry, Operator: types.MatchRegex, Value: "^(CN|RU)$"},
+			resolver:    staticGeoResolver{info: geo},
+			expectMatch: true,
+		},
+		{
+			name:        "Country mismatch",
+			rule:        types.Rule{ID: "geo-us", Type: types.RuleTypeCountry, Operator: types.MatchEquals, Value: "US"},
+			resolver:    staticGeoResolver{info: geo},
+			expectMatch: false,
+		},
+		{
+			name:        "ASN with prefix",
+			rule:        types.Rule{ID: "asn-eq", Type: types.RuleTypeASN, Operator: types.MatchEquals, Value: "AS4134"},
+			resolver:    staticGeoResolver{info: geo},
+			expectMatch: true,
+		},
+		{
+			name:        "ASN organization contains",
+			rule:        types.Rule{ID: "asn-org", Type: types.RuleTypeASN, Operator: types.MatchContains, Value: "chinanet"},
+			resolver:    staticGeoResolver{info: geo},
+			expectMatch: true,
+		},
+		{
+			name:        "Unknown location",
+			rule:        types.Rule{ID: "geo-none", Type: types.RuleTypeCountry, Operator: types.MatchEquals, Value: "CN"},
+			resolver:    staticGeoResolver{},
+			expectMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = types.ActionBlock
+			tt.rule.Enabled = true
+			engine := NewEngine([]types.Rule{tt.rule}, types.ActionAllow)
+			engine.SetGeoResolver(tt.resolver)
+
+			result := engine.EvaluateRequest(&types.RequestInfo{ClientIP: net.ParseIP("1.2.3.4")})
+
+			if result.Matched != tt.expectMatch {
+				t.Errorf("Expected match: %v, got: %v (%s)", tt.expectMatch, result.Matched, result.Reason)
+			}
+			if !reflect.DeepEqual(result.Geo, tt.resolver.(staticGeoResolver).info) {
+				t.Errorf("Expected resolved location to be reported, got %+v", result.Geo)
+			}
+		})
+	}
+}
+
+// staticIPSets is an in-memory IPSetLookup for tests
+type staticIPSets map[string]*net.IPNet
+
+func (s staticIPSets) Contains(name string, ip net.IP) (bool, bool) {
+	network, exists := s[name]
+	if !exists {
+		return false, false
+	}
+	return network.Contains(ip), true
+}
+
+func TestEngine_MatchIPSet(t *testing.T) {
+	_, denylist, _ := net.ParseCIDR("203.0.113.0/24")
+
+	engine := NewEngine([]types.Rule{}, types.ActionAllow)
+	engine.SetIPSets(staticIPSets{"denylist": denylist})
+
+	tests := []struct {
+		name        string
+		rule        types.Rule
+		clientIP    string
+		expectMatch bool
+	}{
+		{
+			name:        "IP in set",
+			rule:        types.Rule{ID: "set", Type: types.RuleTypeIPv4, Operator: types.MatchInSet, Value: "denylist"},
+			clientIP:    "203.0.113.10",
+			expectMatch: true,
+		},
+		{
+			name:        "IP not in set",
+			rule:        types.Rule{ID: "set", Type: types.RuleTypeIPv4, Operator: types.MatchInSet, Value: "denylist"},
+			clientIP:    "198.51.100.1",
+			expectMatch: false,
+		},
+		{
+			name:        "Unknown set",
+			rule:        types.Rule{ID: "set", Type: types.RuleTypeIPv4, Operator: types.MatchInSet, Value: "missing"},
+			clientIP:    "203.0.113.10",
+			expectMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, _ := engine.matchRule(&tt.rule, &types.RequestInfo{ClientIP: net.ParseIP(tt.clientIP)})
+			if matched != tt.expectMatch {
+				t.Errorf("Expected match: %v, got: %v", tt.expectMatch, matched)
+			}
+		})
+	}
+}
+
+func TestEngine_RuleExpiry(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	engine := NewEngine([]types.Rule{}, types.ActionAllow)
+	engine.SetClock(func() time.Time { return now })
+
+	engine.AddRule(types.Rule{
+		ID: "incident-block", Type: types.RuleTypeURL, Operator: types.MatchStartsWith,
+		Value: "/checkout", Action: types.ActionBlock, Priority: 10, Enabled: true,
+		TTL: 30 * time.Minute,
+	})
+
+	rule, _ := engine.GetRuleByID("incident-block")
+	if rule.ExpiresAt == nil || !rule.ExpiresAt.Equal(now.Add(30*time.Minute)) {
+		t.Fatalf("Expected TTL to be converted to expiry at %s, got %v", now.Add(30*time.Minute), rule.ExpiresAt)
+	}
+	if rule.TTL != 0 {
+		t.Errorf("Expected TTL to be cleared once converted, got %v", rule.TTL)
+	}
+
+	req := &types.RequestInfo{URL: "/checkout/pay"}
+	if result := engine.EvaluateRequest(req); result.Action != types.ActionBlock {
+		t.Errorf("Expected rule to apply before expiry")
+	}
+
+	// Expired rules stop matching even before they are swept
+	now = now.Add(time.Hour)
+	if result := engine.EvaluateRequest(req); result.Matched {
+		t.Errorf("Expected expired rule not to match")
+	}
+
+	expired := engine.ExpireRules(now, false)
+	if len(expired) != 1 || expired[0].ID != "incident-block" {
+		t.Errorf("Expected incident-block to expire, got %+v", expired)
+	}
+	if _, exists := engine.GetRuleByID("incident-block"); exists {
+		t.Errorf("Expected expired rule to be removed")
+	}
+}
+
+func TestEngine_ShadowMode(t *testing.T) {
+	rules := []types.Rule{
+		{
+			ID: "new-block", Type: types.RuleTypeUserAgent, Operator: types.MatchContains,
+			Value: "curl", Action: types.ActionBlock, Priority: 10, Enabled: true,
+			Mode: types.RuleModeShadow,
+		},
+		{
+			ID: "allow-api", Type: types.RuleTypeURL, Operator: types.MatchStartsWith,
+			Value: "/api", Action: types.ActionAllow, Priority: 20, Enabled: true,
+		},
+	}
+
+	engine := NewEngine(rules, types.ActionBlock)
+	req := &types.RequestInfo{URL: "/api/users", UserAgent: "curl/8.0"}
+
+	result := engine.EvaluateRequest(req)
+	if result.Action != types.ActionAllow || result.Rule == nil || result.Rule.ID != "allow-api" {
+		t.Errorf("Expected next enforcing rule to decide, got %+v", result)
+	}
+	if result.ShadowRule == nil || result.ShadowRule.ID != "new-block" {
+		t.Errorf("Expected shadow match to be recorded, got %+v", result.ShadowRule)
+	}
+
+	// Without a later match the default action decides
+	result = engine.EvaluateRequest(&types.RequestInfo{URL: "/other", UserAgent: "curl/8.0"})
+	if result.Matched || result.Action != types.ActionBlock || result.ShadowRule == nil {
+		t.Errorf("Expected default action with shadow match, got %+v", result)
+	}
+
+	if stats, _ := engine.GetRuleStatsByID("new-block"); stats.ShadowMatches != 2 || stats.Matches != 0 {
+		t.Errorf("Expected 2 shadow matches, got %+v", stats)
+	}
+
+	// Global shadow mode records the first match and applies the default action
+	engine.SetShadowMode(true)
+	result = engine.EvaluateRequest(req)
+	if result.Matched || result.Action != types.ActionBlock {
+		t.Errorf("Expected default action in global shadow mode, got %+v", result)
+	}
+	if result.ShadowRule == nil || result.ShadowRule.ID != "new-block" {
+		t.Errorf("Expected first shadow match to be new-block, got %+v", result.ShadowRule)
+	}
+	if stats, _ := engine.GetRuleStatsByID("allow-api"); stats.ShadowMatches != 1 {
+		t.Errorf("Expected allow-api to count a shadow match, got %+v", stats)
+	}
+}
+
+func TestEngine_RuleStats(t *testing.T) {
+	rules := []types.Rule{
+		{ID: "block-admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionBlock, Priority: 100, Enabled: true},
+		{ID: "allow-api", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/api", Action: types.ActionAllow, Priority: 200, Enabled: true},
+	}
+	engine := NewEngine(rules, types.ActionAllow)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	engine.SetClock(func() time.Time { return now })
+
+	engine.EvaluateRequest(&types.RequestInfo{URL: "/admin/users", ClientIP: net.ParseIP("10.0.0.1")})
+	engine.EvaluateRequest(&types.RequestInfo{URL: "/admin/settings", ClientIP: net.ParseIP("10.0.0.2")})
+
+	stats, exists := engine.GetRuleStatsByID("block-admin")
+	if !exists {
+		t.Fatal("Expected stats for block-admin")
+	}
+	if stats.Matches != 2 {
+		t.Errorf("Expected 2 matches, got %d", stats.Matches)
+	}
+	if stats.LastMatched == nil || !stats.LastMatched.Equal(now) {
+		t.Errorf("Expected last matched %v, got %v", now, stats.LastMatched)
+	}
+	if stats.LastClientIP != "10.0.0.2" {
+		t.Errorf("Expected last client IP 10.0.0.2, got %s", stats.LastClientIP)
+	}
+
+	unused, _ := engine.GetRuleStatsByID("allow-api")
+	if unused.Matches != 0 || unused.LastMatched != nil || unused.LastClientIP != "" {
+		t.Errorf("Expected no matches for allow-api, got %+v", unused)
+	}
+
+	// Counters survive rule updates for rule IDs that remain
+	rules[0].Value = "/admin/"
+	_ = engine.UpdateRules([]types.Rule{rules[0], {ID: "new-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/new", Action: types.ActionBlock, Priority: 50, Enabled: true}})
+
+	if stats, _ := engine.GetRuleStatsByID("block-admin"); stats.Matches != 2 {
+		t.Errorf("Expected counters to survive UpdateRules, got %+v", stats)
+	}
+	if _, exists := engine.GetRuleStatsByID("allow-api"); exists {
+		t.Error("Expected stats of removed rule to be dropped")
+	}
+
+	all := engine.GetRuleStats()
+	if len(all) != 2 || all[0].RuleID != "new-rule" || all[1].RuleID != "block-admin" {
+		t.Errorf("Expected stats in priority order, got %+v", all)
+	}
+
+	// Replacing a single rule keeps its counters
+	rules[0].Priority = 10
+	if err := engine.UpdateRule(rules[0]); err != nil {
+		t.Fatalf("Expected UpdateRule to succeed, got %v", err)
+	}
+	if stats, _ := engine.GetRuleStatsByID("block-admin"); stats.Matches != 2 {
+		t.Errorf("Expected counters to survive UpdateRule, got %+v", stats)
+	}
+	if all := engine.GetRuleStats(); all[0].RuleID != "block-admin" {
+		t.Errorf("Expected UpdateRule to re-sort by priority, got %+v", all)
+	}
+}
+
+func TestEngine_Explain(t *testing.T) {
+	past := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rules := []types.Rule{
+		{ID: "expired", Type: types.RuleTypeURL, Operator: types.MatchContains, Value: "admin", Action: types.ActionBlock, Priority: 10, Enabled: true, ExpiresAt: &past},
+		{ID: "disabled", Type: types.RuleTypeURL, Operator: types.MatchContains, Value: "admin", Action: types.ActionBlock, Priority: 20, Enabled: false},
+		{ID: "wrong-method", Type: types.RuleTypeMethod, Operator: types.MatchEquals, Value: "DELETE", Action: types.ActionBlock, Priority: 30, Enabled: true},
+		{ID: "shadow", Type: types.RuleTypeURL, Operator: types.MatchContains, Value: "admin", Action: types.ActionBlock, Priority: 40, Enabled: true, Mode: types.RuleModeShadow},
+		{ID: "allow-admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionAllow, Priority: 50, Enabled: true},
+		{ID: "block-all", Type: types.RuleTypeURL, Operator: types.MatchRegex, Value: ".*", Action: types.ActionBlock, Priority: 60, Enabled: true},
+	}
+	engine := NewEngine(rules, types.ActionBlock)
+	engine.SetClock(func() time.Time { return past.Add(time.Hour) })
+
+	req := &types.RequestInfo{Method: "GET", URL: "/admin/users"}
+	explanation := engine.Explain(req)
+
+	expected := []types.TraceStatus{
+		types.TraceInactive,
+		types.TraceDisabled,
+		types.TraceNoMatch,
+		types.TraceShadowMatch,
+		types.TraceMatched,
+		types.TraceSkipped,
+	}
+	if len(explanation.Trace) != len(expected) {
+		t.Fatalf("Expected %d trace entries, got %d", len(expected), len(explanation.Trace))
+	}
+	for i, status := range expected {
+		trace := explanation.Trace[i]
+		if trace.RuleID != rules[i].ID || trace.Status != status {
+			t.Errorf("Expected %s to be %s, got %s with status %s", rules[i].ID, status, trace.RuleID, trace.Status)
+		}
+		if trace.Reason == "" {
+			t.Errorf("Expected a reason for %s", trace.RuleID)
+		}
+	}
+
+	if explanation.Action != types.ActionAllow || explanation.RuleID != "allow-admin" || explanation.ShadowRuleID != "shadow" {
+		t.Errorf("Unexpected explanation result: %+v", explanation)
+	}
+
+	// The explanation agrees with evaluation and does not count matches
+	result := engine.EvaluateRequest(req)
+	if result.Action != explanation.Action || result.Rule.ID != explanation.RuleID {
+		t.Errorf("Expected explanation to agree with evaluation, got %+v", result)
+	}
+	if stats, _ := engine.GetRuleStatsByID("allow-admin"); stats.Matches != 1 {
+		t.Errorf("Expected only the evaluation to be counted, got %+v", stats)
+	}
+
+	// Later rules decide when earlier ones do not match
+	explanation = engine.Explain(&types.RequestInfo{Method: "DELETE", URL: "/other"})
+	if explanation.RuleID != "wrong-method" || explanation.Trace[5].Status != types.TraceSkipped {
+		t.Errorf("Expected wrong-method to decide, got %+v", explanation)
+	}
+}
+
+func TestEngine_AddRule_Validation(t *testing.T) {
+	engine := NewEngine([]types.Rule{
+		{ID: "existing", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/a", Action: types.ActionBlock, Enabled: true},
+	}, types.ActionAllow)
+
+	if err := engine.AddRule(types.Rule{ID: "existing", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/b", Action: types.ActionBlock}); err == nil {
+		t.Error("Expected duplicate rule ID to be rejected")
+	}
+	if err := engine.AddRule(types.Rule{ID: "bad", Type: types.RuleTypeURL, Operator: types.MatchRegex, Value: "(", Action: types.ActionBlock}); err == nil {
+		t.Error("Expected invalid regex to be rejected")
+	}
+	if len(engine.GetRules()) != 1 {
+		t.Errorf("Expected rejected rules not to be added, got %d rules", len(engine.GetRules()))
+	}
+
+	if err := engine.UpdateRule(types.Rule{ID: "missing", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: types.ActionBlock}); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Expected ErrRuleNotFound, got %v", err)
+	}
+	if err := engine.UpdateRule(types.Rule{ID: "existing", Type: types.RuleTypeSize, Operator: types.MatchGTE, Action: types.ActionBlock}); err == nil {
+		t.Error("Expected size rule without min_size to be rejected")
+	}
+}
+
+func TestEngine_CaseSensitivity(t *testing.T) {
+	caseSensitive := true
+
+	tests := []struct {
+		name        string
+		rule        types.Rule
+		legacy      bool
+		req         types.RequestInfo
+		expectMatch bool
+	}{
+		{
+			name:        "equals ignores case by default",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/Admin"},
+			req:         types.RequestInfo{URL: "/admin"},
+			expectMatch: true,
+		},
+		{
+			name:        "case_sensitive rule",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/Admin", CaseSensitive: &caseSensitive},
+			req:         types.RequestInfo{URL: "/admin/users"},
+			expectMatch: false,
+		},
+		{
+			name:        "domain with trailing dot and upper case",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeDomain, Operator: types.MatchEquals, Value: "example.com"},
+			req:         types.RequestInfo{Domain: "Example.COM."},
+			expectMatch: true,
+		},
+		{
+			name:        "case-sensitive domain is still normalized",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeDomain, Operator: types.MatchEquals, Value: "Example.com", CaseSensitive: &caseSensitive},
+			req:         types.RequestInfo{Domain: "example.com."},
+			expectMatch: true,
+		},
+		{
+			name:        "lowercase method rule",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeMethod, Operator: types.MatchEquals, Value: "delete", CaseSensitive: &caseSensitive},
+			req:         types.RequestInfo{Method: "DELETE"},
+			expectMatch: true,
+		},
+		{
+			name:        "regex ignores case by default",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeUserAgent, Operator: types.MatchRegex, Value: "^curl/"},
+			req:         types.RequestInfo{UserAgent: "Curl/8.0"},
+			expectMatch: true,
+		},
+		{
+			name:        "wildcard ignores case by default",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeDomain, Operator: types.MatchWildcard, Value: "*.Example.com"},
+			req:         types.RequestInfo{Domain: "api.example.com"},
+			expectMatch: true,
+		},
+		{
+			name:        "legacy equals is case-sensitive",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/Admin"},
+			legacy:      true,
+			req:         types.RequestInfo{URL: "/admin"},
+			expectMatch: false,
+		},
+		{
+			name:        "legacy contains ignores case",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeUserAgent, Operator: types.MatchContains, Value: "BOT"},
+			legacy:      true,
+			req:         types.RequestInfo{UserAgent: "googlebot"},
+			expectMatch: true,
+		},
+		{
+			name:        "legacy does not normalize domains",
+			rule:        types.Rule{ID: "r", Type: types.RuleTypeDomain, Operator: types.MatchEquals, Value: "example.com"},
+			legacy:      true,
+			req:         types.RequestInfo{Domain: "example.com."},
+			expectMatch: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = types.ActionBlock
+			tt.rule.Enabled = true
+			engine := NewEngine([]types.Rule{tt.rule}, types.ActionAllow)
+			engine.SetLegacyCaseMatching(tt.legacy)
+
+			result := engine.EvaluateRequest(&tt.req)
+			if result.Matched != tt.expectMatch {
+				t.Errorf("Expected match: %v, got: %v (%s)", tt.expectMatch, result.Matched, result.Reason)
+			}
+		})
+	}
+}
+
+func TestEngine_NegatedAndSetOperators(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        types.Rule
+		req         types.RequestInfo
+		expectMatch bool
+	}{
+		{
+			name:        "not_contains matches other user agents",
+			rule:        types.Rule{Type: types.RuleTypeUserAgent, Operator: types.MatchNotContains, Value: "Mozilla"},
+			req:         types.RequestInfo{UserAgent: "curl/8.0"},
+			expectMatch: true,
+		},
+		{
+			name:        "not_contains rejects matching user agent",
+			rule:        types.Rule{Type: types.RuleTypeUserAgent, Operator: types.MatchNotContains, Value: "mozilla"},
+			req:         types.RequestInfo{UserAgent: "Mozilla/5.0"},
+			expectMatch: false,
+		},
+		{
+			name:        "not_regex",
+			rule:        types.Rule{Type: types.RuleTypeURL, Operator: types.MatchNotRegex, Value: `^/api/v[0-9]+/`},
+			req:         types.RequestInfo{URL: "/API/v2/users"},
+			expectMatch: false,
+		},
+		{
+			name:        "in matches one of the values",
+			rule:        types.Rule{Type: types.RuleTypeMethod, Operator: types.MatchIn, Values: []string{"PUT", "patch", "DELETE"}},
+			req:         types.RequestInfo{Method: "PATCH"},
+			expectMatch: true,
+		},
+		{
+			name:        "in rejects other values",
+			rule:        types.Rule{Type: types.RuleTypeMethod, Operator: types.MatchIn, Values: []string{"PUT", "PATCH", "DELETE"}},
+			req:         types.RequestInfo{Method: "GET"},
+			expectMatch: false,
+		},
+		{
+			name:        "not_in",
+			rule:        types.Rule{Type: types.RuleTypeDomain, Operator: types.MatchNotIn, Values: []string{"example.com", "example.org"}},
+			req:         types.RequestInfo{Domain: "Example.org."},
+			expectMatch: false,
+		},
+		{
+			name:        "header exists",
+			rule:        types.Rule{Type: types.RuleTypeHeader, Operator: types.MatchExists, HeaderName: "X-Debug"},
+			req:         types.RequestInfo{Headers: map[string][]string{"x-debug": {""}}},
+			expectMatch: true,
+		},
+		{
+			name:        "header not_exists",
+			rule:        types.Rule{Type: types.RuleTypeHeader, Operator: types.MatchNotExists, HeaderName: "Authorization"},
+			req:         types.RequestInfo{Headers: map[string][]string{"x-debug": {"1"}}},
+			expectMatch: true,
+		},
+		{
+			name:        "header not_equals matches absent header",
+			rule:        types.Rule{Type: types.RuleTypeHeader, Operator: types.MatchNotEquals, HeaderName: "X-Env", HeaderValue: "prod"},
+			req:         types.RequestInfo{Headers: map[string][]string{}},
+			expectMatch: true,
+		},
+		{
+			name:        "header not_equals requires every value to differ",
+			rule:        types.Rule{Type: types.RuleTypeHeader, Operator: types.MatchNotEquals, HeaderName: "X-Env", HeaderValue: "prod"},
+			req:         types.RequestInfo{Headers: map[string][]string{"x-env": {"staging", "PROD"}}},
+			expectMatch: false,
+		},
+		{
+			name:        "header in",
+			rule:        types.Rule{Type: types.RuleTypeHeader, Operator: types.MatchIn, HeaderName: "X-Env", Values: []string{"dev", "staging"}},
+			req:         types.RequestInfo{Headers: map[string][]string{"x-env": {"prod", "staging"}}},
+			expectMatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.ID = "r"
+			tt.rule.Action = types.ActionBlock
+			tt.rule.Enabled = true
+			if err := ValidateRules([]types.Rule{tt.rule}); err != nil {
+				t.Fatalf("Expected valid rule, got %v", err)
+			}
+			engine := NewEngine([]types.Rule{tt.rule}, types.ActionAllow)
+
+			result := engine.EvaluateRequest(&tt.req)
+			if result.Matched != tt.expectMatch {
+				t.Errorf("Expected match: %v, got: %v (%s)", tt.expectMatch, result.Matched, result.Reason)
+			}
+		})
+	}
 }
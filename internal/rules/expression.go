@@ -0,0 +1,670 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"http-proxy/internal/ipset"
+	"http-proxy/pkg/types"
+)
+
+// Expression rules hold a boolean expression over the request in Value, e.g.
+//
+//	method == "POST" && size > 1048576 && !ip.in("10.0.0.0/8")
+//
+// Fields:
+//
+//	method, url, path, domain, user_agent  string
+//	country, as_org                        string (from the GeoIP database)
+//	size, asn                              int
+//	ip                                     client IP
+//
+// Functions and methods:
+//
+//	header(name) string             first value of a header, "" when absent
+//	has_header(name) bool           whether a header is present
+//	s.contains(x), s.starts_with(x), s.ends_with(x) bool
+//	s.matches(regex) bool           regex must be a string literal
+//	s.lower(), s.upper() string
+//	ip.in(cidr, ...) bool           CIDR ranges or addresses as string literals
+//
+// Operators are ||, &&, !, ==, != (strings, ints, bools), <, <=, >, >= (ints)
+// and "in" for membership in a list literal such as ["PUT", "DELETE"].
+// String comparisons are exact; domains are lowercased and methods uppercased
+// before comparison. Expressions are type-checked when compiled and have no
+// side effects, loops or access to anything but the request.
+
+// exprType is the static type of an expression
+type exprType int
+
+const (
+	exprBool exprType = iota
+	exprInt
+	exprString
+	exprIP
+	exprList
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprBool:
+		return "bool"
+	case exprInt:
+		return "int"
+	case exprString:
+		return "string"
+	case exprIP:
+		return "ip"
+	case exprList:
+		return "list"
+	}
+	return "unknown"
+}
+
+// maxExprDepth bounds the nesting of expressions
+const maxExprDepth = 64
+
+// exprEnv is the request an expression is evaluated against
+type exprEnv struct {
+	req *types.RequestInfo
+	geo *types.GeoInfo
+}
+
+// exprNode is a type-checked expression compiled to a closure of its type
+type exprNode struct {
+	typ exprType
+
+	boolFn   func(env *exprEnv) bool
+	intFn    func(env *exprEnv) int64
+	stringFn func(env *exprEnv) string
+	ipFn     func(env *exprEnv) net.IP
+	list     []string // list literals are constant
+
+	literal    bool   // string literal, usable as a compile-time argument
+	literalStr string // value of a string literal
+}
+
+// expression is a compiled expression rule
+type expression struct {
+	source string
+	root   exprNode
+}
+
+// compileExpression parses and type-checks an expression
+func compileExpression(source string) (*expression, error) {
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	if root.typ != exprBool {
+		return nil, fmt.Errorf("expression is %s, not bool", root.typ)
+	}
+
+	return &expression{source: source, root: root}, nil
+}
+
+// eval evaluates the expression against a request
+func (x *expression) eval(env *exprEnv) bool {
+	return x.root.boolFn(env)
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier, operator or unquoted string
+	num  int64
+	pos  int // 1-based column
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// exprOperators are the operator tokens, longest first
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")", "[", "]", ",", "."}
+
+// lexExpression splits an expression into tokens
+func lexExpression(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("column %d: unterminated string", i+1)
+			}
+			text, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid string %s", i+1, source[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i + 1})
+			i = end + 1
+
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(source) && source[end] >= '0' && source[end] <= '9' {
+				end++
+			}
+			num, err := strconv.ParseInt(source[i:end], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid number %s", i+1, source[i:end])
+			}
+			tokens = append(tokens, token{kind: tokInt, text: source[i:end], num: num, pos: i + 1})
+			i = end
+
+		case c == '_' || isAlphanumeric(c):
+			end := i
+			for end < len(source) && (source[end] == '_' || isAlphanumeric(source[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: source[i:end], pos: i + 1})
+			i = end
+
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i + 1})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("column %d: unexpected character %q", i+1, c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(source) + 1}), nil
+}
+
+// Parser
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the operator op if it is next
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return p.errorf(tok, "expected %q, got %s", op, tok)
+	}
+	return nil
+}
+
+func (p *exprParser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("column %d: %s", tok.pos, fmt.Sprintf(format, args...))
+}
+
+// parseOr parses a || b || ...
+func (p *exprParser) parseOr(depth int) (exprNode, error) {
+	if depth > maxExprDepth {
+		return exprNode{}, p.errorf(p.peek(), "expression is nested too deeply")
+	}
+
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return left, err
+	}
+	for {
+		tok := p.peek()
+		if !p.accept("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return right, err
+		}
+		if left.typ != exprBool || right.typ != exprBool {
+			return left, p.errorf(tok, "|| needs bool operands, got %s and %s", left.typ, right.typ)
+		}
+		l, r := left.boolFn, right.boolFn
+		left = exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool { return l(env) || r(env) }}
+	}
+}
+
+// parseAnd parses a && b && ...
+func (p *exprParser) parseAnd(depth int) (exprNode, error) {
+	left, err := p.parseComparison(depth)
+	if err != nil {
+		return left, err
+	}
+	for {
+		tok := p.peek()
+		if !p.accept("&&") {
+			return left, nil
+		}
+		right, err := p.parseComparison(depth)
+		if err != nil {
+			return right, err
+		}
+		if left.typ != exprBool || right.typ != exprBool {
+			return left, p.errorf(tok, "&& needs bool operands, got %s and %s", left.typ, right.typ)
+		}
+		l, r := left.boolFn, right.boolFn
+		left = exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool { return l(env) && r(env) }}
+	}
+}
+
+// parseComparison parses an optional comparison or membership test
+func (p *exprParser) parseComparison(depth int) (exprNode, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return left, err
+	}
+
+	tok := p.peek()
+	var op string
+	switch {
+	case tok.kind == tokOp && (tok.text == "==" || tok.text == "!=" || tok.text == "<" || tok.text == "<=" || tok.text == ">" || tok.text == ">="):
+		op = tok.text
+	case tok.kind == tokIdent && tok.text == "in":
+		op = "in"
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseUnary(depth)
+	if err != nil {
+		return right, err
+	}
+	return compare(p, tok, op, left, right)
+}
+
+// compare type-checks and compiles a binary comparison
+func compare(p *exprParser, tok token, op string, left, right exprNode) (exprNode, error) {
+	if op == "in" {
+		if left.typ != exprString || right.typ != exprList {
+			return left, p.errorf(tok, "in needs a string and a list, got %s and %s", left.typ, right.typ)
+		}
+		value, list := left.stringFn, right.list
+		return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool {
+			v := value(env)
+			for _, item := range list {
+				if item == v {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+
+	if left.typ != right.typ {
+		return left, p.errorf(tok, "cannot compare %s with %s", left.typ, right.typ)
+	}
+
+	var result func(env *exprEnv) bool
+	switch left.typ {
+	case exprInt:
+		l, r := left.intFn, right.intFn
+		switch op {
+		case "==":
+			result = func(env *exprEnv) bool { return l(env) == r(env) }
+		case "!=":
+			result = func(env *exprEnv) bool { return l(env) != r(env) }
+		case "<":
+			result = func(env *exprEnv) bool { return l(env) < r(env) }
+		case "<=":
+			result = func(env *exprEnv) bool { return l(env) <= r(env) }
+		case ">":
+			result = func(env *exprEnv) bool { return l(env) > r(env) }
+		case ">=":
+			result = func(env *exprEnv) bool { return l(env) >= r(env) }
+		}
+	case exprString:
+		l, r := left.stringFn, right.stringFn
+		switch op {
+		case "==":
+			result = func(env *exprEnv) bool { return l(env) == r(env) }
+		case "!=":
+			result = func(env *exprEnv) bool { return l(env) != r(env) }
+		}
+	case exprBool:
+		l, r := left.boolFn, right.boolFn
+		switch op {
+		case "==":
+			result = func(env *exprEnv) bool { return l(env) == r(env) }
+		case "!=":
+			result = func(env *exprEnv) bool { return l(env) != r(env) }
+		}
+	}
+
+	if result == nil {
+		return left, p.errorf(tok, "operator %s is not defined for %s", op, left.typ)
+	}
+	return exprNode{typ: exprBool, boolFn: result}, nil
+}
+
+// parseUnary parses !x
+func (p *exprParser) parseUnary(depth int) (exprNode, error) {
+	tok := p.peek()
+	if !p.accept("!") {
+		return p.parsePostfix(depth)
+	}
+	if depth > maxExprDepth {
+		return exprNode{}, p.errorf(tok, "expression is nested too deeply")
+	}
+
+	operand, err := p.parseUnary(depth + 1)
+	if err != nil {
+		return operand, err
+	}
+	if operand.typ != exprBool {
+		return operand, p.errorf(tok, "! needs a bool operand, got %s", operand.typ)
+	}
+	fn := operand.boolFn
+	return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool { return !fn(env) }}, nil
+}
+
+// parsePostfix parses method calls such as path.starts_with("/api")
+func (p *exprParser) parsePostfix(depth int) (exprNode, error) {
+	node, err := p.parsePrimary(depth)
+	if err != nil {
+		return node, err
+	}
+
+	for p.accept(".") {
+		name := p.next()
+		if name.kind != tokIdent {
+			return node, p.errorf(name, "expected method name, got %s", name)
+		}
+		args, err := p.parseArgs(depth)
+		if err != nil {
+			return node, err
+		}
+		if node, err = callMethod(p, name, node, args); err != nil {
+			return node, err
+		}
+	}
+	return node, nil
+}
+
+// parseArgs parses a parenthesized argument list
+func (p *exprParser) parseArgs(depth int) ([]exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parsePrimary parses literals, fields, function calls, lists and parentheses
+func (p *exprParser) parsePrimary(depth int) (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt:
+		num := tok.num
+		return exprNode{typ: exprInt, intFn: func(*exprEnv) int64 { return num }}, nil
+
+	case tokString:
+		text := tok.text
+		return exprNode{typ: exprString, stringFn: func(*exprEnv) string { return text }, literal: true, literalStr: text}, nil
+
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			value := tok.text == "true"
+			return exprNode{typ: exprBool, boolFn: func(*exprEnv) bool { return value }}, nil
+		}
+		if next := p.peek(); next.kind == tokOp && next.text == "(" {
+			args, err := p.parseArgs(depth)
+			if err != nil {
+				return exprNode{}, err
+			}
+			return callFunction(p, tok, args)
+		}
+		field, ok := exprFields[tok.text]
+		if !ok {
+			return exprNode{}, p.errorf(tok, "unknown field %q", tok.text)
+		}
+		return field, nil
+
+	case tokOp:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr(depth + 1)
+			if err != nil {
+				return node, err
+			}
+			return node, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	}
+
+	return exprNode{}, p.errorf(tok, "unexpected %s", tok)
+}
+
+// parseList parses a list of string literals after "["
+func (p *exprParser) parseList() (exprNode, error) {
+	list := []string{}
+	if p.accept("]") {
+		return exprNode{typ: exprList, list: list}, nil
+	}
+	for {
+		item := p.next()
+		if item.kind != tokString {
+			return exprNode{}, p.errorf(item, "list items must be string literals, got %s", item)
+		}
+		list = append(list, item.text)
+		if p.accept("]") {
+			return exprNode{typ: exprList, list: list}, nil
+		}
+		if err := p.expect(","); err != nil {
+			return exprNode{}, err
+		}
+	}
+}
+
+// exprFields are the request fields available to expressions
+var exprFields = map[string]exprNode{
+	"method":     stringField(func(env *exprEnv) string { return normalizeValue(types.RuleTypeMethod, env.req.Method) }),
+	"url":        stringField(func(env *exprEnv) string { return env.req.URL }),
+	"path":       stringField(func(env *exprEnv) string { return env.req.Path }),
+	"domain":     stringField(func(env *exprEnv) string { return normalizeValue(types.RuleTypeDomain, env.req.Domain) }),
+	"user_agent": stringField(func(env *exprEnv) string { return env.req.UserAgent }),
+	"country": stringField(func(env *exprEnv) string {
+		if env.geo == nil {
+			return ""
+		}
+		return env.geo.Country
+	}),
+	"as_org": stringField(func(env *exprEnv) string {
+		if env.geo == nil {
+			return ""
+		}
+		return env.geo.ASOrganization
+	}),
+	"size": {typ: exprInt, intFn: func(env *exprEnv) int64 { return env.req.Size }},
+	"asn": {typ: exprInt, intFn: func(env *exprEnv) int64 {
+		if env.geo == nil {
+			return 0
+		}
+		return int64(env.geo.ASN)
+	}},
+	"ip": {typ: exprIP, ipFn: func(env *exprEnv) net.IP { return env.req.ClientIP }},
+}
+
+func stringField(fn func(env *exprEnv) string) exprNode {
+	return exprNode{typ: exprString, stringFn: fn}
+}
+
+// callFunction compiles a call of a top-level function
+func callFunction(p *exprParser, name token, args []exprNode) (exprNode, error) {
+	switch name.text {
+	case "header", "has_header":
+		if len(args) != 1 || !args[0].literal {
+			return exprNode{}, p.errorf(name, "%s takes one string literal", name.text)
+		}
+		header := strings.ToLower(args[0].literalStr)
+		if name.text == "has_header" {
+			return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool {
+				_, exists := env.req.Headers[header]
+				return exists
+			}}, nil
+		}
+		return stringField(func(env *exprEnv) string {
+			if values := env.req.Headers[header]; len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}), nil
+	}
+	return exprNode{}, p.errorf(name, "unknown function %q", name.text)
+}
+
+// callMethod compiles a method call on a string or IP value
+func callMethod(p *exprParser, name token, receiver exprNode, args []exprNode) (exprNode, error) {
+	switch receiver.typ {
+	case exprString:
+		return callStringMethod(p, name, receiver.stringFn, args)
+	case exprIP:
+		if name.text != "in" {
+			break
+		}
+		if len(args) == 0 {
+			return exprNode{}, p.errorf(name, "in takes at least one CIDR range")
+		}
+		networks := make([]*net.IPNet, len(args))
+		for i, arg := range args {
+			if !arg.literal {
+				return exprNode{}, p.errorf(name, "in takes string literals")
+			}
+			network, err := ipset.ParseNetwork(arg.literalStr)
+			if err != nil {
+				return exprNode{}, p.errorf(name, "%v", err)
+			}
+			networks[i] = network
+		}
+		ip := receiver.ipFn
+		return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool {
+			addr := ip(env)
+			for _, network := range networks {
+				if addr != nil && network.Contains(addr) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+	return exprNode{}, p.errorf(name, "%s has no method %q", receiver.typ, name.text)
+}
+
+// callStringMethod compiles a string method call
+func callStringMethod(p *exprParser, name token, value func(env *exprEnv) string, args []exprNode) (exprNode, error) {
+	switch name.text {
+	case "lower", "upper":
+		if len(args) != 0 {
+			return exprNode{}, p.errorf(name, "%s takes no arguments", name.text)
+		}
+		transform := strings.ToLower
+		if name.text == "upper" {
+			transform = strings.ToUpper
+		}
+		return stringField(func(env *exprEnv) string { return transform(value(env)) }), nil
+
+	case "matches":
+		if len(args) != 1 || !args[0].literal {
+			return exprNode{}, p.errorf(name, "matches takes one string literal")
+		}
+		regex, err := regexp.Compile(args[0].literalStr)
+		if err != nil {
+			return exprNode{}, p.errorf(name, "invalid regex %q: %v", args[0].literalStr, err)
+		}
+		return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool { return regex.MatchString(value(env)) }}, nil
+
+	case "contains", "starts_with", "ends_with":
+		if len(args) != 1 || args[0].typ != exprString {
+			return exprNode{}, p.errorf(name, "%s takes one string", name.text)
+		}
+		test := strings.Contains
+		switch name.text {
+		case "starts_with":
+			test = strings.HasPrefix
+		case "ends_with":
+			test = strings.HasSuffix
+		}
+		arg := args[0].stringFn
+		return exprNode{typ: exprBool, boolFn: func(env *exprEnv) bool { return test(value(env), arg(env)) }}, nil
+	}
+	return exprNode{}, p.errorf(name, "string has no method %q", name.text)
+}
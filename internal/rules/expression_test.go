@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+func TestCompileExpression_Eval(t *testing.T) {
+	req := &types.RequestInfo{
+		Method:    "post",
+		URL:       "/api/v1/upload?name=a",
+		Path:      "/api/v1/upload",
+		Domain:    "Files.Example.com.",
+		UserAgent: "curl/8.0",
+		ClientIP:  net.ParseIP("203.0.113.9"),
+		Size:      2 << 20,
+		Headers:   map[string][]string{"x-api-key": {"secret"}},
+	}
+	geo := &types.GeoInfo{Country: "DE", ASN: 3320, ASOrganization: "Deutsche Telekom AG"}
+
+	tests := []struct {
+		source string
+		expect bool
+	}{
+		{`method == "POST" && size > 1048576 && !ip.in("10.0.0.0/8")`, true},
+		{`method == "POST" && size > 1048576 && !ip.in("203.0.113.0/24")`, false},
+		{`ip.in("10.0.0.0/8", "203.0.113.9")`, true},
+		{`domain == "files.example.com"`, true},
+		{`path.starts_with("/api/") && !path.ends_with(".json")`, true},
+		{`user_agent.lower().contains("curl") || false`, true},
+		{`url.matches("^/api/v[0-9]+/")`, true},
+		{`method in ["PUT", "PATCH", "DELETE"]`, false},
+		{`method in ["POST"]`, true},
+		{`header("X-Api-Key") == "secret" && has_header("x-api-key")`, true},
+		{`header("authorization") == "" && !has_header("authorization")`, true},
+		{`country == "DE" && asn == 3320 && as_org.contains("Telekom")`, true},
+		{`size <= 1024 || (size >= 1024 && size < 4096)`, false},
+		{`(true == !false) != false`, true},
+	}
+
+	for _, tt := range tests {
+		compiled, err := compileExpression(tt.source)
+		if err != nil {
+			t.Errorf("Failed to compile %q: %v", tt.source, err)
+			continue
+		}
+		if result := compiled.eval(&exprEnv{req: req, geo: geo}); result != tt.expect {
+			t.Errorf("Expected %q to be %v, got %v", tt.source, tt.expect, result)
+		}
+	}
+}
+
+func TestCompileExpression_Errors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`sise > 10`, `column 1: unknown field "sise"`},
+		{`size > "10"`, "column 6: cannot compare int with string"},
+		{`method`, "expression is string, not bool"},
+		{`method == "GET" &&`, "column 19: unexpected end of expression"},
+		{`method < "GET"`, "operator < is not defined for string"},
+		{`!size`, "! needs a bool operand, got int"},
+		{`ip.in("10.0.0.0/33")`, "invalid CIDR range"},
+		{`ip.in(method)`, "in takes string literals"},
+		{`url.matches("(")`, "invalid regex"},
+		{`path.trim()`, `string has no method "trim"`},
+		{`size.lower()`, `int has no method "lower"`},
+		{`exec("rm")`, `unknown function "exec"`},
+		{`method in "GET"`, "in needs a string and a list"},
+		{`method == "GET`, "unterminated string"},
+		{`method == 'GET'`, "unexpected character"},
+		{`(method == "GET"`, `expected ")"`},
+		{strings.Repeat("(", 100) + "true" + strings.Repeat(")", 100), "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		_, err := compileExpression(tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", tt.source, tt.expected, err)
+		}
+	}
+}
+
+func TestEngine_MatchExpression(t *testing.T) {
+	engine := NewEngine([]types.Rule{
+		{ID: "large-external-posts", Type: types.RuleTypeExpression, Value: `method == "POST" && size > 1048576 && !ip.in("10.0.0.0/8")`, Action: types.ActionBlock, Priority: 10, Enabled: true},
+	}, types.ActionAllow)
+
+	result := engine.EvaluateRequest(&types.RequestInfo{Method: "POST", Size: 2 << 20, ClientIP: net.ParseIP("192.0.2.1")})
+	if !result.Matched || result.Action != types.ActionBlock {
+		t.Errorf("Expected expression rule to block, got %+v", result)
+	}
+
+	result = engine.EvaluateRequest(&types.RequestInfo{Method: "POST", Size: 2 << 20, ClientIP: net.ParseIP("10.1.2.3")})
+	if result.Matched {
+		t.Errorf("Expected internal client not to match, got %+v", result)
+	}
+}
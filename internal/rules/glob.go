@@ -0,0 +1,143 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileGlob compiles a wildcard pattern into an anchored regular expression.
+//
+// Patterns support "*", "**", "?", character classes ("[a-z]", "[!0-9]"),
+// brace alternatives ("{jpg,png}") and backslash escapes. For paths and other
+// values "*" and "**" match any text, including "/", and "**/" at the start of
+// a segment also matches no directory at all. For domains "*" and "?" stay
+// within one label while "**" matches any text, and "**." at the start of a
+// label matches zero or more labels: "*.example.com" matches "www.example.com"
+// but not "a.b.example.com", while "**.example.com" matches both and "example.com".
+func compileGlob(pattern string, domain bool, caseSensitive bool) (*regexp.Regexp, error) {
+	expr, err := translateGlob(pattern, domain)
+	if err != nil {
+		return nil, err
+	}
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// translateGlob converts a wildcard pattern into regular expression syntax
+func translateGlob(pattern string, domain bool) (string, error) {
+	separator, star, question := byte('/'), ".*", "."
+	if domain {
+		separator, star, question = '.', `[^.]*`, `[^.]`
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	braces := 0
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				segmentStart := i == 0 || pattern[i-1] == separator
+				i++
+				if segmentStart && i+1 < len(pattern) && pattern[i+1] == separator {
+					// "**/" or "**." matches zero or more segments
+					i++
+					expr.WriteString("(?:.*" + regexp.QuoteMeta(string(separator)) + ")?")
+					continue
+				}
+				expr.WriteString(".*")
+				continue
+			}
+			expr.WriteString(star)
+		case '?':
+			expr.WriteString(question)
+		case '[':
+			end, class, err := globClass(pattern, i)
+			if err != nil {
+				return "", err
+			}
+			expr.WriteString(class)
+			i = end
+		case '{':
+			braces++
+			expr.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				expr.WriteString(`\}`)
+				continue
+			}
+			braces--
+			expr.WriteString(")")
+		case ',':
+			if braces == 0 {
+				expr.WriteString(",")
+				continue
+			}
+			expr.WriteString("|")
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", fmt.Errorf("trailing backslash in %q", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	if braces > 0 {
+		return "", fmt.Errorf("unterminated brace in %q", pattern)
+	}
+	expr.WriteString("$")
+	return expr.String(), nil
+}
+
+// globClass translates the character class starting at pattern[start] and
+// returns the index of its closing bracket
+func globClass(pattern string, start int) (int, string, error) {
+	var class strings.Builder
+	class.WriteString("[")
+
+	i := start + 1
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		class.WriteString("^")
+		i++
+	}
+	// A closing bracket right after the opening one is a literal
+	if i < len(pattern) && pattern[i] == ']' {
+		class.WriteString(`\]`)
+		i++
+	}
+
+	for ; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case ']':
+			class.WriteString("]")
+			return i, class.String(), nil
+		case '\\':
+			if i+1 == len(pattern) {
+				return 0, "", fmt.Errorf("trailing backslash in %q", pattern)
+			}
+			i++
+			if isAlphanumeric(pattern[i]) {
+				class.WriteByte(pattern[i])
+			} else {
+				class.WriteString(`\` + pattern[i:i+1])
+			}
+		case '[', '^':
+			class.WriteString(`\` + string(c))
+		default:
+			class.WriteByte(c)
+		}
+	}
+
+	return 0, "", fmt.Errorf("unterminated character class in %q", pattern)
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
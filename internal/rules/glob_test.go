@@ -0,0 +1,74 @@
+package rules
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  bool
+		value   string
+		expect  bool
+	}{
+		{"*", false, "/api/v1/users?id=1", true},
+		{"/api/*", false, "/api/v1/users", true},
+		{"/api/*", false, "/apiv1", false},
+		{"/api/*/delete", false, "/api/users/delete", true},
+		{"/api/**/users", false, "/api/users", true},
+		{"/api/**/users", false, "/api/v1/admin/users", true},
+		{"/api/**/users", false, "/api/v1users", false},
+		{"/file?.txt", false, "/file1.txt", true},
+		{"/file?.txt", false, "/file10.txt", false},
+		{"/img/*.{png,jp{e,}g}", false, "/img/cat.jpeg", true},
+		{"/img/*.{png,jp{e,}g}", false, "/img/cat.jpg", true},
+		{"/img/*.{png,jp{e,}g}", false, "/img/cat.gif", false},
+		{"/v[0-9]/*", false, "/v2/users", true},
+		{"/v[!0-9]/*", false, "/v2/users", false},
+		{"/v[]x]", false, "/v]", true},
+		{`/a\*b`, false, "/a*b", true},
+		{`/a\*b`, false, "/axb", false},
+		{"/a.b", false, "/axb", false},
+		{"/{a,b}}", false, "/a}", true},
+
+		{"*.example.com", true, "www.example.com", true},
+		{"*.example.com", true, "a.b.example.com", false},
+		{"*.example.com", true, "example.com", false},
+		{"**.example.com", true, "a.b.example.com", true},
+		{"**.example.com", true, "example.com", true},
+		{"**.example.com", true, "badexample.com", false},
+		{"api-?.example.com", true, "api-1.example.com", true},
+		{"api-?.example.com", true, "api-..example.com", false},
+		{"*.example.{com,org}", true, "www.example.org", true},
+		{"*", true, "example", true},
+		{"*", true, "www.example.com", false},
+		{"**", true, "www.example.com", true},
+	}
+
+	for _, tt := range tests {
+		glob, err := compileGlob(tt.pattern, tt.domain, true)
+		if err != nil {
+			t.Errorf("Failed to compile %q: %v", tt.pattern, err)
+			continue
+		}
+		if matched := glob.MatchString(tt.value); matched != tt.expect {
+			t.Errorf("Expected %q (domain %v) matching %q to be %v, got %v", tt.pattern, tt.domain, tt.value, tt.expect, matched)
+		}
+	}
+}
+
+func TestCompileGlob_CaseInsensitive(t *testing.T) {
+	glob, err := compileGlob("/Static/*.CSS", false, false)
+	if err != nil {
+		t.Fatalf("Failed to compile pattern: %v", err)
+	}
+	if !glob.MatchString("/static/site.css") {
+		t.Error("Expected case-insensitive glob to match")
+	}
+}
+
+func TestCompileGlob_Invalid(t *testing.T) {
+	for _, pattern := range []string{"[a-z", "/{a,b", `/a\`, "[z-a]"} {
+		if _, err := compileGlob(pattern, false, true); err == nil {
+			t.Errorf("Expected %q to be rejected", pattern)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+// DefaultHistorySize is the number of ruleset versions kept when not configured
+const DefaultHistorySize = 20
+
+// ErrVersionNotFound is returned for ruleset versions not in the history
+var ErrVersionNotFound = errors.New("ruleset version not found")
+
+// ruleSetSnapshot is a recorded version together with its rules
+type ruleSetSnapshot struct {
+	info  types.RuleSetVersion
+	rules []types.Rule
+}
+
+// ruleHistory keeps the most recent versions of the ruleset, oldest first
+type ruleHistory struct {
+	size     int
+	versions []ruleSetSnapshot
+}
+
+func newRuleHistory(size int) *ruleHistory {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &ruleHistory{size: size}
+}
+
+// record adds rules as a new version unless they equal the current version
+func (h *ruleHistory) record(rules []types.Rule, source types.RuleSetSource, now time.Time) (types.RuleSetVersion, bool) {
+	hash := hashRules(rules)
+	current, exists := h.current()
+	if exists && current.Hash == hash {
+		return current, false
+	}
+
+	info := types.RuleSetVersion{
+		Version:   current.Version + 1,
+		Timestamp: now,
+		Source:    source,
+		Hash:      hash,
+		RuleCount: len(rules),
+	}
+	h.versions = append(h.versions, ruleSetSnapshot{info: info, rules: rules})
+	if len(h.versions) > h.size {
+		h.versions = append([]ruleSetSnapshot(nil), h.versions[len(h.versions)-h.size:]...)
+	}
+	return info, true
+}
+
+// current returns the latest version
+func (h *ruleHistory) current() (types.RuleSetVersion, bool) {
+	if len(h.versions) == 0 {
+		return types.RuleSetVersion{}, false
+	}
+	return h.versions[len(h.versions)-1].info, true
+}
+
+// get returns a version still in the history
+func (h *ruleHistory) get(version int) (ruleSetSnapshot, bool) {
+	for _, snapshot := range h.versions {
+		if snapshot.info.Version == version {
+			return snapshot, true
+		}
+	}
+	return ruleSetSnapshot{}, false
+}
+
+// list returns all versions in the history, oldest first
+func (h *ruleHistory) list() []types.RuleSetVersion {
+	versions := make([]types.RuleSetVersion, len(h.versions))
+	for i, snapshot := range h.versions {
+		versions[i] = snapshot.info
+	}
+	return versions
+}
+
+// hashRules returns the SHA-256 of the rules ordered by ID, so that rules with
+// equal priority hash the same whatever order they were loaded in
+func hashRules(rules []types.Rule) string {
+	sorted := make([]types.Rule, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	data, _ := json.Marshal(sorted)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sameRule reports whether two rules serialize identically, so that nil and
+// empty fields from different file formats compare equal
+func sameRule(a, b types.Rule) bool {
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	return bytes.Equal(dataA, dataB)
+}
+
+// diffRules compares two rulesets by rule ID
+func diffRules(from, to []types.Rule) *types.RuleSetDiff {
+	diff := &types.RuleSetDiff{Added: []types.Rule{}, Removed: []types.Rule{}, Changed: []types.RuleChange{}}
+
+	before := make(map[string]types.Rule, len(from))
+	for _, rule := range from {
+		before[rule.ID] = rule
+	}
+	after := make(map[string]bool, len(to))
+	for _, rule := range to {
+		after[rule.ID] = true
+		old, exists := before[rule.ID]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, rule)
+		case !sameRule(old, rule):
+			diff.Changed = append(diff.Changed, types.RuleChange{ID: rule.ID, Before: old, After: rule})
+		}
+	}
+	for _, rule := range from {
+		if !after[rule.ID] {
+			diff.Removed = append(diff.Removed, rule)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].ID < diff.Added[j].ID })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].ID < diff.Removed[j].ID })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].ID < diff.Changed[j].ID })
+	return diff
+}
@@ -0,0 +1,284 @@
+package rules
+
+import (
+	"math/bits"
+	"net"
+	"strings"
+
+	"http-proxy/internal/ipset"
+	"http-proxy/pkg/types"
+)
+
+// ruleIndex narrows the rules that can match a request so evaluation does
+// not have to walk every rule. Rules are identified by their position in the
+// priority-sorted rule list; the index only yields candidates, which are then
+// verified in priority order, so first-match semantics are unchanged.
+type ruleIndex struct {
+	size int
+
+	// linear marks enabled rules that cannot be indexed and are always candidates
+	linear bitset
+
+	fields map[types.RuleType]*fieldIndex
+	ips    *ipset.Tree // CIDR and exact IP rules; values are []int positions
+}
+
+// fieldIndex indexes the string operators of one request field. Keys and
+// request values are normalized and folded to lower case, so case-sensitive
+// rules are over-selected and rejected when they are verified.
+type fieldIndex struct {
+	ruleType types.RuleType
+	equals   map[string][]int
+	prefix   *trie
+	suffix   *trie
+	contains *ahoCorasick
+}
+
+// bitset is a fixed-size set of rule positions
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+func (b bitset) set(pos int) {
+	b[pos>>6] |= 1 << uint(pos&63)
+}
+
+// forEach calls fn for each set position in ascending order until fn returns false
+func (b bitset) forEach(fn func(pos int) bool) {
+	for word, value := range b {
+		for value != 0 {
+			pos := word<<6 + bits.TrailingZeros64(value)
+			if !fn(pos) {
+				return
+			}
+			value &= value - 1
+		}
+	}
+}
+
+// buildIndex indexes the enabled rules of a priority-sorted rule list
+func buildIndex(rules []types.Rule) *ruleIndex {
+	index := &ruleIndex{
+		size:   len(rules),
+		linear: newBitset(len(rules)),
+		fields: make(map[types.RuleType]*fieldIndex),
+		ips:    ipset.NewTree(),
+	}
+
+	ipRules := make(map[string][]int)
+	var ipNetworks []*net.IPNet
+
+	for pos, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		switch {
+		case isIndexableIPRule(rule):
+			network, err := ipRuleNetwork(rule)
+			if err != nil {
+				index.linear.set(pos)
+				continue
+			}
+			key := network.String()
+			if _, exists := ipRules[key]; !exists {
+				ipNetworks = append(ipNetworks, network)
+			}
+			ipRules[key] = append(ipRules[key], pos)
+		case isIndexableStringRule(rule):
+			index.field(rule.Type).add(rule, pos)
+		default:
+			index.linear.set(pos)
+		}
+	}
+
+	for _, network := range ipNetworks {
+		index.ips.Insert(network, ipRules[network.String()])
+	}
+	for _, field := range index.fields {
+		field.contains.build()
+	}
+
+	return index
+}
+
+// field returns the index for a rule type, creating it if needed
+func (idx *ruleIndex) field(ruleType types.RuleType) *fieldIndex {
+	field, exists := idx.fields[ruleType]
+	if !exists {
+		field = &fieldIndex{
+			ruleType: ruleType,
+			equals:   make(map[string][]int),
+			prefix:   newTrie(),
+			suffix:   newTrie(),
+			contains: newAhoCorasick(),
+		}
+		idx.fields[ruleType] = field
+	}
+	return field
+}
+
+// add indexes a string rule under its operator
+func (f *fieldIndex) add(rule types.Rule, pos int) {
+	key := f.key(rule.Value)
+	switch {
+	case rule.Operator == types.MatchEquals && rule.Type == types.RuleTypeURISuffix:
+		// uri_suffix "equals" is a suffix match
+		f.suffix.add(reverse(key), pos)
+	case rule.Operator == types.MatchEquals:
+		f.equals[key] = append(f.equals[key], pos)
+	case rule.Operator == types.MatchStartsWith:
+		f.prefix.add(key, pos)
+	case rule.Operator == types.MatchEndsWith:
+		f.suffix.add(reverse(key), pos)
+	case rule.Operator == types.MatchContains:
+		f.contains.add(key, pos)
+	case rule.Operator == types.MatchIn:
+		for _, value := range rule.Values {
+			key := f.key(value)
+			f.equals[key] = append(f.equals[key], pos)
+		}
+	}
+}
+
+// key normalizes and folds a rule value or request value
+func (f *fieldIndex) key(value string) string {
+	return strings.ToLower(normalizeValue(f.ruleType, value))
+}
+
+// collect marks the rules of this field that may match value
+func (f *fieldIndex) collect(value string, candidates bitset) {
+	key := f.key(value)
+	for _, pos := range f.equals[key] {
+		candidates.set(pos)
+	}
+	f.prefix.walk(key, candidates.set)
+	f.suffix.walk(reverse(key), candidates.set)
+	f.contains.search(key, candidates.set)
+}
+
+// candidates returns the positions of all rules that may match req
+func (idx *ruleIndex) candidates(req *types.RequestInfo) bitset {
+	candidates := make(bitset, len(idx.linear))
+	copy(candidates, idx.linear)
+
+	for ruleType, field := range idx.fields {
+		field.collect(requestField(ruleType, req), candidates)
+	}
+
+	if req.ClientIP != nil && idx.ips.Len() > 0 {
+		idx.ips.Walk(req.ClientIP, func(network *net.IPNet, value interface{}) bool {
+			for _, pos := range value.([]int) {
+				candidates.set(pos)
+			}
+			return true
+		})
+	}
+
+	return candidates
+}
+
+// isIndexableStringRule reports whether a rule matches a single string field
+// with an operator the index supports
+func isIndexableStringRule(rule types.Rule) bool {
+	switch rule.Type {
+	case types.RuleTypeURL, types.RuleTypeDomain, types.RuleTypeUserAgent, types.RuleTypeMethod:
+		switch rule.Operator {
+		case types.MatchEquals, types.MatchStartsWith, types.MatchEndsWith, types.MatchContains, types.MatchIn:
+			return true
+		}
+	case types.RuleTypeURISuffix:
+		return rule.Operator == types.MatchEquals
+	}
+	return false
+}
+
+// isIndexableIPRule reports whether a rule is an exact IP or CIDR rule
+func isIndexableIPRule(rule types.Rule) bool {
+	if rule.Type != types.RuleTypeIPv4 && rule.Type != types.RuleTypeIPv6 {
+		return false
+	}
+	return rule.Operator == types.MatchEquals || rule.Operator == types.MatchInRange
+}
+
+// ipRuleNetwork returns the prefix an IP rule matches
+func ipRuleNetwork(rule types.Rule) (*net.IPNet, error) {
+	if rule.Operator == types.MatchInRange {
+		_, network, err := net.ParseCIDR(rule.Value)
+		return network, err
+	}
+	return ipset.ParseNetwork(rule.Value)
+}
+
+// requestField returns the request field matched by a string rule type
+func requestField(ruleType types.RuleType, req *types.RequestInfo) string {
+	switch ruleType {
+	case types.RuleTypeURL:
+		return req.URL
+	case types.RuleTypeDomain:
+		return req.Domain
+	case types.RuleTypeUserAgent:
+		return req.UserAgent
+	case types.RuleTypeMethod:
+		return req.Method
+	case types.RuleTypeURISuffix:
+		return req.Path
+	}
+	return ""
+}
+
+// trie maps string prefixes to rule positions
+type trie struct {
+	children map[byte]*trie
+	rules    []int
+}
+
+func newTrie() *trie {
+	return &trie{}
+}
+
+// add registers key for rule position pos
+func (t *trie) add(key string, pos int) {
+	node := t
+	for i := 0; i < len(key); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*trie)
+		}
+		child, exists := node.children[key[i]]
+		if !exists {
+			child = newTrie()
+			node.children[key[i]] = child
+		}
+		node = child
+	}
+	node.rules = append(node.rules, pos)
+}
+
+// walk calls emit for the rules of every key that is a prefix of value
+func (t *trie) walk(value string, emit func(pos int)) {
+	node := t
+	for i := 0; ; i++ {
+		for _, pos := range node.rules {
+			emit(pos)
+		}
+		if i == len(value) || node.children == nil {
+			return
+		}
+		next, exists := node.children[value[i]]
+		if !exists {
+			return
+		}
+		node = next
+	}
+}
+
+// reverse reverses the bytes of s
+func reverse(s string) string {
+	reversed := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		reversed[len(s)-1-i] = s[i]
+	}
+	return string(reversed)
+}
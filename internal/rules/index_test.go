@@ -0,0 +1,236 @@
+package rules
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+// evaluateLinear is the reference first-match evaluation that walks every rule
+func evaluateLinear(e *Engine, req *types.RequestInfo) *types.RuleResult {
+	for _, rule := range e.rules {
+		if !rule.Enabled {
+			continue
+		}
+		if matched, reason := e.matchRule(&rule, req); matched {
+			return &types.RuleResult{Rule: &rule, Matched: true, Action: rule.Action, Reason: reason}
+		}
+	}
+	return &types.RuleResult{Action: e.defaultAction, Reason: "no rules matched, using default action"}
+}
+
+func TestAhoCorasick_Search(t *testing.T) {
+	ac := newAhoCorasick()
+	patterns := []string{"he", "she", "his", "hers", "admin", ""}
+	for pos, pattern := range patterns {
+		ac.add(pattern, pos)
+	}
+	ac.build()
+
+	tests := []struct {
+		text     string
+		expected []int
+	}{
+		{"ushers", []int{0, 1, 3, 5}},
+		{"/admin/his", []int{2, 4, 5}},
+		{"nothing", []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			found := map[int]bool{}
+			ac.search(tt.text, func(pos int) { found[pos] = true })
+
+			var got []int
+			for pos := range found {
+				got = append(got, pos)
+			}
+			sort.Ints(got)
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected patterns %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTrie_Walk(t *testing.T) {
+	tr := newTrie()
+	tr.add("/api", 0)
+	tr.add("/api/v1", 1)
+	tr.add("/admin", 2)
+	tr.add("", 3)
+
+	var got []int
+	tr.walk("/api/v1/users", func(pos int) { got = append(got, pos) })
+
+	if fmt.Sprint(got) != fmt.Sprint([]int{3, 0, 1}) {
+		t.Errorf("Expected prefixes [3 0 1], got %v", got)
+	}
+}
+
+// randomRules generates a mix of indexable and non-indexable rules
+func randomRules(rng *rand.Rand, count int) []types.Rule {
+	words := []string{"admin", "api", "login", "bot", "curl", "example", "internal", "v1", "users", "static"}
+	word := func() string { return words[rng.Intn(len(words))] }
+
+	var rules []types.Rule
+	for i := 0; i < count; i++ {
+		rule := types.Rule{
+			ID:       fmt.Sprintf("rule-%d", i),
+			Priority: rng.Intn(count),
+			Enabled:  rng.Intn(10) > 0,
+			Action:   []types.Action{types.ActionAllow, types.ActionBlock}[rng.Intn(2)],
+		}
+
+		switch rng.Intn(8) {
+		case 0:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURL, types.MatchStartsWith, "/"+word()
+		case 1:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURL, types.MatchContains, word()
+		case 2:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeDomain, types.MatchEndsWith, "."+word()+".com"
+		case 3:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeUserAgent, types.MatchContains, word()
+		case 4:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeMethod, types.MatchEquals, []string{"GET", "POST", "DELETE"}[rng.Intn(3)]
+		case 5:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeIPv4, types.MatchInRange, fmt.Sprintf("10.%d.0.0/16", rng.Intn(4))
+		case 6:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURISuffix, types.MatchEquals, "."+word()
+		case 7:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURL, types.MatchRegex, "^/"+word()+"/[0-9]+"
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// randomRequest generates requests that hit the rules of randomRules
+func randomRequest(rng *rand.Rand) *types.RequestInfo {
+	words := []string{"admin", "API", "login", "Bot", "curl", "example", "internal", "v1", "users", "static", "other"}
+	word := func() string { return words[rng.Intn(len(words))] }
+	path := "/" + word() + "/" + fmt.Sprint(rng.Intn(100)) + "." + word()
+
+	return &types.RequestInfo{
+		Method:    []string{"GET", "POST", "DELETE", "PUT"}[rng.Intn(4)],
+		URL:       path,
+		Path:      path,
+		Domain:    word() + "." + word() + ".com",
+		UserAgent: word() + "/1.0",
+		ClientIP:  net.IPv4(10, byte(rng.Intn(6)), 1, 1),
+	}
+}
+
+func TestEngine_IndexMatchesLinearEvaluation(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for round := 0; round < 20; round++ {
+		engine := NewEngine(randomRules(rng, 200), types.ActionAllow)
+
+		for i := 0; i < 200; i++ {
+			req := randomRequest(rng)
+			indexed := engine.EvaluateRequest(req)
+			linear := evaluateLinear(engine, req)
+
+			if indexed.Action != linear.Action || indexed.Matched != linear.Matched {
+				t.Fatalf("Indexed result %+v differs from linear result %+v for %+v", indexed, linear, req)
+			}
+			if indexed.Rule != nil && indexed.Rule.ID != linear.Rule.ID {
+				t.Fatalf("Indexed rule %s differs from linear rule %s for %+v", indexed.Rule.ID, linear.Rule.ID, req)
+			}
+		}
+	}
+}
+
+func TestEngine_IndexRebuiltOnChanges(t *testing.T) {
+	engine := NewEngine([]types.Rule{}, types.ActionAllow)
+	req := &types.RequestInfo{URL: "/admin"}
+
+	engine.AddRule(types.Rule{
+		ID: "block-admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith,
+		Value: "/admin", Action: types.ActionBlock, Priority: 10, Enabled: true,
+	})
+	if result := engine.EvaluateRequest(req); result.Action != types.ActionBlock {
+		t.Errorf("Expected added rule to match")
+	}
+
+	engine.DisableRule("block-admin")
+	if result := engine.EvaluateRequest(req); result.Matched {
+		t.Errorf("Expected disabled rule not to match")
+	}
+
+	engine.EnableRule("block-admin")
+	if result := engine.EvaluateRequest(req); !result.Matched {
+		t.Errorf("Expected re-enabled rule to match")
+	}
+
+	engine.RemoveRule("block-admin")
+	if result := engine.EvaluateRequest(req); result.Matched {
+		t.Errorf("Expected removed rule not to match")
+	}
+}
+
+// scaleRules generates count rules with distinct values, as in large
+// per-tenant rule sets where any request matches at most a few rules
+func scaleRules(count int) []types.Rule {
+	var rules []types.Rule
+	for i := 0; i < count; i++ {
+		rule := types.Rule{
+			ID:       fmt.Sprintf("rule-%d", i),
+			Priority: i,
+			Enabled:  true,
+			Action:   types.ActionBlock,
+		}
+
+		switch i % 5 {
+		case 0:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURL, types.MatchStartsWith, fmt.Sprintf("/svc-%d/", i)
+		case 1:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeDomain, types.MatchEndsWith, fmt.Sprintf(".tenant-%d.example.com", i)
+		case 2:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeUserAgent, types.MatchContains, fmt.Sprintf("scanner-%d", i)
+		case 3:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeIPv4, types.MatchInRange, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+		case 4:
+			rule.Type, rule.Operator, rule.Value = types.RuleTypeURL, types.MatchEquals, fmt.Sprintf("/internal/%d", i)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func benchmarkEngine(b *testing.B, ruleCount int, evaluate func(*Engine, *types.RequestInfo) *types.RuleResult) {
+	engine := NewEngine(scaleRules(ruleCount), types.ActionAllow)
+
+	// One request matching the last rule and one matching nothing
+	requests := []*types.RequestInfo{
+		{URL: fmt.Sprintf("/internal/%d", ruleCount-1), Domain: "www.example.com", UserAgent: "Mozilla/5.0", ClientIP: net.ParseIP("192.0.2.1")},
+		{URL: "/public/index.html", Domain: "www.example.com", UserAgent: "Mozilla/5.0", ClientIP: net.ParseIP("192.0.2.1")},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		evaluate(engine, requests[i%len(requests)])
+	}
+}
+
+func BenchmarkEngine_EvaluateRequest_Linear(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			benchmarkEngine(b, count, evaluateLinear)
+		})
+	}
+}
+
+func BenchmarkEngine_EvaluateRequest_Indexed(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			benchmarkEngine(b, count, (*Engine).EvaluateRequest)
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package rules
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +13,10 @@ import (
 	"sync"
 	"time"
 
+	"http-proxy/internal/fileutil"
+	"http-proxy/internal/filewatch"
+	"http-proxy/internal/geoip"
+	"http-proxy/internal/ipset"
 	"http-proxy/pkg/types"
 
 	"github.com/BurntSushi/toml"
@@ -21,26 +28,79 @@ type Manager struct {
 	mu           sync.RWMutex
 	engine       *Engine
 	rulesFile    string
+	rulesDir     string
 	watchEnabled bool
-	stopWatch    chan bool
-	reloadTicker *time.Ticker
-	lastModTime  time.Time
+	watcher      *filewatch.Watcher
+	dirWatcher   *filewatch.Watcher
+	ipSets       *ipset.Registry
+	geoIP        *geoip.Database
+
+	// fileMu serializes reads and writes of the rules file. The file is only
+	// reloaded when its content hash differs from the last one loaded or
+	// written, which also makes the watcher skip our own writes.
+	fileMu      sync.Mutex
+	lastDigest  string
+	fileBackups int
+
+	// The active rules are the base rules, from the config, the rules file or
+	// the API, followed by the rules of every file in the rules directory.
+	// baseRules and dirRuleIDs are guarded by mu, ruleFiles by fileMu.
+	baseRules  []types.Rule
+	dirRuleIDs map[string]bool
+	ruleFiles  map[string]*ruleFile
+
+	// configRules are the config's rules as last applied, so that config
+	// reloads only replace the base rules when the list changed. Guarded by fileMu.
+	configRules []types.Rule
+
+	disableExpired bool
+	stopExpiry     chan bool
+	expiryTicker   *time.Ticker
+
+	history *ruleHistory
 }
 
 // NewManager creates a new rules manager
 func NewManager(config *types.RulesConfig) (*Manager, error) {
 	manager := &Manager{
 		rulesFile:    config.RulesFile,
+		rulesDir:     config.RulesDir,
 		watchEnabled: config.WatchRulesFile,
-		stopWatch:    make(chan bool, 1),
+		ruleFiles:    make(map[string]*ruleFile),
+
+		disableExpired: config.ExpiredRuleAction == types.ExpiredRuleDisable,
+		stopExpiry:     make(chan bool, 1),
+
+		history:     newRuleHistory(config.HistorySize),
+		fileBackups: config.FileBackups,
+		configRules: append([]types.Rule{}, config.Rules...),
+	}
+	if manager.fileBackups == 0 {
+		manager.fileBackups = fileutil.DefaultBackups
 	}
 
 	// Initialize engine with rules from config
 	manager.engine = NewEngine(config.Rules, config.DefaultAction)
+	manager.engine.SetBodyInspection(config.BodyInspection)
+	manager.engine.SetShadowMode(config.ShadowMode)
+	manager.engine.SetLegacyCaseMatching(config.LegacyCaseMatching)
+	manager.engineChanged(types.RuleSetSourceConfig)
+
+	// Load named IP sets; they are watched independently of the rules file
+	if len(config.IPSets) > 0 {
+		registry, err := ipset.NewRegistry(config.IPSets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load IP sets: %w", err)
+		}
+		registry.StartFileWatcher(config.ReloadInterval)
+		manager.ipSets = registry
+		manager.engine.SetIPSets(registry)
+	}
 
 	// If rules file is specified, load rules from file
 	if manager.rulesFile != "" {
 		if err := manager.loadRulesFromFile(); err != nil {
+			manager.Close()
 			return nil, fmt.Errorf("failed to load rules from file: %w", err)
 		}
 
@@ -50,9 +110,47 @@ func NewManager(config *types.RulesConfig) (*Manager, error) {
 		}
 	}
 
+	// Files of the rules directory that fail to load are logged and skipped
+	if manager.rulesDir != "" {
+		if err := manager.loadRulesDir(); err != nil {
+			manager.Close()
+			return nil, fmt.Errorf("failed to load rules directory: %w", err)
+		}
+		if manager.watchEnabled {
+			manager.startDirWatcher(config.ReloadInterval)
+		}
+	}
+
+	manager.startExpiryChecker(config.ExpiryCheckInterval)
+
 	return manager, nil
 }
 
+// LoadGeoIP opens the GeoIP databases of config and resolves geo_country
+// and asn rules with them, replacing any databases loaded before. Without
+// databases it does nothing.
+func (rm *Manager) LoadGeoIP(config *types.GeoIPConfig) error {
+	if config.CountryDatabase == "" && config.ASNDatabase == "" {
+		return nil
+	}
+
+	db, err := geoip.NewDatabase(config)
+	if err != nil {
+		return fmt.Errorf("failed to load GeoIP databases: %w", err)
+	}
+
+	rm.mu.Lock()
+	previous := rm.geoIP
+	rm.geoIP = db
+	rm.engine.SetGeoResolver(db)
+	rm.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
 // GetEngine returns the rules engine
 func (rm *Manager) GetEngine() *Engine {
 	rm.mu.RLock()
@@ -60,11 +158,12 @@ func (rm *Manager) GetEngine() *Engine {
 	return rm.engine
 }
 
-// UpdateRules updates the rules in the engine
-func (rm *Manager) UpdateRules(rules []types.Rule) {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
-	rm.engine.UpdateRules(rules)
+// UpdateRules replaces the base rules; the rules directory is merged in as
+// usual. An invalid list is rejected as a whole.
+func (rm *Manager) UpdateRules(rules []types.Rule) error {
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+	return rm.applyRules(append([]types.Rule{}, rules...), types.RuleSetSourceAPI)
 }
 
 // LoadRulesFromFile loads rules from the configured file
@@ -73,46 +172,68 @@ func (rm *Manager) loadRulesFromFile() error {
 		return fmt.Errorf("no rules file configured")
 	}
 
-	fileInfo, err := os.Stat(rm.rulesFile)
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+
+	data, err := os.ReadFile(rm.rulesFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Rules file %s does not exist, using existing rules", rm.rulesFile)
 			return nil
 		}
-		return fmt.Errorf("failed to stat rules file: %w", err)
+		return fmt.Errorf("failed to read rules file: %w", err)
 	}
 
-	// Check if file has been modified
-	if !fileInfo.ModTime().After(rm.lastModTime) {
-		return nil // File hasn't been modified
+	// Skip files whose content hasn't changed
+	digest := contentDigest(data)
+	if digest == rm.lastDigest {
+		return nil
 	}
 
-	data, err := os.ReadFile(rm.rulesFile)
+	rules, err := ParseRulesFile(data, rm.rulesFile)
 	if err != nil {
-		return fmt.Errorf("failed to read rules file: %w", err)
+		return fmt.Errorf("failed to parse rules file: %w", err)
 	}
 
-	rules, err := rm.parseRulesFile(data, rm.rulesFile)
-	if err != nil {
-		return fmt.Errorf("failed to parse rules file: %w", err)
+	if rules == nil {
+		rules = []types.Rule{} // a file without rules clears them
 	}
 
-	rm.mu.Lock()
-	rm.engine.UpdateRules(rules)
-	rm.lastModTime = fileInfo.ModTime()
-	rm.mu.Unlock()
+	// Reject the whole file if any rule is invalid, keeping the current rules.
+	// The file's rules come first in the merged list, so indexes match.
+	if err := rm.applyRules(rules, types.RuleSetSourceFile); err != nil {
+		var errs ValidationErrors
+		if errors.As(err, &errs) {
+			return fmt.Errorf("invalid rules: %w", errs.WithPositions(rm.rulesFile, RuleLines(data, rm.rulesFile, "rules")))
+		}
+		return fmt.Errorf("failed to apply rules: %w", err)
+	}
+	rm.lastDigest = digest
 
 	log.Printf("Loaded %d rules from %s", len(rules), rm.rulesFile)
 	return nil
 }
 
-// parseRulesFile parses rules from file data based on file extension
-func (rm *Manager) parseRulesFile(data []byte, filename string) ([]types.Rule, error) {
+// ParseRulesFile parses rules from file data based on file extension
+func ParseRulesFile(data []byte, filename string) ([]types.Rule, error) {
+	document, err := parseRulesDocument(data, filename)
+	if err != nil {
+		return nil, err
+	}
+	return document.Rules, nil
+}
+
+// rulesDocument is the content of a rules file
+type rulesDocument struct {
+	Namespace string       `yaml:"namespace" json:"namespace" toml:"namespace"` // used in the rules directory
+	Rules     []types.Rule `yaml:"rules" json:"rules" toml:"rules"`
+}
+
+// parseRulesDocument parses a rules file based on its extension
+func parseRulesDocument(data []byte, filename string) (*rulesDocument, error) {
 	ext := strings.ToLower(filepath.Ext(filename))
 
-	var rulesWrapper struct {
-		Rules []types.Rule `yaml:"rules" json:"rules" toml:"rules"`
-	}
+	var rulesWrapper rulesDocument
 
 	switch ext {
 	case ".yaml", ".yml":
@@ -131,7 +252,7 @@ func (rm *Manager) parseRulesFile(data []byte, filename string) ([]types.Rule, e
 		return nil, fmt.Errorf("unsupported rules file format: %s", ext)
 	}
 
-	return rulesWrapper.Rules, nil
+	return &rulesWrapper, nil
 }
 
 // SaveRulesToFile saves current rules to the configured file
@@ -140,8 +261,9 @@ func (rm *Manager) SaveRulesToFile() error {
 		return fmt.Errorf("no rules file configured")
 	}
 
+	// Rules of the rules directory are saved in their own files
 	rm.mu.RLock()
-	rules := rm.engine.GetRules()
+	rules := append([]types.Rule(nil), rm.baseRules...)
 	rm.mu.RUnlock()
 
 	rulesWrapper := struct {
@@ -169,51 +291,155 @@ func (rm *Manager) SaveRulesToFile() error {
 		return fmt.Errorf("failed to marshal rules: %w", err)
 	}
 
-	if err := os.WriteFile(rm.rulesFile, data, 0644); err != nil {
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+
+	if err := fileutil.WriteFileWithBackup(rm.rulesFile, data, 0644, rm.fileBackups); err != nil {
 		return fmt.Errorf("failed to write rules file: %w", err)
 	}
 
+	// Our own write is not a change for the watcher to reload
+	rm.lastDigest = contentDigest(data)
+
 	log.Printf("Saved %d rules to %s", len(rules), rm.rulesFile)
 	return nil
 }
 
-// startFileWatcher starts watching the rules file for changes
+// contentDigest returns the SHA-256 of file content
+func contentDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// startFileWatcher starts watching the rules file for changes. Changes are
+// picked up through inotify where available; interval is the polling
+// interval used otherwise.
 func (rm *Manager) startFileWatcher(interval time.Duration) {
-	rm.reloadTicker = time.NewTicker(interval)
+	rm.watcher = filewatch.New(rm.rulesFile, interval, filewatch.DefaultDebounce, func() {
+		if err := rm.loadRulesFromFile(); err != nil {
+			log.Printf("Error reloading rules from file: %v", err)
+		}
+	})
+
+	if rm.watcher.Polling() {
+		log.Printf("Started file watcher for rules file: %s (polling every %v)", rm.rulesFile, interval)
+	} else {
+		log.Printf("Started file watcher for rules file: %s", rm.rulesFile)
+	}
+}
+
+// startDirWatcher starts watching the rules directory for changes
+func (rm *Manager) startDirWatcher(interval time.Duration) {
+	dir := rulesDirWatchPath(rm.rulesDir)
+	rm.dirWatcher = filewatch.NewDir(dir, interval, filewatch.DefaultDebounce, func() {
+		if err := rm.loadRulesDir(); err != nil {
+			log.Printf("Error reloading rules directory: %v", err)
+		}
+	})
+	log.Printf("Started file watcher for rules directory: %s", rm.rulesDir)
+}
+
+// StopFileWatcher stops the file watchers
+func (rm *Manager) StopFileWatcher() {
+	if rm.watcher != nil {
+		rm.watcher.Close()
+	}
+	if rm.dirWatcher != nil {
+		rm.dirWatcher.Close()
+	}
+}
+
+// startExpiryChecker periodically drops or disables rules whose expiry has passed
+func (rm *Manager) startExpiryChecker(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	rm.expiryTicker = time.NewTicker(interval)
 
 	go func() {
 		for {
 			select {
-			case <-rm.reloadTicker.C:
-				if err := rm.loadRulesFromFile(); err != nil {
-					log.Printf("Error reloading rules from file: %v", err)
-				}
-			case <-rm.stopWatch:
-				rm.reloadTicker.Stop()
+			case <-rm.expiryTicker.C:
+				rm.ExpireRules(time.Now())
+			case <-rm.stopExpiry:
+				rm.expiryTicker.Stop()
 				return
 			}
 		}
 	}()
+}
+
+// ExpireRules drops, or disables if so configured, all rules expired at now
+func (rm *Manager) ExpireRules(now time.Time) []types.Rule {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
-	log.Printf("Started file watcher for rules file: %s (interval: %v)", rm.rulesFile, interval)
+	expired := rm.engine.ExpireRules(now, rm.disableExpired)
+	for _, rule := range expired {
+		if rm.disableExpired {
+			log.Printf("Rule %s expired at %s, disabled", rule.ID, rule.ExpiresAt.Format(time.RFC3339))
+		} else {
+			log.Printf("Rule %s expired at %s, removed", rule.ID, rule.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+	if len(expired) > 0 {
+		rm.engineChanged(types.RuleSetSourceExpiry)
+	}
+	return expired
 }
 
-// StopFileWatcher stops the file watcher
-func (rm *Manager) StopFileWatcher() {
-	if rm.reloadTicker != nil {
-		select {
-		case rm.stopWatch <- true:
-		default:
+// ApplyConfig applies the runtime-changeable settings of a new rules config:
+// the default action, shadow mode, case matching, body inspection and expiry
+// action. Unless rules come from a rules file, a changed rules list replaces
+// the base rules, including rules added through the API; the rules directory
+// is merged in as usual. An unchanged list leaves the rules alone. Rule
+// sources, such as the rules file and directory, only change on restart.
+func (rm *Manager) ApplyConfig(config *types.RulesConfig) error {
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+
+	// Apply the rules first, so that an invalid list leaves the settings alone
+	if rm.rulesFile == "" && hashRules(config.Rules) != hashRules(rm.configRules) {
+		if err := rm.applyRules(append([]types.Rule{}, config.Rules...), types.RuleSetSourceConfig); err != nil {
+			return err
 		}
+		rm.configRules = append([]types.Rule{}, config.Rules...)
 	}
+
+	rm.mu.Lock()
+	rm.engine.SetDefaultAction(config.DefaultAction)
+	rm.engine.SetShadowMode(config.ShadowMode)
+	rm.engine.SetLegacyCaseMatching(config.LegacyCaseMatching)
+	rm.engine.SetBodyInspection(config.BodyInspection)
+	rm.disableExpired = config.ExpiredRuleAction == types.ExpiredRuleDisable
+	rm.mu.Unlock()
+	return nil
 }
 
-// AddRule adds a new rule
-func (rm *Manager) AddRule(rule types.Rule) {
+// AddRule validates and adds a new rule
+func (rm *Manager) AddRule(rule types.Rule) error {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
-	rm.engine.AddRule(rule)
+
+	if err := rm.engine.AddRule(rule); err != nil {
+		return err
+	}
+	rm.engineChanged(types.RuleSetSourceAPI)
 	log.Printf("Added rule: %s", rule.ID)
+	return nil
+}
+
+// UpdateRule validates a rule and replaces the existing rule with the same ID
+func (rm *Manager) UpdateRule(rule types.Rule) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if err := rm.engine.UpdateRule(rule); err != nil {
+		return err
+	}
+	rm.engineChanged(types.RuleSetSourceAPI)
+	log.Printf("Updated rule: %s", rule.ID)
+	return nil
 }
 
 // RemoveRule removes a rule by ID
@@ -222,6 +448,7 @@ func (rm *Manager) RemoveRule(id string) bool {
 	defer rm.mu.Unlock()
 
 	if rm.engine.RemoveRule(id) {
+		rm.engineChanged(types.RuleSetSourceAPI)
 		log.Printf("Removed rule: %s", id)
 		return true
 	}
@@ -234,6 +461,7 @@ func (rm *Manager) EnableRule(id string) bool {
 	defer rm.mu.Unlock()
 
 	if rm.engine.EnableRule(id) {
+		rm.engineChanged(types.RuleSetSourceAPI)
 		log.Printf("Enabled rule: %s", id)
 		return true
 	}
@@ -246,6 +474,7 @@ func (rm *Manager) DisableRule(id string) bool {
 	defer rm.mu.Unlock()
 
 	if rm.engine.DisableRule(id) {
+		rm.engineChanged(types.RuleSetSourceAPI)
 		log.Printf("Disabled rule: %s", id)
 		return true
 	}
@@ -266,16 +495,132 @@ func (rm *Manager) GetRuleByID(id string) (*types.Rule, bool) {
 	return rm.engine.GetRuleByID(id)
 }
 
-// EvaluateRequest evaluates a request against all rules
+// EvaluateRequest evaluates a request against all rules and stamps the
+// result with the active ruleset version
 func (rm *Manager) EvaluateRequest(req *types.RequestInfo) *types.RuleResult {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
-	return rm.engine.EvaluateRequest(req)
+	result := rm.engine.EvaluateRequest(req)
+	current, _ := rm.history.current()
+	result.RuleSetVersion = current.Version
+	return result
+}
+
+// Explain evaluates a request and traces every rule
+func (rm *Manager) Explain(req *types.RequestInfo) *types.Explanation {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.engine.Explain(req)
+}
+
+// GetRuleStats returns match statistics for every rule
+func (rm *Manager) GetRuleStats() []types.RuleStats {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.engine.GetRuleStats()
+}
+
+// GetRuleStatsByID returns match statistics for a single rule
+func (rm *Manager) GetRuleStatsByID(id string) (types.RuleStats, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.engine.GetRuleStatsByID(id)
+}
+
+// recordVersion adds the engine's rules to the history if they changed. The
+// caller must hold rm.mu.
+func (rm *Manager) recordVersion(source types.RuleSetSource) {
+	if version, added := rm.history.record(rm.engine.GetRules(), source, time.Now()); added {
+		log.Printf("Ruleset version %d from %s: %d rules", version.Version, source, version.RuleCount)
+	}
+}
+
+// engineChanged takes the engine's rules, except those of the rules
+// directory, as the new base rules and records a version. The caller must
+// hold rm.mu.
+func (rm *Manager) engineChanged(source types.RuleSetSource) {
+	rules := rm.engine.GetRules()
+	base := make([]types.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if !rm.dirRuleIDs[rule.ID] {
+			base = append(base, rule)
+		}
+	}
+	rm.baseRules = base
+	rm.recordVersion(source)
+}
+
+// RuleSetVersion returns the active ruleset version
+func (rm *Manager) RuleSetVersion() types.RuleSetVersion {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	current, _ := rm.history.current()
+	return current
+}
+
+// RuleSetVersions returns the ruleset versions in the history, oldest first
+func (rm *Manager) RuleSetVersions() []types.RuleSetVersion {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.history.list()
+}
+
+// DiffRuleSets lists the rule changes from one ruleset version to another
+func (rm *Manager) DiffRuleSets(from, to int) (*types.RuleSetDiff, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	before, exists := rm.history.get(from)
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, from)
+	}
+	after, exists := rm.history.get(to)
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, to)
+	}
+
+	diff := diffRules(before.rules, after.rules)
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// Rollback restores the rules of an earlier ruleset version, recording them
+// as a new version. The rules file is not rewritten.
+func (rm *Manager) Rollback(version int) (types.RuleSetVersion, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	snapshot, exists := rm.history.get(version)
+	if !exists {
+		return types.RuleSetVersion{}, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	if err := rm.engine.UpdateRules(snapshot.rules); err != nil {
+		return types.RuleSetVersion{}, fmt.Errorf("cannot roll back to version %d: %w", version, err)
+	}
+	rm.engineChanged(types.RuleSetSourceRollback)
+	log.Printf("Rolled back rules to version %d", version)
+
+	current, _ := rm.history.current()
+	return current, nil
 }
 
 // Close cleans up the manager
 func (rm *Manager) Close() {
 	rm.StopFileWatcher()
+	if rm.expiryTicker != nil {
+		select {
+		case rm.stopExpiry <- true:
+		default:
+		}
+	}
+	if rm.ipSets != nil {
+		rm.ipSets.Close()
+	}
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	if rm.geoIP != nil {
+		rm.geoIP.Close()
+	}
 }
 
 // CreateSampleRulesFile creates a sample rules file
@@ -361,7 +706,7 @@ func CreateSampleRulesFile(filename string) error {
 		return fmt.Errorf("failed to marshal sample rules: %w", err)
 	}
 
-	if err := os.WriteFile(filename, data, 0644); err != nil {
+	if err := fileutil.WriteFileAtomic(filename, data, 0644); err != nil {
 		return fmt.Errorf("failed to write sample rules file: %w", err)
 	}
 
@@ -2,12 +2,17 @@ package rules
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"http-proxy/pkg/matcher"
 	"http-proxy/pkg/types"
 
 	"gopkg.in/yaml.v3"
@@ -302,6 +307,47 @@ func TestManager_SaveRulesToFile(t *testing.T) {
 	}
 }
 
+func TestManager_SaveRulesToFile_IgnoresOwnWrites(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	content := "rules:\n  - id: block-admin\n    type: url\n    operator: starts_with\n    value: /admin\n    action: block\n    enabled: true\n"
+	if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile, FileBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.AddRule(types.Rule{ID: "block-api", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/api", Action: types.ActionBlock, Enabled: true}); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+	if err := manager.SaveRulesToFile(); err != nil {
+		t.Fatalf("Failed to save rules: %v", err)
+	}
+
+	// The watcher considers the file unchanged since the save
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentDigest(data) != manager.lastDigest {
+		t.Error("Expected the watcher to skip its own write")
+	}
+
+	backups, err := filepath.Glob(rulesFile + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected a backup of the original file, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != content {
+		t.Errorf("Expected backup to hold the original rules, got %s", data)
+	}
+}
+
 func TestManager_AddRule(t *testing.T) {
 	config := &types.RulesConfig{
 		DefaultAction: types.ActionAllow,
@@ -449,16 +495,26 @@ func TestManager_UpdateRules(t *testing.T) {
 	newRules := []types.Rule{
 		{
 			ID:       "new-rule-1",
+			Type:     types.RuleTypeURL,
+			Operator: types.MatchStartsWith,
+			Value:    "/admin",
+			Action:   types.ActionBlock,
 			Priority: 50,
 		},
 		{
 			ID:       "new-rule-2",
+			Type:     types.RuleTypeIPv4,
+			Operator: types.MatchInRange,
+			Value:    "10.0.0.0/8",
+			Action:   types.ActionAllow,
 			Priority: 100,
 		},
 	}
 
 	// Update rules
-	manager.UpdateRules(newRules)
+	if err := manager.UpdateRules(newRules); err != nil {
+		t.Fatalf("Expected no error updating rules, got: %v", err)
+	}
 
 	// Verify rules were updated
 	rules := manager.GetRules()
@@ -476,6 +532,18 @@ func TestManager_UpdateRules(t *testing.T) {
 	if rules[0].ID != "new-rule-1" || rules[1].ID != "new-rule-2" {
 		t.Errorf("New rules not properly updated or sorted")
 	}
+
+	// An invalid list is rejected as a whole
+	invalid := newRules[1]
+	invalid.ID, invalid.Value = "bad-cidr", "10.0.0.0/33"
+	err = manager.UpdateRules([]types.Rule{newRules[0], invalid})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].RuleID != "bad-cidr" {
+		t.Errorf("Expected a validation error for bad-cidr, got %v", err)
+	}
+	if rules := manager.GetRules(); len(rules) != 2 {
+		t.Errorf("Expected rules to be unchanged after rejected update, got %d rules", len(rules))
+	}
 }
 
 func TestManager_EvaluateRequest(t *testing.T) {
@@ -551,7 +619,7 @@ func TestManager_FileWatching_DisabledByDefault(t *testing.T) {
 		Rules []types.Rule `yaml:"rules"`
 	}{
 		Rules: []types.Rule{
-			{ID: "initial-rule", Action: types.ActionAllow},
+			{ID: "initial-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/initial", Action: types.ActionAllow},
 		},
 	}
 
@@ -581,8 +649,8 @@ func TestManager_FileWatching_DisabledByDefault(t *testing.T) {
 		Rules []types.Rule `yaml:"rules"`
 	}{
 		Rules: []types.Rule{
-			{ID: "initial-rule", Action: types.ActionAllow},
-			{ID: "new-rule", Action: types.ActionBlock},
+			{ID: "initial-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/initial", Action: types.ActionAllow},
+			{ID: "new-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/new", Action: types.ActionBlock},
 		},
 	}
 
@@ -598,6 +666,48 @@ func TestManager_FileWatching_DisabledByDefault(t *testing.T) {
 	}
 }
 
+func TestManager_FileWatching_ReloadsChanges(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "rules.yaml")
+	rule := "rules:\n  - id: %s\n    type: url\n    operator: equals\n    value: /%s\n    action: block\n    enabled: true\n"
+	if err := os.WriteFile(rulesFile, []byte(fmt.Sprintf(rule, "first", "first")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManager(&types.RulesConfig{
+		RulesFile:      rulesFile,
+		WatchRulesFile: true,
+		ReloadInterval: 50 * time.Millisecond,
+		DefaultAction:  types.ActionAllow,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	// Editors that save by renaming a temporary file, twice within a second
+	for _, id := range []string{"second", "third"} {
+		tmp := filepath.Join(dir, ".rules.yaml.tmp")
+		if err := os.WriteFile(tmp, []byte(fmt.Sprintf(rule, id, id)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, rulesFile); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, exists := manager.GetRuleByID(id); exists {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, exists := manager.GetRuleByID(id); !exists {
+			t.Fatalf("Expected rule %s to be loaded after the file changed", id)
+		}
+	}
+}
+
 func TestCreateSampleRulesFile(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -744,3 +854,443 @@ func TestManager_GetEngine(t *testing.T) {
 		t.Errorf("Engine and manager should have same number of rules")
 	}
 }
+
+func TestNewManager_WithIPSets(t *testing.T) {
+	tempDir := t.TempDir()
+	setFile := filepath.Join(tempDir, "denylist.txt")
+	if err := os.WriteFile(setFile, []byte("# scanners\n203.0.113.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &types.RulesConfig{
+		DefaultAction: types.ActionAllow,
+		IPSets:        []types.IPSetConfig{{Name: "denylist", File: setFile}},
+		Rules: []types.Rule{
+			{
+				ID:       "block-denylist",
+				Type:     types.RuleTypeIPv4,
+				Operator: types.MatchInSet,
+				Value:    "denylist",
+				Action:   types.ActionBlock,
+				Priority: 10,
+				Enabled:  true,
+			},
+		},
+	}
+
+	manager, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("Expected no error creating manager with IP sets, got: %v", err)
+	}
+	defer manager.Close()
+
+	result := manager.EvaluateRequest(&types.RequestInfo{ClientIP: net.ParseIP("203.0.113.9")})
+	if result.Action != types.ActionBlock {
+		t.Errorf("Expected IP in set to be blocked, got %v (%s)", result.Action, result.Reason)
+	}
+
+	config.IPSets = []types.IPSetConfig{{Name: "broken", File: filepath.Join(tempDir, "missing.txt")}}
+	if _, err := NewManager(config); err == nil {
+		t.Errorf("Expected error for missing IP set file")
+	}
+}
+
+func TestManager_LoadGeoIP(t *testing.T) {
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.LoadGeoIP(&types.GeoIPConfig{}); err != nil {
+		t.Errorf("Expected no error without databases, got: %v", err)
+	}
+	if manager.GetEngine().geoResolver != nil {
+		t.Errorf("Expected no geo resolver without databases")
+	}
+
+	err = manager.LoadGeoIP(&types.GeoIPConfig{CountryDatabase: filepath.Join(t.TempDir(), "missing.mmdb")})
+	if err == nil || !strings.Contains(err.Error(), "failed to load GeoIP databases") {
+		t.Errorf("Expected error for missing database file, got: %v", err)
+	}
+}
+
+func TestManager_ExpireRules(t *testing.T) {
+	tempDir := t.TempDir()
+	rulesFile := filepath.Join(tempDir, "rules.yaml")
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	config := &types.RulesConfig{
+		DefaultAction:     types.ActionAllow,
+		RulesFile:         rulesFile,
+		ExpiredRuleAction: types.ExpiredRuleDisable,
+		Rules: []types.Rule{
+			{
+				ID:        "temporary",
+				Type:      types.RuleTypeURL,
+				Operator:  types.MatchEquals,
+				Value:     "/temp",
+				Action:    types.ActionBlock,
+				Priority:  10,
+				Enabled:   true,
+				ExpiresAt: &expiresAt,
+			},
+			{
+				ID:       "permanent",
+				Type:     types.RuleTypeURL,
+				Operator: types.MatchEquals,
+				Value:    "/perm",
+				Action:   types.ActionBlock,
+				Priority: 20,
+				Enabled:  true,
+			},
+		},
+	}
+
+	manager, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("Expected no error creating manager, got: %v", err)
+	}
+	defer manager.Close()
+
+	// The expiry must survive a save and reload
+	if err := manager.SaveRulesToFile(); err != nil {
+		t.Fatalf("Expected no error saving rules, got: %v", err)
+	}
+	reloaded, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatalf("Expected no error reloading rules, got: %v", err)
+	}
+	defer reloaded.Close()
+
+	rule, exists := reloaded.GetRuleByID("temporary")
+	if !exists || rule.ExpiresAt == nil || !rule.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Expected persisted expiry %s, got %+v", expiresAt, rule)
+	}
+
+	// Expire with disable configured
+	expired := manager.ExpireRules(expiresAt.Add(time.Second))
+	if len(expired) != 1 {
+		t.Fatalf("Expected 1 expired rule, got %d", len(expired))
+	}
+
+	rule, exists = manager.GetRuleByID("temporary")
+	if !exists || rule.Enabled {
+		t.Errorf("Expected expired rule to be kept but disabled")
+	}
+	if permanent, _ := manager.GetRuleByID("permanent"); !permanent.Enabled {
+		t.Errorf("Expected permanent rule to stay enabled")
+	}
+}
+
+func TestManager_LoadRulesFromFile_RejectsInvalidRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	valid := "rules:\n  - id: block-admin\n    type: url\n    operator: starts_with\n    value: /admin\n    action: block\n    enabled: true\n"
+	if err := os.WriteFile(rulesFile, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	invalid := valid +
+		"  - id: bad-regex\n    type: url\n    operator: regex\n    value: \"(\"\n    action: block\n" +
+		"  - id: block-admin\n    type: size\n    operator: gte\n    action: block\n"
+	if err := os.WriteFile(rulesFile, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(rulesFile, future, future)
+
+	err = manager.loadRulesFromFile()
+	if err == nil {
+		t.Fatal("Expected invalid rules file to be rejected")
+	}
+	for _, expected := range []string{
+		rulesFile + ":8: rule bad-regex: invalid regex",
+		rulesFile + ":13: rule block-admin: gte size rule requires min_size",
+		rulesFile + ":13: rule block-admin: duplicate rule ID",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got: %v", expected, err)
+		}
+	}
+
+	// The previous rules stay in place
+	rules := manager.GetRules()
+	if len(rules) != 1 || rules[0].ID != "block-admin" || rules[0].Type != types.RuleTypeURL {
+		t.Errorf("Expected previous rules to be kept, got %+v", rules)
+	}
+}
+
+func TestManager_LoadRulesFromFile_CustomRuleType(t *testing.T) {
+	type pathParams struct {
+		Paths []string `json:"paths"`
+	}
+	matcher.MustRegister("internal_path", matcher.Definition{
+		NewParams: func() interface{} { return &pathParams{} },
+		Compile: func(rule types.Rule, params interface{}) (matcher.Matcher, error) {
+			paths := params.(*pathParams).Paths
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("paths is required")
+			}
+			return matcher.MatcherFunc(func(req *types.RequestInfo) (bool, string) {
+				for _, path := range paths {
+					if req.Path == path {
+						return true, fmt.Sprintf("path %s is internal", path)
+					}
+				}
+				return false, "path is not internal"
+			}), nil
+		},
+	})
+	defer matcher.Unregister("internal_path")
+
+	files := map[string]string{
+		"rules.yaml": "rules:\n  - id: internal\n    type: internal_path\n    params:\n      paths: [/metrics, /debug]\n    action: block\n    enabled: true\n",
+		"rules.json": `{"rules": [{"id": "internal", "type": "internal_path", "params": {"paths": ["/metrics", "/debug"]}, "action": "block", "enabled": true}]}`,
+		"rules.toml": "[[rules]]\nid = \"internal\"\ntype = \"internal_path\"\naction = \"block\"\nenabled = true\n[rules.params]\npaths = [\"/metrics\", \"/debug\"]\n",
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			rulesFile := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile})
+			if err != nil {
+				t.Fatalf("Failed to create manager: %v", err)
+			}
+			defer manager.Close()
+
+			result := manager.EvaluateRequest(&types.RequestInfo{Path: "/debug"})
+			if !result.Matched || result.Action != types.ActionBlock {
+				t.Errorf("Expected custom rule to block, got %+v", result)
+			}
+		})
+	}
+
+	err := ValidateRules([]types.Rule{{ID: "empty", Type: "internal_path", Action: types.ActionBlock}})
+	if err == nil || !strings.Contains(err.Error(), "rule empty: paths is required") {
+		t.Errorf("Expected compile error of custom rule, got %v", err)
+	}
+}
+
+func TestManager_CustomRuleType_CompiledOnce(t *testing.T) {
+	compiles := make(map[string]int)
+	matcher.MustRegister("counted", matcher.Definition{
+		Compile: func(rule types.Rule, params interface{}) (matcher.Matcher, error) {
+			compiles[rule.ID]++
+			return matcher.MatcherFunc(func(req *types.RequestInfo) (bool, string) {
+				return req.Path == rule.Value, "counted"
+			}), nil
+		},
+	})
+	defer matcher.Unregister("counted")
+
+	rule := func(id, value string) types.Rule {
+		return types.Rule{ID: id, Type: "counted", Value: value, Action: types.ActionBlock, Enabled: true}
+	}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.UpdateRules([]types.Rule{rule("first", "/first")}); err != nil {
+		t.Fatalf("Failed to update rules: %v", err)
+	}
+	if err := manager.AddRule(rule("second", "/second")); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+	if err := manager.UpdateRule(rule("second", "/other")); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+	manager.GetEngine().SetLegacyCaseMatching(true)
+
+	if compiles["first"] != 1 || compiles["second"] != 2 {
+		t.Errorf("Expected first compiled once and second once per change, got %v", compiles)
+	}
+	if result := manager.EvaluateRequest(&types.RequestInfo{Path: "/other"}); result.Rule == nil || result.Rule.ID != "second" {
+		t.Errorf("Expected updated custom rule to match, got %+v", result)
+	}
+}
+
+func TestManager_RuleSetHistory(t *testing.T) {
+	blockAdmin := types.Rule{ID: "block-admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionBlock, Priority: 100, Enabled: true}
+	blockAPI := types.Rule{ID: "block-api", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/api", Action: types.ActionBlock, Priority: 200, Enabled: true}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, Rules: []types.Rule{blockAdmin}, HistorySize: 3})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	initial := manager.RuleSetVersion()
+	if initial.Version != 1 || initial.Source != types.RuleSetSourceConfig || initial.RuleCount != 1 || initial.Hash == "" {
+		t.Errorf("Expected version 1 from config with 1 rule, got %+v", initial)
+	}
+
+	if err := manager.AddRule(blockAPI); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+	manager.DisableRule("block-admin")
+	manager.DisableRule("block-admin") // no change, no new version
+
+	versions := manager.RuleSetVersions()
+	if len(versions) != 3 || versions[2].Version != 3 || versions[2].Source != types.RuleSetSourceAPI {
+		t.Fatalf("Expected versions 1-3 with version 3 from the API, got %+v", versions)
+	}
+
+	if result := manager.EvaluateRequest(&types.RequestInfo{URL: "/api/users"}); result.RuleSetVersion != 3 {
+		t.Errorf("Expected result stamped with version 3, got %d", result.RuleSetVersion)
+	}
+
+	diff, err := manager.DiffRuleSets(1, 3)
+	if err != nil {
+		t.Fatalf("Failed to diff versions: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].ID != "block-api" || len(diff.Removed) != 0 {
+		t.Errorf("Expected block-api to be added, got %+v", diff)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ID != "block-admin" || diff.Changed[0].After.Enabled {
+		t.Errorf("Expected block-admin to be disabled, got %+v", diff.Changed)
+	}
+
+	current, err := manager.Rollback(1)
+	if err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if current.Version != 4 || current.Source != types.RuleSetSourceRollback || current.Hash != initial.Hash {
+		t.Errorf("Expected version 4 from rollback with the hash of version 1, got %+v", current)
+	}
+	if result := manager.EvaluateRequest(&types.RequestInfo{URL: "/admin"}); result.Action != types.ActionBlock {
+		t.Errorf("Expected block-admin to be restored, got %s", result.Action)
+	}
+
+	// Version 1 has been evicted from a history of 3
+	if _, err := manager.Rollback(1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+	if _, err := manager.DiffRuleSets(1, 4); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestManager_RuleSetHistory_FileReload(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	content := "rules:\n  - id: block-admin\n    type: url\n    operator: starts_with\n    value: /admin\n    action: block\n    enabled: true\n"
+	if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	versions := manager.RuleSetVersions()
+	if len(versions) != 2 || versions[1].Source != types.RuleSetSourceFile || versions[1].RuleCount != 1 {
+		t.Errorf("Expected empty config version followed by file version, got %+v", versions)
+	}
+
+	// Rewriting the same rules does not add a version
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(rulesFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.loadRulesFromFile(); err != nil {
+		t.Fatalf("Failed to reload rules: %v", err)
+	}
+	if got := len(manager.RuleSetVersions()); got != 2 {
+		t.Errorf("Expected unchanged rules not to add a version, got %d versions", got)
+	}
+}
+
+func TestManager_ApplyConfig(t *testing.T) {
+	blockAdmin := types.Rule{ID: "block-admin", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/admin", Action: types.ActionBlock, Priority: 100, Enabled: true}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, Rules: []types.Rule{blockAdmin}})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	apiRule := types.Rule{ID: "api-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/api", Action: types.ActionBlock, Enabled: true}
+	if err := manager.AddRule(apiRule); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	// An unchanged rules list keeps the rules added through the API
+	err = manager.ApplyConfig(&types.RulesConfig{DefaultAction: types.ActionAllow, Rules: []types.Rule{blockAdmin}, LegacyCaseMatching: true})
+	if err != nil {
+		t.Fatalf("Failed to apply config: %v", err)
+	}
+	if _, exists := manager.GetRuleByID("api-rule"); !exists {
+		t.Error("Expected the API rule to survive a config change that keeps the rules list")
+	}
+
+	// A changed rules list replaces them
+	err = manager.ApplyConfig(&types.RulesConfig{DefaultAction: types.ActionBlock, ShadowMode: true})
+	if err != nil {
+		t.Fatalf("Failed to apply config: %v", err)
+	}
+
+	result := manager.EvaluateRequest(&types.RequestInfo{URL: "/public"})
+	if result.Action != types.ActionBlock {
+		t.Errorf("Expected new default action block, got %s", result.Action)
+	}
+	if rules := manager.GetRules(); len(rules) != 0 {
+		t.Errorf("Expected config rules to be replaced, got %d rules", len(rules))
+	}
+	if version := manager.RuleSetVersion(); version.Version != 3 || version.Source != types.RuleSetSourceConfig {
+		t.Errorf("Expected version 3 from config, got %+v", version)
+	}
+
+	invalid := blockAdmin
+	invalid.Operator = "bogus"
+	if err := manager.ApplyConfig(&types.RulesConfig{DefaultAction: types.ActionAllow, Rules: []types.Rule{invalid}}); err == nil {
+		t.Error("Expected error for invalid rules")
+	}
+	if result := manager.EvaluateRequest(&types.RequestInfo{URL: "/public"}); result.Action != types.ActionBlock {
+		t.Errorf("Expected invalid rules to leave the default action alone, got %s", result.Action)
+	}
+}
+
+func TestManager_ApplyConfig_RulesFile(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	data := "rules:\n  - id: block-admin\n    type: url\n    operator: starts_with\n    value: /admin\n    action: block\n    enabled: true\n"
+	if err := os.WriteFile(rulesFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+	version := manager.RuleSetVersion().Version
+
+	configRule := types.Rule{ID: "config-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/config", Action: types.ActionBlock, Enabled: true}
+	err = manager.ApplyConfig(&types.RulesConfig{DefaultAction: types.ActionBlock, RulesFile: rulesFile, Rules: []types.Rule{configRule}})
+	if err != nil {
+		t.Fatalf("Failed to apply config: %v", err)
+	}
+
+	if rules := manager.GetRules(); len(rules) != 1 || rules[0].ID != "block-admin" {
+		t.Errorf("Expected the rules file to keep supplying the rules, got %+v", rules)
+	}
+	if manager.RuleSetVersion().Version != version {
+		t.Errorf("Expected no new ruleset version, got %+v", manager.RuleSetVersion())
+	}
+	if result := manager.EvaluateRequest(&types.RequestInfo{URL: "/public"}); result.Action != types.ActionBlock {
+		t.Errorf("Expected new default action block, got %s", result.Action)
+	}
+}
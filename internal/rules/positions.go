@@ -0,0 +1,128 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleLines returns the 1-based line of each element of the rules list found
+// under the key path (for example "rules" in a rules file or "rules", "rules"
+// in a proxy config). Lines are 0 where the position cannot be determined.
+func RuleLines(data []byte, filename string, path ...string) []int {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return yamlRuleLines(data, path)
+	case ".json":
+		return jsonRuleLines(data, path)
+	case ".toml":
+		return tomlRuleLines(data, path)
+	}
+	return nil
+}
+
+// yamlRuleLines walks the YAML node tree to the rules sequence
+func yamlRuleLines(data []byte, path []string) []int {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil || len(document.Content) == 0 {
+		return nil
+	}
+
+	node := document.Content[0]
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	lines := make([]int, len(node.Content))
+	for i, item := range node.Content {
+		lines[i] = item.Line
+	}
+	return lines
+}
+
+// jsonRuleLines scans the JSON tokens to the rules array and records the
+// offset at which each element starts
+func jsonRuleLines(data []byte, path []string) []int {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	for _, key := range path {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return nil
+		}
+		for {
+			if !decoder.More() {
+				return nil
+			}
+			token, err := decoder.Token()
+			if err != nil {
+				return nil
+			}
+			if token == key {
+				break
+			}
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return nil
+			}
+		}
+	}
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil
+	}
+
+	var lines []int
+	for decoder.More() {
+		start := int(decoder.InputOffset())
+		for start < len(data) && strings.IndexByte(" \t\r\n,", data[start]) >= 0 {
+			start++
+		}
+		lines = append(lines, 1+bytes.Count(data[:start], []byte("\n")))
+
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return lines
+		}
+	}
+	return lines
+}
+
+// tomlRuleLines finds the array-of-tables headers of the rules list
+func tomlRuleLines(data []byte, path []string) []int {
+	header := "[[" + strings.Join(path, ".") + "]]"
+
+	var lines []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if index := strings.IndexByte(line, '#'); index >= 0 {
+			line = line[:index]
+		}
+		if strings.ReplaceAll(line, " ", "") == header {
+			lines = append(lines, lineNumber)
+		}
+	}
+	return lines
+}
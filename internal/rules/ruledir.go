@@ -0,0 +1,265 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"http-proxy/pkg/types"
+)
+
+// NamespaceSeparator joins the namespace of a rules directory file and the
+// IDs of its rules, as in "team-a:block-admin"
+const NamespaceSeparator = ":"
+
+// ruleFile is the state of one file of the rules directory
+type ruleFile struct {
+	namespace string
+	digest    string
+	rules     []types.Rule // last valid rules, namespaced
+	err       error        // error of the last load, if any
+	conflict  error        // rule ID conflict that keeps the file out of the merge
+}
+
+// isRulesFile reports whether name has a supported rules file extension
+func isRulesFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json", ".toml":
+		return true
+	}
+	return false
+}
+
+// rulesDirFiles returns the rule files of a directory or glob pattern, sorted.
+// Hidden files, such as editor and temporary files, are skipped.
+func rulesDirFiles(pattern string) ([]string, error) {
+	var paths []string
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rules directory: %w", err)
+		}
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(pattern, entry.Name()))
+		}
+	} else {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules_dir pattern: %w", err)
+		}
+		paths = matches
+	}
+
+	files := paths[:0]
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if strings.HasPrefix(name, ".") || !isRulesFile(name) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// rulesDirWatchPath returns the directory to watch for a rules_dir setting
+func rulesDirWatchPath(pattern string) string {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		return pattern
+	}
+	return filepath.Dir(pattern)
+}
+
+// loadRuleFile parses and validates one file of the rules directory and
+// namespaces its rule IDs. The namespace is the file's "namespace" key, or
+// else its name without extension.
+func loadRuleFile(path string, data []byte) (string, []types.Rule, error) {
+	document, err := parseRulesDocument(data, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	namespace := document.Namespace
+	if namespace == "" {
+		namespace = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if strings.Contains(namespace, NamespaceSeparator) || strings.Contains(namespace, "/") {
+		return "", nil, fmt.Errorf("invalid namespace %q", namespace)
+	}
+
+	if err := ValidateRules(document.Rules); err != nil {
+		var errs ValidationErrors
+		if errors.As(err, &errs) {
+			err = errs.WithPositions(path, RuleLines(data, path, "rules"))
+		}
+		return "", nil, fmt.Errorf("invalid rules: %w", err)
+	}
+
+	rules := make([]types.Rule, len(document.Rules))
+	for i, rule := range document.Rules {
+		rule.ID = namespace + NamespaceSeparator + rule.ID
+		rules[i] = rule
+	}
+	return namespace, rules, nil
+}
+
+// refreshRuleFiles reloads the files of the rules directory whose content
+// changed. A file that fails to load keeps its last valid rules, so that one
+// broken file does not hold back the others. The caller must hold rm.fileMu.
+func (rm *Manager) refreshRuleFiles() (bool, error) {
+	paths, err := rulesDirFiles(rm.rulesDir)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read rules file %s: %v", path, err)
+			continue
+		}
+		digest := contentDigest(data)
+		file, exists := rm.ruleFiles[path]
+		if exists && file.digest == digest {
+			continue
+		}
+		if !exists {
+			file = &ruleFile{}
+			rm.ruleFiles[path] = file
+		}
+		file.digest = digest
+		changed = true
+
+		namespace, rules, err := loadRuleFile(path, data)
+		if err != nil {
+			file.err = err
+			log.Printf("Keeping previous rules of %s: %v", path, err)
+			continue
+		}
+		file.namespace, file.rules, file.err = namespace, rules, nil
+		log.Printf("Loaded %d rules from %s", len(rules), path)
+	}
+
+	for path := range rm.ruleFiles {
+		if !present[path] {
+			delete(rm.ruleFiles, path)
+			changed = true
+			log.Printf("Rules file %s was removed", path)
+		}
+	}
+	return changed, nil
+}
+
+// mergeRules returns the base rules followed by the rules of every directory
+// file in path order. A file with a rule ID already taken by the base rules
+// or an earlier file is left out as a whole. The caller must hold rm.fileMu.
+func (rm *Manager) mergeRules(base []types.Rule) []types.Rule {
+	merged := append([]types.Rule(nil), base...)
+	owner := make(map[string]string, len(merged))
+	for _, rule := range merged {
+		owner[rule.ID] = "the base rules"
+	}
+
+	paths := make([]string, 0, len(rm.ruleFiles))
+	for path := range rm.ruleFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file := rm.ruleFiles[path]
+		if file.rules == nil {
+			continue
+		}
+
+		var conflict error
+		for _, rule := range file.rules {
+			if other, exists := owner[rule.ID]; exists {
+				conflict = fmt.Errorf("rule ID %s conflicts with %s", rule.ID, other)
+				break
+			}
+		}
+		if conflict != nil && (file.conflict == nil || file.conflict.Error() != conflict.Error()) {
+			log.Printf("Skipping rules file %s: %v", path, conflict)
+		}
+		file.conflict = conflict
+		if conflict != nil {
+			continue
+		}
+
+		for _, rule := range file.rules {
+			owner[rule.ID] = path
+		}
+		merged = append(merged, file.rules...)
+	}
+	return merged
+}
+
+// loadRulesDir reloads the rules directory and applies the merged rules if
+// any file changed
+func (rm *Manager) loadRulesDir() error {
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+
+	changed, err := rm.refreshRuleFiles()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return rm.applyRules(nil, types.RuleSetSourceFile)
+	}
+	return nil
+}
+
+// applyRules replaces the engine's rules with the base rules, or the current
+// ones if base is nil, and the directory rules. The caller must hold rm.fileMu.
+func (rm *Manager) applyRules(base []types.Rule, source types.RuleSetSource) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if base == nil {
+		base = rm.baseRules
+	}
+	rules := rm.mergeRules(base)
+	if err := rm.engine.UpdateRules(rules); err != nil {
+		return err
+	}
+
+	rm.baseRules = base
+	rm.dirRuleIDs = make(map[string]bool, len(rules)-len(base))
+	for _, rule := range rules[len(base):] {
+		rm.dirRuleIDs[rule.ID] = true
+	}
+	rm.engineChanged(source)
+	return nil
+}
+
+// RuleFiles returns the status of every file of the rules directory
+func (rm *Manager) RuleFiles() []types.RuleFileStatus {
+	rm.fileMu.Lock()
+	defer rm.fileMu.Unlock()
+
+	statuses := make([]types.RuleFileStatus, 0, len(rm.ruleFiles))
+	for path, file := range rm.ruleFiles {
+		status := types.RuleFileStatus{Path: path, Namespace: file.namespace, RuleCount: len(file.rules)}
+		if file.err != nil {
+			status.Error = file.err.Error()
+		} else if file.conflict != nil {
+			status.Error = file.conflict.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+func writeRuleFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ruleIDs(rules []types.Rule) map[string]types.Rule {
+	ids := make(map[string]types.Rule, len(rules))
+	for _, rule := range rules {
+		ids[rule.ID] = rule
+	}
+	return ids
+}
+
+func TestManager_RulesDir(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml":          "rules:\n  - id: block-admin\n    type: url\n    operator: starts_with\n    value: /admin\n    action: block\n    enabled: true\n",
+		"team-b.json":          `{"rules":[{"id":"block-admin","type":"url","operator":"starts_with","value":"/b-admin","action":"block","enabled":true}]}`,
+		"payments.toml":        "namespace = \"pay\"\n[[rules]]\nid = \"block-refunds\"\ntype = \"url\"\noperator = \"equals\"\nvalue = \"/refunds\"\naction = \"block\"\nenabled = true\n",
+		"broken.yaml":          "rules:\n  - id: bad\n    type: url\n    operator: regex\n    value: \"(\"\n    action: block\n",
+		".team-a.yaml.swp":     "not yaml: [",
+		"team-a.yaml.bak":      "rules: []",
+		"README.md":            "# rules",
+		"team-a.yaml.20240101": "rules: []",
+	})
+
+	manager, err := NewManager(&types.RulesConfig{
+		DefaultAction: types.ActionAllow,
+		RulesDir:      dir,
+		Rules:         []types.Rule{{ID: "allow-health", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/health", Action: types.ActionAllow, Enabled: true}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	rules := ruleIDs(manager.GetRules())
+	for _, id := range []string{"allow-health", "team-a:block-admin", "team-b:block-admin", "pay:block-refunds"} {
+		if _, exists := rules[id]; !exists {
+			t.Errorf("Expected rule %s, got %v", id, manager.GetRules())
+		}
+	}
+	if len(rules) != 4 {
+		t.Errorf("Expected 4 rules, got %d", len(rules))
+	}
+
+	files := manager.RuleFiles()
+	if len(files) != 4 {
+		t.Fatalf("Expected 4 rule files, got %+v", files)
+	}
+	if files[0].Path != filepath.Join(dir, "broken.yaml") || !strings.Contains(files[0].Error, "broken.yaml:2") {
+		t.Errorf("Expected broken.yaml to report its error position, got %+v", files[0])
+	}
+	if files[1].Namespace != "pay" || files[1].RuleCount != 1 {
+		t.Errorf("Expected payments.toml in namespace pay, got %+v", files[1])
+	}
+
+	if result := manager.EvaluateRequest(&types.RequestInfo{URL: "/refunds"}); result.Action != types.ActionBlock || result.Rule.ID != "pay:block-refunds" {
+		t.Errorf("Expected /refunds to be blocked by pay:block-refunds, got %+v", result)
+	}
+}
+
+func TestManager_RulesDir_PerFileReload(t *testing.T) {
+	dir := t.TempDir()
+	rule := "rules:\n  - id: %s\n    type: url\n    operator: equals\n    value: /%s\n    action: block\n    enabled: true\n"
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml": fmt.Sprintf(rule, "a1", "a1"),
+		"team-b.yaml": fmt.Sprintf(rule, "b1", "b1"),
+	})
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	// team-a breaks its file while team-b updates theirs
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml": "rules:\n  - id: a2\n    type: url\n",
+		"team-b.yaml": fmt.Sprintf(rule, "b2", "b2"),
+	})
+	if err := manager.loadRulesDir(); err != nil {
+		t.Fatalf("Failed to reload rules directory: %v", err)
+	}
+
+	rules := ruleIDs(manager.GetRules())
+	if _, exists := rules["team-a:a1"]; !exists {
+		t.Error("Expected team-a to keep its previous rules")
+	}
+	if _, exists := rules["team-b:b2"]; !exists {
+		t.Error("Expected team-b's update to be applied")
+	}
+	if _, exists := rules["team-b:b1"]; exists {
+		t.Error("Expected team-b's old rule to be gone")
+	}
+
+	// Removing a file removes its rules
+	if err := os.Remove(filepath.Join(dir, "team-b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.loadRulesDir(); err != nil {
+		t.Fatalf("Failed to reload rules directory: %v", err)
+	}
+	if rules := manager.GetRules(); len(rules) != 1 || rules[0].ID != "team-a:a1" {
+		t.Errorf("Expected only team-a:a1 to remain, got %v", rules)
+	}
+}
+
+func TestManager_RulesDir_Conflicts(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFiles(t, dir, map[string]string{
+		"a.yaml": "namespace: shared\nrules:\n  - id: block-admin\n    type: url\n    operator: equals\n    value: /admin\n    action: block\n",
+		"b.yaml": "namespace: shared\nrules:\n  - id: block-admin\n    type: url\n    operator: equals\n    value: /b\n    action: block\n",
+		"c.yaml": "namespace: c\nrules:\n  - id: ok\n    type: url\n    operator: equals\n    value: /c\n    action: block\n",
+	})
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesDir: filepath.Join(dir, "*.yaml")})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	rules := ruleIDs(manager.GetRules())
+	if len(rules) != 2 || rules["shared:block-admin"].Value != "/admin" {
+		t.Errorf("Expected a.yaml to win the conflict and c.yaml to load, got %v", manager.GetRules())
+	}
+	if files := manager.RuleFiles(); !strings.Contains(files[1].Error, "conflicts with") {
+		t.Errorf("Expected b.yaml to report the conflict, got %+v", files[1])
+	}
+
+	// Once a.yaml is gone, b.yaml is merged
+	if err := os.Remove(filepath.Join(dir, "a.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.loadRulesDir(); err != nil {
+		t.Fatalf("Failed to reload rules directory: %v", err)
+	}
+	if rules := ruleIDs(manager.GetRules()); rules["shared:block-admin"].Value != "/b" {
+		t.Errorf("Expected b.yaml to be merged, got %v", manager.GetRules())
+	}
+	if files := manager.RuleFiles(); files[0].Error != "" {
+		t.Errorf("Expected conflict to be cleared, got %+v", files[0])
+	}
+}
+
+func TestManager_RulesDir_KeepsAPIChanges(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml": "rules:\n  - id: a1\n    type: url\n    operator: equals\n    value: /a1\n    action: block\n",
+	})
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesDir: dir, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.AddRule(types.Rule{ID: "api-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/api", Action: types.ActionBlock}); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml": "rules:\n  - id: a2\n    type: url\n    operator: equals\n    value: /a2\n    action: block\n",
+	})
+	if err := manager.loadRulesDir(); err != nil {
+		t.Fatalf("Failed to reload rules directory: %v", err)
+	}
+
+	rules := ruleIDs(manager.GetRules())
+	if _, exists := rules["api-rule"]; !exists {
+		t.Error("Expected the API rule to survive a directory reload")
+	}
+	if _, exists := rules["team-a:a2"]; !exists {
+		t.Error("Expected team-a:a2 to be loaded")
+	}
+
+	// Directory rules stay in their own files
+	if err := manager.SaveRulesToFile(); err != nil {
+		t.Fatalf("Failed to save rules: %v", err)
+	}
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "api-rule") || strings.Contains(string(data), "team-a:") {
+		t.Errorf("Expected only the API rule to be saved, got %s", data)
+	}
+}
+
+func TestManager_RulesDir_UpdateRules(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFiles(t, dir, map[string]string{
+		"team-a.yaml": "rules:\n  - id: a1\n    type: url\n    operator: equals\n    value: /a1\n    action: block\n",
+	})
+
+	manager, err := NewManager(&types.RulesConfig{DefaultAction: types.ActionAllow, RulesDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.UpdateRules([]types.Rule{{ID: "api-rule", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/api", Action: types.ActionBlock}})
+	if err != nil {
+		t.Fatalf("Failed to update rules: %v", err)
+	}
+
+	rules := ruleIDs(manager.GetRules())
+	if _, exists := rules["api-rule"]; !exists || len(rules) != 2 {
+		t.Errorf("Expected api-rule to replace the base rules, got %v", rules)
+	}
+	if _, exists := rules["team-a:a1"]; !exists {
+		t.Error("Expected directory rules to survive UpdateRules")
+	}
+}
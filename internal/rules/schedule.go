@@ -0,0 +1,268 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+// schedule is the compiled form of types.Schedule
+type schedule struct {
+	location  *time.Location
+	cron      *cronExpr
+	days      uint8 // bit per time.Weekday; 0 means every day
+	startMin  int   // minutes since midnight, inclusive
+	endMin    int   // minutes since midnight, exclusive
+	window    bool  // whether start/end times were given
+	notBefore *time.Time
+	notAfter  *time.Time
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compileSchedule validates and compiles a rule schedule
+func compileSchedule(s *types.Schedule) (*schedule, error) {
+	compiled := &schedule{
+		location:  time.UTC,
+		startMin:  0,
+		endMin:    24 * 60,
+		notBefore: s.NotBefore,
+		notAfter:  s.NotAfter,
+	}
+
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %w", s.Timezone, err)
+		}
+		compiled.location = location
+	}
+
+	if s.Cron != "" {
+		cron, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", s.Cron, err)
+		}
+		compiled.cron = cron
+	}
+
+	for _, day := range s.Days {
+		name := strings.ToLower(strings.TrimSpace(day))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		weekday, ok := weekdayNames[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", day)
+		}
+		compiled.days |= 1 << uint(weekday)
+	}
+
+	if s.StartTime != "" {
+		minutes, err := parseClock(s.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_time: %w", err)
+		}
+		compiled.startMin = minutes
+		compiled.window = true
+	}
+	if s.EndTime != "" {
+		minutes, err := parseClock(s.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end_time: %w", err)
+		}
+		compiled.endMin = minutes
+		compiled.window = true
+	}
+	if compiled.window && compiled.startMin == compiled.endMin {
+		return nil, fmt.Errorf("start_time and end_time must differ")
+	}
+
+	if s.NotBefore != nil && s.NotAfter != nil && !s.NotAfter.After(*s.NotBefore) {
+		return nil, fmt.Errorf("not_after must be later than not_before")
+	}
+
+	return compiled, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" is allowed as an end time
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	total := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || total > 24*60 {
+		return 0, fmt.Errorf("time %q out of range", value)
+	}
+	return total, nil
+}
+
+// active reports whether the schedule allows the rule at now, with a reason when it does not
+func (s *schedule) active(now time.Time) (bool, string) {
+	if s.notBefore != nil && now.Before(*s.notBefore) {
+		return false, fmt.Sprintf("rule not active before %s", s.notBefore.Format(time.RFC3339))
+	}
+	if s.notAfter != nil && !now.Before(*s.notAfter) {
+		return false, fmt.Sprintf("rule not active after %s", s.notAfter.Format(time.RFC3339))
+	}
+
+	local := now.In(s.location)
+
+	if s.cron != nil && !s.cron.matches(local) {
+		return false, fmt.Sprintf("outside cron schedule at %s", local.Format("Mon 15:04 MST"))
+	}
+
+	if s.window || s.days != 0 {
+		minutes := local.Hour()*60 + local.Minute()
+		today := local.Weekday()
+
+		var inWindow bool
+		if s.startMin < s.endMin {
+			inWindow = minutes >= s.startMin && minutes < s.endMin && s.dayAllowed(today)
+		} else {
+			// Overnight window: the part after midnight belongs to the previous day
+			inWindow = (minutes >= s.startMin && s.dayAllowed(today)) ||
+				(minutes < s.endMin && s.dayAllowed((today+6)%7))
+		}
+		if !inWindow {
+			return false, fmt.Sprintf("outside scheduled window at %s", local.Format("Mon 15:04 MST"))
+		}
+	}
+
+	return true, ""
+}
+
+func (s *schedule) dayAllowed(day time.Weekday) bool {
+	return s.days == 0 || s.days&(1<<uint(day)) != 0
+}
+
+// cronExpr is a parsed five-field cron expression (minute hour day-of-month month day-of-week)
+type cronExpr struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek uint64
+	domRestricted, dowRestricted                    bool
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses a five-field cron expression
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	cron := &cronExpr{}
+	var err error
+	if cron.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if cron.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if cron.daysOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if cron.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if cron.daysOfWeek, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// 7 is an alias for Sunday
+	if cron.daysOfWeek&(1<<7) != 0 {
+		cron.daysOfWeek |= 1
+	}
+	cron.domRestricted = fields[2] != "*"
+	cron.dowRestricted = fields[4] != "*"
+
+	return cron, nil
+}
+
+// parseCronField parses lists, ranges, steps and names into a bit set
+func parseCronField(field string, low, high int, names map[string]int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.IndexByte(part, '/'); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:index]
+		}
+
+		start, end := low, high
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				end = high
+			}
+		}
+
+		if start < low || end > high || start > end {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, low, high)
+		}
+		for value := start; value <= end; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return number, nil
+}
+
+// matches reports whether t falls in a minute selected by the expression
+func (c *cronExpr) matches(t time.Time) bool {
+	if c.minutes&(1<<uint(t.Minute())) == 0 || c.hours&(1<<uint(t.Hour())) == 0 ||
+		c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := c.daysOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := c.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	// As in cron, when both day fields are restricted either one may match
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
@@ -0,0 +1,145 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+func TestSchedule_Active(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule types.Schedule
+		now      time.Time
+		expected bool
+	}{
+		{
+			name:     "Business hours on a weekday",
+			schedule: types.Schedule{Days: []string{"mon", "tue", "wed", "thu", "fri"}, StartTime: "09:00", EndTime: "17:00"},
+			now:      time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC), // Wednesday
+			expected: true,
+		},
+		{
+			name:     "Business hours end is exclusive",
+			schedule: types.Schedule{StartTime: "09:00", EndTime: "17:00"},
+			now:      time.Date(2026, 3, 4, 17, 0, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "Weekend outside weekday window",
+			schedule: types.Schedule{Days: []string{"Monday", "Friday"}, StartTime: "09:00", EndTime: "17:00"},
+			now:      time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC), // Saturday
+			expected: false,
+		},
+		{
+			name:     "Overnight window after midnight belongs to previous day",
+			schedule: types.Schedule{Days: []string{"sat"}, StartTime: "22:00", EndTime: "04:00"},
+			now:      time.Date(2026, 3, 8, 2, 0, 0, 0, time.UTC), // Sunday 02:00
+			expected: true,
+		},
+		{
+			name:     "Window in another time zone",
+			schedule: types.Schedule{Timezone: "America/New_York", StartTime: "09:00", EndTime: "17:00"},
+			now:      time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC), // 10:00 in New York
+			expected: true,
+		},
+		{
+			name:     "Cron maintenance window",
+			schedule: types.Schedule{Cron: "*/15 2-3 * * sun"},
+			now:      time.Date(2026, 3, 8, 2, 45, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "Cron minute not selected",
+			schedule: types.Schedule{Cron: "*/15 2-3 * * sun"},
+			now:      time.Date(2026, 3, 8, 2, 46, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "Before absolute start",
+			schedule: types.Schedule{NotBefore: &start, NotAfter: &end},
+			now:      time.Date(2026, 2, 28, 12, 0, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "Within absolute range",
+			schedule: types.Schedule{NotBefore: &start, NotAfter: &end},
+			now:      time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "At absolute end",
+			schedule: types.Schedule{NotBefore: &start, NotAfter: &end},
+			now:      end,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := compileSchedule(&tt.schedule)
+			if err != nil {
+				t.Fatalf("Expected schedule to compile, got: %v", err)
+			}
+
+			if active, reason := compiled.active(tt.now); active != tt.expected {
+				t.Errorf("Expected active %v at %s, got %v (%s)", tt.expected, tt.now, active, reason)
+			}
+		})
+	}
+}
+
+func TestCompileSchedule_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule types.Schedule
+	}{
+		{"Unknown timezone", types.Schedule{Timezone: "Mars/Olympus"}},
+		{"Bad cron field count", types.Schedule{Cron: "* * *"}},
+		{"Cron value out of range", types.Schedule{Cron: "60 * * * *"}},
+		{"Unknown weekday", types.Schedule{Days: []string{"someday"}}},
+		{"Bad time format", types.Schedule{StartTime: "9am"}},
+		{"Empty window", types.Schedule{StartTime: "09:00", EndTime: "09:00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileSchedule(&tt.schedule); err == nil {
+				t.Errorf("Expected error for %+v", tt.schedule)
+			}
+		})
+	}
+}
+
+func TestEngine_ScheduledRules(t *testing.T) {
+	rules := []types.Rule{
+		{
+			ID:       "maintenance",
+			Type:     types.RuleTypeURL,
+			Operator: types.MatchStartsWith,
+			Value:    "/",
+			Action:   types.ActionBlock,
+			Priority: 10,
+			Enabled:  true,
+			Schedule: &types.Schedule{Days: []string{"sun"}, StartTime: "02:00", EndTime: "04:00"},
+		},
+	}
+
+	engine := NewEngine(rules, types.ActionAllow)
+	req := &types.RequestInfo{URL: "/orders"}
+
+	now := time.Date(2026, 3, 8, 3, 0, 0, 0, time.UTC) // Sunday 03:00
+	engine.SetClock(func() time.Time { return now })
+	if result := engine.EvaluateRequest(req); result.Action != types.ActionBlock {
+		t.Errorf("Expected request to be blocked during maintenance window")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if result := engine.EvaluateRequest(req); result.Action != types.ActionAllow || result.Matched {
+		t.Errorf("Expected request to be allowed outside maintenance window")
+	}
+}
@@ -0,0 +1,361 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"http-proxy/pkg/matcher"
+	"http-proxy/pkg/types"
+)
+
+// ValidationError describes a problem with a single rule
+type ValidationError struct {
+	Index    int    // position of the rule in the list
+	RuleID   string // may be empty when the rule has no ID
+	Position string // "file:line" when known
+	Message  string
+}
+
+// Error formats the error with its position and rule ID
+func (e ValidationError) Error() string {
+	var prefix string
+	if e.Position != "" {
+		prefix = e.Position + ": "
+	}
+	if e.RuleID == "" {
+		return fmt.Sprintf("%srule at index %d: %s", prefix, e.Index, e.Message)
+	}
+	return fmt.Sprintf("%srule %s: %s", prefix, e.RuleID, e.Message)
+}
+
+// ValidationErrors holds every problem found in a rule list
+type ValidationErrors []ValidationError
+
+// Error lists all problems, one per line
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	if len(errs) == 1 {
+		return messages[0]
+	}
+	return fmt.Sprintf("%d rule errors:\n  %s", len(errs), strings.Join(messages, "\n  "))
+}
+
+// WithPositions annotates the errors with "file:line" positions, where lines
+// holds the line of each rule by index as returned by RuleLines. Errors of
+// rules without a known line get the file name alone.
+func (errs ValidationErrors) WithPositions(filename string, lines []int) ValidationErrors {
+	for i := range errs {
+		errs[i].Position = filename
+		if index := errs[i].Index; index >= 0 && index < len(lines) && lines[index] > 0 {
+			errs[i].Position = fmt.Sprintf("%s:%d", filename, lines[index])
+		}
+	}
+	return errs
+}
+
+// stringOperators are the operators supported by string rule types
+var stringOperators = []types.MatchOperator{
+	types.MatchEquals, types.MatchContains, types.MatchStartsWith,
+	types.MatchEndsWith, types.MatchRegex, types.MatchWildcard,
+	types.MatchNotEquals, types.MatchNotContains, types.MatchNotStartsWith,
+	types.MatchNotEndsWith, types.MatchNotRegex, types.MatchNotWildcard,
+	types.MatchIn, types.MatchNotIn,
+}
+
+// headerOperators are the string operators plus presence checks
+var headerOperators = append(append([]types.MatchOperator{}, stringOperators...), types.MatchExists, types.MatchNotExists)
+
+// ruleOperators lists the operators supported by each rule type
+var ruleOperators = map[types.RuleType][]types.MatchOperator{
+	types.RuleTypeIPv4:      {types.MatchEquals, types.MatchInRange, types.MatchInSet},
+	types.RuleTypeIPv6:      {types.MatchEquals, types.MatchInRange, types.MatchInSet},
+	types.RuleTypeURL:       stringOperators,
+	types.RuleTypeDomain:    stringOperators,
+	types.RuleTypeUserAgent: stringOperators,
+	types.RuleTypeURISuffix: {types.MatchEquals, types.MatchWildcard, types.MatchRegex},
+	types.RuleTypeSize:      {types.MatchEquals, types.MatchGTE, types.MatchLTE, types.MatchInRange},
+	types.RuleTypeMethod:    stringOperators,
+	types.RuleTypeHeader:    headerOperators,
+	types.RuleTypeJSONField: stringOperators,
+	types.RuleTypeFormField: stringOperators,
+	types.RuleTypeCountry:   stringOperators,
+	types.RuleTypeASN:       stringOperators,
+
+	// Expression rules take no operator
+	types.RuleTypeExpression: {""},
+}
+
+// ValidateRules checks every rule and returns all problems as ValidationErrors,
+// or nil when the rules are valid
+func ValidateRules(rules []types.Rule) error {
+	_, err := validateRules(rules)
+	return err
+}
+
+// validateRules is ValidateRules, also returning the matchers compiled while
+// checking rules of custom types, by rule ID, so they need not be compiled again
+func validateRules(rules []types.Rule) (map[string]matcher.Matcher, error) {
+	var errs ValidationErrors
+	seen := make(map[string]int, len(rules))
+	custom := make(map[string]matcher.Matcher)
+
+	for i, rule := range rules {
+		problems, compiled := validateRule(rule)
+		for _, message := range problems {
+			errs = append(errs, ValidationError{Index: i, RuleID: rule.ID, Message: message})
+		}
+		if compiled != nil {
+			custom[rule.ID] = compiled
+		}
+		if rule.ID == "" {
+			continue
+		}
+		if first, exists := seen[rule.ID]; exists {
+			errs = append(errs, ValidationError{
+				Index:   i,
+				RuleID:  rule.ID,
+				Message: fmt.Sprintf("duplicate rule ID, first defined at index %d", first),
+			})
+			continue
+		}
+		seen[rule.ID] = i
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return custom, nil
+}
+
+// validateAddedRule validates a rule to be appended to rules, which are
+// already valid and are not checked again. It returns the rule's matcher if
+// it has a custom type.
+func validateAddedRule(rules []types.Rule, rule types.Rule) (matcher.Matcher, error) {
+	problems, compiled := validateRule(rule)
+	var errs ValidationErrors
+	for _, message := range problems {
+		errs = append(errs, ValidationError{Index: len(rules), RuleID: rule.ID, Message: message})
+	}
+	for i, existing := range rules {
+		if rule.ID != "" && existing.ID == rule.ID {
+			errs = append(errs, ValidationError{
+				Index:   len(rules),
+				RuleID:  rule.ID,
+				Message: fmt.Sprintf("duplicate rule ID, first defined at index %d", i),
+			})
+			break
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return compiled, nil
+}
+
+// validateRule returns the problems of a single rule, and its compiled
+// matcher if it is a valid rule of a custom type
+func validateRule(rule types.Rule) ([]string, matcher.Matcher) {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if rule.ID == "" {
+		addf("missing ID")
+	}
+	if rule.Action != types.ActionAllow && rule.Action != types.ActionBlock {
+		addf("invalid action: %q", rule.Action)
+	}
+	if rule.Mode != "" && rule.Mode != types.RuleModeEnforce && rule.Mode != types.RuleModeShadow {
+		addf("invalid mode: %q", rule.Mode)
+	}
+	if rule.TTL < 0 {
+		addf("ttl must not be negative")
+	}
+	if rule.Schedule != nil {
+		if _, err := compileSchedule(rule.Schedule); err != nil {
+			addf("invalid schedule: %v", err)
+		}
+	}
+
+	operators, known := ruleOperators[rule.Type]
+	if def, custom := matcher.Lookup(rule.Type); !known && custom {
+		compiled, customProblems := validateCustomRule(rule, def)
+		return append(problems, customProblems...), compiled
+	}
+	if !known {
+		if rule.Type == "" {
+			addf("missing type")
+		} else {
+			addf("unknown type: %q", rule.Type)
+		}
+		return problems, nil
+	}
+	if !hasOperator(operators, rule.Operator) {
+		addf("operator %q is not supported by rule type %s", rule.Operator, rule.Type)
+		return problems, nil
+	}
+
+	switch rule.Type {
+	case types.RuleTypeIPv4, types.RuleTypeIPv6:
+		problems = append(problems, validateIPRule(rule)...)
+	case types.RuleTypeSize:
+		problems = append(problems, validateSizeRule(rule)...)
+	case types.RuleTypeHeader:
+		if rule.HeaderName == "" {
+			addf("header rule requires header_name")
+		}
+	case types.RuleTypeJSONField, types.RuleTypeFormField:
+		if rule.Field == "" {
+			addf("%s rule requires field", rule.Type)
+		}
+	case types.RuleTypeASN:
+		switch positiveOperator(rule.Operator) {
+		case types.MatchEquals:
+			if _, err := parseASN(rule.Value); err != nil {
+				addf("invalid ASN %q", rule.Value)
+			}
+		case types.MatchIn:
+			for _, value := range rule.Values {
+				if _, err := parseASN(value); err != nil {
+					addf("invalid ASN %q", value)
+				}
+			}
+		}
+	}
+
+	switch {
+	case rule.Type == types.RuleTypeIPv4 || rule.Type == types.RuleTypeIPv6 || rule.Type == types.RuleTypeSize:
+	case rule.Type == types.RuleTypeExpression:
+		if rule.Value == "" {
+			addf("missing value")
+		} else if _, err := compileExpression(rule.Value); err != nil {
+			addf("invalid expression: %v", err)
+		}
+	case rule.Operator == types.MatchExists || rule.Operator == types.MatchNotExists:
+	case positiveOperator(rule.Operator) == types.MatchIn:
+		if len(rule.Values) == 0 {
+			addf("%s requires values", rule.Operator)
+		}
+	default:
+		if message := validatePattern(positiveOperator(rule.Operator), rulePattern(rule)); message != "" {
+			addf("%s", message)
+		}
+	}
+
+	return problems, nil
+}
+
+// validateCustomRule checks a rule of a type registered with pkg/matcher by
+// compiling it, and returns the compiled matcher
+func validateCustomRule(rule types.Rule, def matcher.Definition) (matcher.Matcher, []string) {
+	operators := def.Operators
+	if len(operators) == 0 {
+		operators = []types.MatchOperator{""}
+	}
+	if !hasOperator(operators, rule.Operator) {
+		return nil, []string{fmt.Sprintf("operator %q is not supported by rule type %s", rule.Operator, rule.Type)}
+	}
+	compiled, err := matcher.Compile(rule)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	return compiled, nil
+}
+
+// validateIPRule checks the address, range or set name of an IP rule
+func validateIPRule(rule types.Rule) []string {
+	wantV4 := rule.Type == types.RuleTypeIPv4
+
+	switch rule.Operator {
+	case types.MatchEquals:
+		ip := net.ParseIP(rule.Value)
+		if ip == nil {
+			return []string{fmt.Sprintf("invalid IP address %q", rule.Value)}
+		}
+		if (ip.To4() != nil) != wantV4 {
+			return []string{fmt.Sprintf("IP address %s does not match rule type %s", rule.Value, rule.Type)}
+		}
+	case types.MatchInRange:
+		ip, _, err := net.ParseCIDR(rule.Value)
+		if err != nil {
+			return []string{fmt.Sprintf("invalid CIDR range %q", rule.Value)}
+		}
+		if (ip.To4() != nil) != wantV4 {
+			return []string{fmt.Sprintf("CIDR range %s does not match rule type %s", rule.Value, rule.Type)}
+		}
+	case types.MatchInSet:
+		if rule.Value == "" {
+			return []string{"in_set requires an IP set name"}
+		}
+	}
+	return nil
+}
+
+// validateSizeRule checks that a size rule has the bounds its operator needs
+func validateSizeRule(rule types.Rule) []string {
+	switch rule.Operator {
+	case types.MatchGTE:
+		if rule.MinSize == nil {
+			return []string{"gte size rule requires min_size"}
+		}
+	case types.MatchLTE:
+		if rule.MaxSize == nil {
+			return []string{"lte size rule requires max_size"}
+		}
+	case types.MatchInRange:
+		if rule.MinSize == nil || rule.MaxSize == nil {
+			return []string{"in_range size rule requires min_size and max_size"}
+		}
+		if *rule.MinSize > *rule.MaxSize {
+			return []string{fmt.Sprintf("min_size %d is greater than max_size %d", *rule.MinSize, *rule.MaxSize)}
+		}
+	case types.MatchEquals:
+		if _, err := strconv.ParseInt(rule.Value, 10, 64); err != nil {
+			return []string{fmt.Sprintf("invalid size %q", rule.Value)}
+		}
+	}
+	return nil
+}
+
+// validatePattern checks the value of a string rule for its operator
+func validatePattern(operator types.MatchOperator, pattern string) string {
+	switch operator {
+	case types.MatchRegex:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Sprintf("invalid regex %q: %v", pattern, err)
+		}
+	case types.MatchWildcard:
+		if _, err := compileGlob(pattern, false, true); err != nil {
+			return fmt.Sprintf("invalid wildcard pattern %q: %v", pattern, err)
+		}
+	}
+	if pattern == "" {
+		return "missing value"
+	}
+	return ""
+}
+
+// rulePattern returns the value a string rule matches against
+func rulePattern(rule types.Rule) string {
+	if rule.Type == types.RuleTypeHeader {
+		return rule.HeaderValue
+	}
+	return rule.Value
+}
+
+func hasOperator(operators []types.MatchOperator, operator types.MatchOperator) bool {
+	for _, candidate := range operators {
+		if candidate == operator {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"http-proxy/pkg/types"
+)
+
+func TestValidateRules(t *testing.T) {
+	size := func(n int64) *int64 { return &n }
+	valid := types.Rule{ID: "valid", Type: types.RuleTypeURL, Operator: types.MatchStartsWith, Value: "/api", Action: types.ActionAllow}
+
+	tests := []struct {
+		name     string
+		rule     types.Rule
+		expected string // substring of the error, empty for a valid rule
+	}{
+		{"valid string rule", valid, ""},
+		{"missing ID", types.Rule{Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: types.ActionAllow}, "missing ID"},
+		{"missing type", types.Rule{ID: "r", Action: types.ActionAllow}, "missing type"},
+		{"unknown type", types.Rule{ID: "r", Type: "cookie", Action: types.ActionAllow}, `unknown type: "cookie"`},
+		{"invalid action", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: "drop"}, `invalid action: "drop"`},
+		{"invalid mode", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: types.ActionAllow, Mode: "audit"}, `invalid mode: "audit"`},
+		{"unsupported operator", types.Rule{ID: "r", Type: types.RuleTypeIPv4, Operator: types.MatchContains, Value: "10.", Action: types.ActionBlock}, "not supported by rule type ipv4"},
+		{"missing value", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchContains, Action: types.ActionBlock}, "missing value"},
+		{"invalid regex", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchRegex, Value: "([a-z", Action: types.ActionBlock}, "invalid regex"},
+		{"invalid wildcard", types.Rule{ID: "r", Type: types.RuleTypeURISuffix, Operator: types.MatchWildcard, Value: "[", Action: types.ActionBlock}, "invalid wildcard pattern"},
+		{"invalid header regex", types.Rule{ID: "r", Type: types.RuleTypeHeader, Operator: types.MatchRegex, HeaderName: "X-Id", HeaderValue: "(", Action: types.ActionBlock}, "invalid regex"},
+		{"header without name", types.Rule{ID: "r", Type: types.RuleTypeHeader, Operator: types.MatchEquals, HeaderValue: "x", Action: types.ActionBlock}, "requires header_name"},
+		{"body rule without field", types.Rule{ID: "r", Type: types.RuleTypeJSONField, Operator: types.MatchEquals, Value: "x", Action: types.ActionBlock}, "requires field"},
+		{"invalid IP", types.Rule{ID: "r", Type: types.RuleTypeIPv4, Operator: types.MatchEquals, Value: "10.0.0", Action: types.ActionBlock}, "invalid IP address"},
+		{"IP family mismatch", types.Rule{ID: "r", Type: types.RuleTypeIPv4, Operator: types.MatchEquals, Value: "::1", Action: types.ActionBlock}, "does not match rule type ipv4"},
+		{"invalid CIDR", types.Rule{ID: "r", Type: types.RuleTypeIPv6, Operator: types.MatchInRange, Value: "2001:db8::/200", Action: types.ActionBlock}, "invalid CIDR range"},
+		{"valid CIDR", types.Rule{ID: "r", Type: types.RuleTypeIPv6, Operator: types.MatchInRange, Value: "2001:db8::/32", Action: types.ActionBlock}, ""},
+		{"size gte without min", types.Rule{ID: "r", Type: types.RuleTypeSize, Operator: types.MatchGTE, Action: types.ActionBlock}, "requires min_size"},
+		{"size range inverted", types.Rule{ID: "r", Type: types.RuleTypeSize, Operator: types.MatchInRange, MinSize: size(10), MaxSize: size(5), Action: types.ActionBlock}, "greater than max_size"},
+		{"valid size rule", types.Rule{ID: "r", Type: types.RuleTypeSize, Operator: types.MatchLTE, MaxSize: size(5), Action: types.ActionBlock}, ""},
+		{"invalid ASN", types.Rule{ID: "r", Type: types.RuleTypeASN, Operator: types.MatchEquals, Value: "ASX", Action: types.ActionBlock}, "invalid ASN"},
+		{"invalid schedule", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: types.ActionBlock, Schedule: &types.Schedule{StartTime: "25:00"}}, "invalid schedule"},
+		{"in without values", types.Rule{ID: "r", Type: types.RuleTypeMethod, Operator: types.MatchIn, Action: types.ActionBlock}, "in requires values"},
+		{"invalid not_regex", types.Rule{ID: "r", Type: types.RuleTypeUserAgent, Operator: types.MatchNotRegex, Value: "(", Action: types.ActionBlock}, "invalid regex"},
+		{"exists on non-header rule", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchExists, Action: types.ActionBlock}, "not supported by rule type url"},
+		{"valid header exists", types.Rule{ID: "r", Type: types.RuleTypeHeader, Operator: types.MatchExists, HeaderName: "X-Debug", Action: types.ActionBlock}, ""},
+		{"invalid ASN in list", types.Rule{ID: "r", Type: types.RuleTypeASN, Operator: types.MatchNotIn, Values: []string{"AS1", "ASX"}, Action: types.ActionBlock}, "invalid ASN"},
+		{"valid expression", types.Rule{ID: "r", Type: types.RuleTypeExpression, Value: `method == "POST" && size > 1024`, Action: types.ActionBlock}, ""},
+		{"invalid expression", types.Rule{ID: "r", Type: types.RuleTypeExpression, Value: `size > "1024"`, Action: types.ActionBlock}, "invalid expression: column 6: cannot compare int with string"},
+		{"expression with operator", types.Rule{ID: "r", Type: types.RuleTypeExpression, Operator: types.MatchEquals, Value: "true", Action: types.ActionBlock}, "not supported by rule type expression"},
+		{"negative TTL", types.Rule{ID: "r", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/", Action: types.ActionBlock, TTL: -time.Minute}, "ttl must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRules([]types.Rule{tt.rule})
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateRules_Aggregated(t *testing.T) {
+	rules := []types.Rule{
+		{ID: "dup", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/a", Action: types.ActionAllow},
+		{ID: "bad-regex", Type: types.RuleTypeURL, Operator: types.MatchRegex, Value: "(", Action: types.ActionBlock},
+		{ID: "dup", Type: types.RuleTypeURL, Operator: types.MatchEquals, Value: "/b", Action: types.ActionAllow},
+		{ID: "bad-size", Type: types.RuleTypeSize, Operator: types.MatchGTE, Action: "drop"},
+	}
+
+	err := ValidateRules(rules)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	expected := []struct {
+		index  int
+		ruleID string
+	}{{1, "bad-regex"}, {2, "dup"}, {3, "bad-size"}, {3, "bad-size"}}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), err)
+	}
+	for i, e := range expected {
+		if errs[i].Index != e.index || errs[i].RuleID != e.ruleID {
+			t.Errorf("Expected error %d for rule %s at index %d, got %+v", i, e.ruleID, e.index, errs[i])
+		}
+	}
+
+	errs = errs.WithPositions("rules.yaml", []int{2, 8, 14})
+	if errs[0].Position != "rules.yaml:8" || errs[3].Position != "rules.yaml" {
+		t.Errorf("Unexpected positions: %q, %q", errs[0].Position, errs[3].Position)
+	}
+	if !strings.HasPrefix(errs[1].Error(), "rules.yaml:14: rule dup: duplicate rule ID") {
+		t.Errorf("Unexpected error format: %s", errs[1].Error())
+	}
+}
+
+func TestRuleLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		path     []string
+		expected []int
+	}{
+		{
+			name:     "YAML rules file",
+			filename: "rules.yaml",
+			data:     "rules:\n  - id: a\n    type: url\n\n  - id: b\n",
+			path:     []string{"rules"},
+			expected: []int{2, 5},
+		},
+		{
+			name:     "YAML config",
+			filename: "proxy.yml",
+			data:     "server:\n  port: 80\nrules:\n  default_action: allow\n  rules:\n    - id: a\n",
+			path:     []string{"rules", "rules"},
+			expected: []int{6},
+		},
+		{
+			name:     "JSON config",
+			filename: "proxy.json",
+			data:     "{\n  \"server\": {\"port\": 80},\n  \"rules\": {\n    \"rules\": [\n      {\"id\": \"a\"},\n\n      {\"id\": \"b\"}\n    ]\n  }\n}\n",
+			path:     []string{"rules", "rules"},
+			expected: []int{5, 7},
+		},
+		{
+			name:     "TOML config",
+			filename: "proxy.toml",
+			data:     "[rules]\ndefault_action = \"allow\"\n\n  [[rules.rules]]\n  id = \"a\"\n\n[[ rules.rules ]] # second\nid = \"b\"\n",
+			path:     []string{"rules", "rules"},
+			expected: []int{4, 7},
+		},
+		{
+			name:     "missing key",
+			filename: "rules.json",
+			data:     `{"other": []}`,
+			path:     []string{"rules"},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := RuleLines([]byte(tt.data), tt.filename, tt.path...)
+			if len(lines) != len(tt.expected) {
+				t.Fatalf("Expected lines %v, got %v", tt.expected, lines)
+			}
+			for i := range lines {
+				if lines[i] != tt.expected[i] {
+					t.Errorf("Expected lines %v, got %v", tt.expected, lines)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRuleOperators_BuiltinTypes(t *testing.T) {
+	if len(ruleOperators) != len(types.BuiltinRuleTypes) {
+		t.Errorf("Expected operators for %d built-in types, got %d", len(types.BuiltinRuleTypes), len(ruleOperators))
+	}
+	for _, ruleType := range types.BuiltinRuleTypes {
+		if _, exists := ruleOperators[ruleType]; !exists {
+			t.Errorf("Expected operators for built-in type %s", ruleType)
+		}
+	}
+}
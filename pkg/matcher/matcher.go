@@ -0,0 +1,169 @@
+// Package matcher lets programs embedding the proxy add custom rule types.
+//
+// A custom type is registered once, usually from an init function, and is then
+// accepted in YAML, JSON and TOML rule files and through the rules API like the
+// built-in types. Type-specific settings go in the rule's params:
+//
+//	rules:
+//	  - id: block-tor
+//	    type: tor_exit
+//	    params:
+//	      list_url: https://example.com/exits.txt
+//	    action: block
+package matcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"http-proxy/pkg/types"
+)
+
+// Matcher matches requests against one compiled rule
+type Matcher interface {
+	// Match reports whether the request matches and why
+	Match(req *types.RequestInfo) (bool, string)
+}
+
+// MatcherFunc adapts a function to the Matcher interface
+type MatcherFunc func(req *types.RequestInfo) (bool, string)
+
+// Match calls f(req)
+func (f MatcherFunc) Match(req *types.RequestInfo) (bool, string) {
+	return f(req)
+}
+
+// Definition describes a custom rule type
+type Definition struct {
+	// Operators lists the operators rules of the type accept. Leave it
+	// empty for types that take no operator.
+	Operators []types.MatchOperator
+
+	// NewParams returns a pointer to the type's parameter struct. When set,
+	// the rule's params are decoded into it, rejecting unknown keys, and the
+	// result is passed to Validate and Compile.
+	NewParams func() interface{}
+
+	// Validate checks a rule before it is compiled (optional)
+	Validate func(rule types.Rule, params interface{}) error
+
+	// Compile builds the matcher of a rule
+	Compile func(rule types.Rule, params interface{}) (Matcher, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[types.RuleType]Definition)
+)
+
+// Register adds a custom rule type. Built-in and already registered types
+// cannot be replaced.
+func Register(name types.RuleType, def Definition) error {
+	if name == "" {
+		return fmt.Errorf("rule type name is empty")
+	}
+	if def.Compile == nil {
+		return fmt.Errorf("rule type %s has no Compile function", name)
+	}
+	for _, builtin := range types.BuiltinRuleTypes {
+		if name == builtin {
+			return fmt.Errorf("rule type %s is built in", name)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("rule type %s is already registered", name)
+	}
+	registry[name] = def
+	return nil
+}
+
+// MustRegister is like Register but panics on error
+func MustRegister(name types.RuleType, def Definition) {
+	if err := Register(name, def); err != nil {
+		panic(err)
+	}
+}
+
+// Unregister removes a custom rule type
+func Unregister(name types.RuleType) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(registry, name)
+}
+
+// Lookup returns the definition of a registered rule type
+func Lookup(name types.RuleType) (Definition, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	def, exists := registry[name]
+	return def, exists
+}
+
+// Registered returns the names of all registered rule types, sorted
+func Registered() []types.RuleType {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]types.RuleType, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Compile decodes the params of a rule of a registered type, validates the
+// rule and compiles its matcher
+func Compile(rule types.Rule) (Matcher, error) {
+	def, exists := Lookup(rule.Type)
+	if !exists {
+		return nil, fmt.Errorf("unknown type: %q", rule.Type)
+	}
+
+	var params interface{}
+	if def.NewParams != nil {
+		params = def.NewParams()
+		if err := DecodeParams(rule.Params, params); err != nil {
+			return nil, err
+		}
+	} else if len(rule.Params) > 0 {
+		return nil, fmt.Errorf("rule type %s takes no params", rule.Type)
+	}
+
+	if def.Validate != nil {
+		if err := def.Validate(rule, params); err != nil {
+			return nil, err
+		}
+	}
+
+	matcher, err := def.Compile(rule, params)
+	if err != nil {
+		return nil, err
+	}
+	if matcher == nil {
+		return nil, fmt.Errorf("rule type %s compiled to no matcher", rule.Type)
+	}
+	return matcher, nil
+}
+
+// DecodeParams decodes rule params into target, a pointer to a struct with
+// json tags. Unknown keys are rejected.
+func DecodeParams(params map[string]interface{}, target interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"http-proxy/pkg/types"
+)
+
+type prefixParams struct {
+	Prefix string `json:"prefix"`
+}
+
+func registerPrefix(t *testing.T, name types.RuleType) {
+	err := Register(name, Definition{
+		NewParams: func() interface{} { return &prefixParams{} },
+		Validate: func(rule types.Rule, params interface{}) error {
+			if params.(*prefixParams).Prefix == "" {
+				return fmt.Errorf("prefix is required")
+			}
+			return nil
+		},
+		Compile: func(rule types.Rule, params interface{}) (Matcher, error) {
+			prefix := params.(*prefixParams).Prefix
+			return MatcherFunc(func(req *types.RequestInfo) (bool, string) {
+				return strings.HasPrefix(req.Path, prefix), "path prefix " + prefix
+			}), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register %s: %v", name, err)
+	}
+	t.Cleanup(func() { Unregister(name) })
+}
+
+func TestRegister(t *testing.T) {
+	registerPrefix(t, "path_prefix")
+
+	tests := []struct {
+		name     string
+		ruleType types.RuleType
+		def      Definition
+		expected string
+	}{
+		{"built-in type", types.RuleTypeURL, Definition{Compile: func(types.Rule, interface{}) (Matcher, error) { return nil, nil }}, "is built in"},
+		{"duplicate type", "path_prefix", Definition{Compile: func(types.Rule, interface{}) (Matcher, error) { return nil, nil }}, "already registered"},
+		{"missing compile", "no_compile", Definition{}, "no Compile function"},
+		{"empty name", "", Definition{}, "name is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Register(tt.ruleType, tt.def)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if registered := Registered(); len(registered) != 1 || registered[0] != "path_prefix" {
+		t.Errorf("Expected path_prefix to be registered, got %v", registered)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	registerPrefix(t, "path_prefix")
+
+	matcher, err := Compile(types.Rule{ID: "r", Type: "path_prefix", Params: map[string]interface{}{"prefix": "/internal"}})
+	if err != nil {
+		t.Fatalf("Failed to compile rule: %v", err)
+	}
+	if matched, _ := matcher.Match(&types.RequestInfo{Path: "/internal/metrics"}); !matched {
+		t.Error("Expected matcher to match")
+	}
+
+	tests := []struct {
+		name     string
+		rule     types.Rule
+		expected string
+	}{
+		{"unknown type", types.Rule{Type: "nope"}, `unknown type: "nope"`},
+		{"unknown param", types.Rule{Type: "path_prefix", Params: map[string]interface{}{"prefix": "/a", "suffix": "b"}}, `unknown field "suffix"`},
+		{"wrong param type", types.Rule{Type: "path_prefix", Params: map[string]interface{}{"prefix": 1}}, "invalid params"},
+		{"failed validation", types.Rule{Type: "path_prefix"}, "prefix is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.rule)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -17,15 +17,35 @@ const (
 type RuleType string
 
 const (
-	RuleTypeIPv4      RuleType = "ipv4"
-	RuleTypeIPv6      RuleType = "ipv6"
-	RuleTypeURL       RuleType = "url"
-	RuleTypeDomain    RuleType = "domain"
-	RuleTypeUserAgent RuleType = "user_agent"
-	RuleTypeURISuffix RuleType = "uri_suffix"
-	RuleTypeSize      RuleType = "size"
-	RuleTypeMethod    RuleType = "method"
-	RuleTypeHeader    RuleType = "header"
+	RuleTypeIPv4       RuleType = "ipv4"
+	RuleTypeIPv6       RuleType = "ipv6"
+	RuleTypeURL        RuleType = "url"
+	RuleTypeDomain     RuleType = "domain"
+	RuleTypeUserAgent  RuleType = "user_agent"
+	RuleTypeURISuffix  RuleType = "uri_suffix"
+	RuleTypeSize       RuleType = "size"
+	RuleTypeMethod     RuleType = "method"
+	RuleTypeHeader     RuleType = "header"
+	RuleTypeJSONField  RuleType = "json_field"
+	RuleTypeFormField  RuleType = "form_field"
+	RuleTypeCountry    RuleType = "geo_country"
+	RuleTypeASN        RuleType = "asn"
+	RuleTypeExpression RuleType = "expression" // Value holds a boolean expression over the request
+)
+
+// BuiltinRuleTypes lists the rule types implemented by the rules engine
+var BuiltinRuleTypes = []RuleType{
+	RuleTypeIPv4, RuleTypeIPv6, RuleTypeURL, RuleTypeDomain, RuleTypeUserAgent,
+	RuleTypeURISuffix, RuleTypeSize, RuleTypeMethod, RuleTypeHeader, RuleTypeJSONField,
+	RuleTypeFormField, RuleTypeCountry, RuleTypeASN, RuleTypeExpression,
+}
+
+// RuleMode defines whether a rule's action is applied
+type RuleMode string
+
+const (
+	RuleModeEnforce RuleMode = "enforce"
+	RuleModeShadow  RuleMode = "shadow" // matches are recorded but do not decide the request
 )
 
 // MatchOperator defines how to match the rule
@@ -41,6 +61,20 @@ const (
 	MatchGTE        MatchOperator = "gte" // Greater than or equal (for size)
 	MatchLTE        MatchOperator = "lte" // Less than or equal (for size)
 	MatchInRange    MatchOperator = "in_range"
+	MatchInSet      MatchOperator = "in_set" // Value names an IP set
+
+	// Negated string operators match when the operator they negate does not
+	MatchNotEquals     MatchOperator = "not_equals"
+	MatchNotContains   MatchOperator = "not_contains"
+	MatchNotStartsWith MatchOperator = "not_starts_with"
+	MatchNotEndsWith   MatchOperator = "not_ends_with"
+	MatchNotRegex      MatchOperator = "not_regex"
+	MatchNotWildcard   MatchOperator = "not_wildcard"
+
+	MatchIn        MatchOperator = "in"         // equals one of Values
+	MatchNotIn     MatchOperator = "not_in"     // equals none of Values
+	MatchExists    MatchOperator = "exists"     // header is present (for headers)
+	MatchNotExists MatchOperator = "not_exists" // header is absent (for headers)
 )
 
 // Rule represents a filtering rule
@@ -51,9 +85,14 @@ type Rule struct {
 	Type        RuleType      `yaml:"type" json:"type" toml:"type"`
 	Operator    MatchOperator `yaml:"operator" json:"operator" toml:"operator"`
 	Value       string        `yaml:"value" json:"value" toml:"value"`
+	Values      []string      `yaml:"values,omitempty" json:"values,omitempty" toml:"values,omitempty"` // for in and not_in
 	Action      Action        `yaml:"action" json:"action" toml:"action"`
 	Priority    int           `yaml:"priority" json:"priority" toml:"priority"`
 	Enabled     bool          `yaml:"enabled" json:"enabled" toml:"enabled"`
+	Mode        RuleMode      `yaml:"mode,omitempty" json:"mode,omitempty" toml:"mode,omitempty"` // defaults to enforce
+
+	// String operators compare case-insensitively unless CaseSensitive is true
+	CaseSensitive *bool `yaml:"case_sensitive,omitempty" json:"case_sensitive,omitempty" toml:"case_sensitive,omitempty"`
 
 	// For size-based rules
 	MinSize *int64 `yaml:"min_size,omitempty" json:"min_size,omitempty" toml:"min_size,omitempty"`
@@ -62,6 +101,31 @@ type Rule struct {
 	// For header-based rules
 	HeaderName  string `yaml:"header_name,omitempty" json:"header_name,omitempty" toml:"header_name,omitempty"`
 	HeaderValue string `yaml:"header_value,omitempty" json:"header_value,omitempty" toml:"header_value,omitempty"`
+
+	// For body inspection rules (JSON path such as "user.roles.0" or form key)
+	Field string `yaml:"field,omitempty" json:"field,omitempty" toml:"field,omitempty"`
+
+	// Parameters of custom rule types registered with pkg/matcher
+	Params map[string]interface{} `yaml:"params,omitempty" json:"params,omitempty" toml:"params,omitempty"`
+
+	// Optional time restrictions; the rule is skipped outside its schedule
+	Schedule *Schedule `yaml:"schedule,omitempty" json:"schedule,omitempty" toml:"schedule,omitempty"`
+
+	// Temporary rules: TTL is converted to ExpiresAt when the rule is added or loaded
+	ExpiresAt *time.Time    `yaml:"expires_at,omitempty" json:"expires_at,omitempty" toml:"expires_at,omitempty"`
+	TTL       time.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty" toml:"ttl,omitempty"`
+}
+
+// Schedule restricts when a rule applies. All given conditions must hold.
+type Schedule struct {
+	Timezone  string   `yaml:"timezone,omitempty" json:"timezone,omitempty" toml:"timezone,omitempty"`       // IANA name, defaults to UTC
+	Cron      string   `yaml:"cron,omitempty" json:"cron,omitempty" toml:"cron,omitempty"`                   // five-field expression; active during matching minutes
+	Days      []string `yaml:"days,omitempty" json:"days,omitempty" toml:"days,omitempty"`                   // weekdays such as "mon", "fri"
+	StartTime string   `yaml:"start_time,omitempty" json:"start_time,omitempty" toml:"start_time,omitempty"` // "HH:MM", inclusive
+	EndTime   string   `yaml:"end_time,omitempty" json:"end_time,omitempty" toml:"end_time,omitempty"`       // "HH:MM", exclusive; before start_time for overnight windows
+
+	NotBefore *time.Time `yaml:"not_before,omitempty" json:"not_before,omitempty" toml:"not_before,omitempty"`
+	NotAfter  *time.Time `yaml:"not_after,omitempty" json:"not_after,omitempty" toml:"not_after,omitempty"`
 }
 
 // ProxyConfig represents the main proxy configuration
@@ -71,6 +135,7 @@ type ProxyConfig struct {
 	Rules    RulesConfig    `yaml:"rules" json:"rules" toml:"rules"`
 	Logging  LoggingConfig  `yaml:"logging" json:"logging" toml:"logging"`
 	Security SecurityConfig `yaml:"security,omitempty" json:"security,omitempty" toml:"security,omitempty"`
+	GeoIP    GeoIPConfig    `yaml:"geoip,omitempty" json:"geoip,omitempty" toml:"geoip,omitempty"`
 }
 
 // ServerConfig represents proxy server configuration
@@ -81,6 +146,23 @@ type ServerConfig struct {
 	WriteTimeout   time.Duration `yaml:"write_timeout" json:"write_timeout" toml:"write_timeout"`
 	IdleTimeout    time.Duration `yaml:"idle_timeout" json:"idle_timeout" toml:"idle_timeout"`
 	MaxHeaderBytes int           `yaml:"max_header_bytes" json:"max_header_bytes" toml:"max_header_bytes"`
+
+	// CIDR ranges or addresses of load balancers and proxies whose
+	// Forwarded, X-Forwarded-For and X-Real-IP headers are trusted
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty" toml:"trusted_proxies,omitempty"`
+
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol,omitempty" json:"proxy_protocol,omitempty" toml:"proxy_protocol,omitempty"`
+}
+
+// ProxyProtocolConfig configures HAProxy PROXY protocol support on the listener
+type ProxyProtocolConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled" toml:"enabled"`
+
+	// CIDR ranges or addresses of load balancers that send PROXY headers
+	AllowedSources []string `yaml:"allowed_sources" json:"allowed_sources" toml:"allowed_sources"`
+
+	// Maximum time to wait for the header after accepting a connection
+	HeaderTimeout time.Duration `yaml:"header_timeout" json:"header_timeout" toml:"header_timeout"`
 }
 
 // BackendConfig represents backend server configuration
@@ -106,8 +188,93 @@ type RulesConfig struct {
 	Rules          []Rule        `yaml:"rules" json:"rules" toml:"rules"`
 	DefaultAction  Action        `yaml:"default_action" json:"default_action" toml:"default_action"`
 	RulesFile      string        `yaml:"rules_file,omitempty" json:"rules_file,omitempty" toml:"rules_file,omitempty"`
+	RulesDir       string        `yaml:"rules_dir,omitempty" json:"rules_dir,omitempty" toml:"rules_dir,omitempty"` // directory or glob of rule files merged into the rules
 	WatchRulesFile bool          `yaml:"watch_rules_file" json:"watch_rules_file" toml:"watch_rules_file"`
+	ShadowMode     bool          `yaml:"shadow_mode,omitempty" json:"shadow_mode,omitempty" toml:"shadow_mode,omitempty"` // run every rule in shadow mode
 	ReloadInterval time.Duration `yaml:"reload_interval" json:"reload_interval" toml:"reload_interval"`
+
+	// LegacyCaseMatching restores the old string matching: equals, wildcard and regex
+	// case-sensitive, other operators case-insensitive, and no field normalization
+	LegacyCaseMatching bool `yaml:"legacy_case_matching,omitempty" json:"legacy_case_matching,omitempty" toml:"legacy_case_matching,omitempty"`
+
+	BodyInspection BodyInspectionConfig `yaml:"body_inspection,omitempty" json:"body_inspection,omitempty" toml:"body_inspection,omitempty"`
+	IPSets         []IPSetConfig        `yaml:"ip_sets,omitempty" json:"ip_sets,omitempty" toml:"ip_sets,omitempty"`
+
+	// Expired rules are removed, or only disabled when ExpiredRuleAction is "disable"
+	ExpiredRuleAction   string        `yaml:"expired_rule_action,omitempty" json:"expired_rule_action,omitempty" toml:"expired_rule_action,omitempty"`
+	ExpiryCheckInterval time.Duration `yaml:"expiry_check_interval,omitempty" json:"expiry_check_interval,omitempty" toml:"expiry_check_interval,omitempty"`
+
+	// Number of ruleset versions kept for diffs and rollback
+	HistorySize int `yaml:"history_size,omitempty" json:"history_size,omitempty" toml:"history_size,omitempty"`
+
+	// Number of timestamped backups kept when the rules file is rewritten; -1 keeps none
+	FileBackups int `yaml:"file_backups,omitempty" json:"file_backups,omitempty" toml:"file_backups,omitempty"`
+}
+
+// RuleSetSource describes what produced a version of the ruleset
+type RuleSetSource string
+
+const (
+	RuleSetSourceConfig   RuleSetSource = "config"
+	RuleSetSourceFile     RuleSetSource = "file"
+	RuleSetSourceAPI      RuleSetSource = "api"
+	RuleSetSourceExpiry   RuleSetSource = "expiry"
+	RuleSetSourceRollback RuleSetSource = "rollback"
+)
+
+// RuleSetVersion describes one version of the active ruleset
+type RuleSetVersion struct {
+	Version   int           `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	Source    RuleSetSource `json:"source"`
+	Hash      string        `json:"hash"` // SHA-256 of the rules, independent of their order
+	RuleCount int           `json:"rule_count"`
+}
+
+// RuleSetDiff lists the rules added, removed and changed between two versions
+type RuleSetDiff struct {
+	From    int          `json:"from"`
+	To      int          `json:"to"`
+	Added   []Rule       `json:"added"`
+	Removed []Rule       `json:"removed"`
+	Changed []RuleChange `json:"changed"`
+}
+
+// RuleFileStatus describes one file of the rules directory
+type RuleFileStatus struct {
+	Path      string `json:"path"`
+	Namespace string `json:"namespace"`
+	RuleCount int    `json:"rule_count"`
+	Error     string `json:"error,omitempty"` // why the file's latest content is not in effect
+}
+
+// RuleChange is a rule that differs between two ruleset versions
+type RuleChange struct {
+	ID     string `json:"id"`
+	Before Rule   `json:"before"`
+	After  Rule   `json:"after"`
+}
+
+// Values for RulesConfig.ExpiredRuleAction
+const (
+	ExpiredRuleRemove  = "remove"
+	ExpiredRuleDisable = "disable"
+)
+
+// IPSetConfig represents a named IP set loaded from a file with one CIDR per line
+type IPSetConfig struct {
+	Name string `yaml:"name" json:"name" toml:"name"`
+	File string `yaml:"file" json:"file" toml:"file"`
+}
+
+// BodyInspectionConfig represents request body inspection configuration
+type BodyInspectionConfig struct {
+	MaxBodySize int64 `yaml:"max_body_size" json:"max_body_size" toml:"max_body_size"` // bytes buffered for inspection
+
+	// Actions taken by body rules when the body cannot be inspected.
+	// An empty action skips the rule instead.
+	OversizeAction   Action `yaml:"oversize_action,omitempty" json:"oversize_action,omitempty" toml:"oversize_action,omitempty"`
+	ParseErrorAction Action `yaml:"parse_error_action,omitempty" json:"parse_error_action,omitempty" toml:"parse_error_action,omitempty"`
 }
 
 // LoggingConfig represents logging configuration
@@ -137,17 +304,36 @@ type RateLimitConfig struct {
 	CleanupInterval time.Duration `yaml:"cleanup_interval" json:"cleanup_interval" toml:"cleanup_interval"`
 }
 
+// GeoIPConfig represents GeoIP database configuration
+type GeoIPConfig struct {
+	CountryDatabase string        `yaml:"country_database,omitempty" json:"country_database,omitempty" toml:"country_database,omitempty"` // MaxMind-format (.mmdb) file
+	ASNDatabase     string        `yaml:"asn_database,omitempty" json:"asn_database,omitempty" toml:"asn_database,omitempty"`
+	ReloadInterval  time.Duration `yaml:"reload_interval" json:"reload_interval" toml:"reload_interval"`
+}
+
+// GeoInfo represents the resolved location of a client IP
+type GeoInfo struct {
+	Country        string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN            uint32 `json:"asn,omitempty"`
+	ASOrganization string `json:"as_organization,omitempty"`
+}
+
 // RequestInfo represents information about an HTTP request for rule evaluation
 type RequestInfo struct {
-	Method     string
-	URL        string
-	Domain     string
-	Path       string
-	Headers    map[string][]string
-	UserAgent  string
-	ClientIP   net.IP
-	Size       int64
-	RemoteAddr string
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Domain     string              `json:"domain"`
+	Path       string              `json:"path"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	UserAgent  string              `json:"user_agent"`
+	ClientIP   net.IP              `json:"client_ip"`         // derived through trusted proxies
+	PeerIP     net.IP              `json:"peer_ip,omitempty"` // address of the connecting peer
+	Size       int64               `json:"size"`
+	RemoteAddr string              `json:"remote_addr,omitempty"`
+
+	// Buffered request body for body inspection rules
+	Body          []byte `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
 }
 
 // RuleResult represents the result of rule evaluation
@@ -156,6 +342,57 @@ type RuleResult struct {
 	Matched bool
 	Action  Action
 	Reason  string
+	Geo     *GeoInfo // resolved client location, if GeoIP is configured
+
+	// First shadow-mode rule that matched before the decision was made
+	ShadowRule   *Rule
+	ShadowReason string
+
+	// Version of the ruleset the request was evaluated against
+	RuleSetVersion int
+}
+
+// TraceStatus describes what happened to a rule while explaining a request
+type TraceStatus string
+
+const (
+	TraceMatched     TraceStatus = "matched"
+	TraceNoMatch     TraceStatus = "no_match"
+	TraceShadowMatch TraceStatus = "shadow_match"
+	TraceDisabled    TraceStatus = "disabled"
+	TraceInactive    TraceStatus = "inactive"
+	TraceSkipped     TraceStatus = "skipped"
+)
+
+// RuleTrace records how a single rule was evaluated against a request
+type RuleTrace struct {
+	RuleID   string      `json:"rule_id"`
+	Name     string      `json:"name,omitempty"`
+	Priority int         `json:"priority"`
+	Status   TraceStatus `json:"status"`
+	Action   Action      `json:"action,omitempty"`
+	Reason   string      `json:"reason,omitempty"`
+}
+
+// Explanation is the outcome of evaluating a request together with the
+// trace of every rule in priority order
+type Explanation struct {
+	Action       Action      `json:"action"`
+	Matched      bool        `json:"matched"`
+	RuleID       string      `json:"rule_id,omitempty"`
+	Reason       string      `json:"reason"`
+	ShadowRuleID string      `json:"shadow_rule_id,omitempty"`
+	Geo          *GeoInfo    `json:"geo,omitempty"`
+	Trace        []RuleTrace `json:"trace"`
+}
+
+// RuleStats represents match statistics of a single rule
+type RuleStats struct {
+	RuleID        string     `json:"rule_id"`
+	Matches       int64      `json:"matches"`
+	ShadowMatches int64      `json:"shadow_matches"`
+	LastMatched   *time.Time `json:"last_matched,omitempty"`
+	LastClientIP  string     `json:"last_client_ip,omitempty"`
 }
 
 // ProxyStats represents proxy statistics